@@ -0,0 +1,197 @@
+package elastic_operation
+
+import (
+	"bufio"
+	"bytes"
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"io"
+	"time"
+)
+
+const maxBulkLineSize = 1024 * 1024
+
+// bulkValidate checks the document lines of create and index actions against
+// the binding tags of model.CreateRequest.
+var bulkValidate = newBulkValidate()
+
+func newBulkValidate() *validator.Validate {
+	validate := validator.New()
+	validate.SetTagName("binding")
+	return validate
+}
+
+// bulkLineError reports an NDJSON line that could not be turned into an
+// operation. Reading stops at the first one, except for a document that does
+// not validate, which only rejects its own item.
+type bulkLineError struct {
+	Line int
+	Err  error
+}
+
+func (e *bulkLineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// bulkReader reads Elasticsearch style bulk NDJSON: an action line followed
+// by a document line for create, index and update.
+type bulkReader struct {
+	scanner    *bufio.Scanner
+	line       int
+	actor      string
+	softDelete bool
+}
+
+// newBulkReader reads the actions of body. With softDelete, delete actions
+// mark users as deleted instead of removing them.
+func newBulkReader(body io.Reader, actor string, softDelete bool) *bulkReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxBulkLineSize)
+	return &bulkReader{scanner: scanner, actor: actor, softDelete: softDelete}
+}
+
+func (r *bulkReader) readLine() ([]byte, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) > 0 {
+			return line, nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, &bulkLineError{Line: r.line + 1, Err: err}
+	}
+	return nil, io.EOF
+}
+
+func (r *bulkReader) next() (elasticsearch.BulkOperation, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return elasticsearch.BulkOperation{}, err
+	}
+
+	var action map[string]model.BulkActionMeta
+	if err := json.Unmarshal(line, &action); err != nil {
+		return elasticsearch.BulkOperation{}, &bulkLineError{Line: r.line, Err: err}
+	}
+	if len(action) != 1 {
+		return elasticsearch.BulkOperation{}, &bulkLineError{Line: r.line, Err: fmt.Errorf("expected exactly one action")}
+	}
+
+	for name, meta := range action {
+		switch name {
+		case elasticsearch.BulkCreate, elasticsearch.BulkIndex:
+			var req model.CreateRequest
+			if err := r.readDocument(&req); err != nil {
+				return elasticsearch.BulkOperation{}, err
+			}
+			if meta.ID == "" {
+				meta.ID = uuid.New().String()
+			}
+			if err := bulkValidate.Struct(req); err != nil {
+				return elasticsearch.BulkOperation{Action: name, User: elasticsearch.UserInfo{ID: meta.ID},
+					Rejected: &bulkLineError{Line: r.line, Err: err}}, nil
+			}
+			cr := time.Now().UTC()
+			return elasticsearch.BulkOperation{Action: name, User: elasticsearch.UserInfo{
+				ID:         meta.ID,
+				Name:       req.Name,
+				Job:        req.Job,
+				ChildNames: req.ChildNames,
+				Comment:    req.Comment,
+				CreatedAt:  &cr,
+				UpdatedAt:  &cr,
+				CreatedBy:  r.actor,
+				UpdatedBy:  r.actor,
+				Revision:   1,
+			}}, nil
+		case elasticsearch.BulkUpdate:
+			var req model.BulkUpdateDocument
+			if err := r.readDocument(&req); err != nil {
+				return elasticsearch.BulkOperation{}, err
+			}
+			if meta.ID == "" {
+				return elasticsearch.BulkOperation{}, &bulkLineError{Line: r.line, Err: fmt.Errorf("update requires _id")}
+			}
+			doc, err := bulkUpdateFields(req.Doc)
+			if err != nil {
+				return elasticsearch.BulkOperation{Action: name, User: elasticsearch.UserInfo{ID: meta.ID},
+					Rejected: &bulkLineError{Line: r.line, Err: err}}, nil
+			}
+			doc["updated_at"] = time.Now().UTC()
+			doc["updated_by"] = r.actor
+			return elasticsearch.BulkOperation{Action: name, User: elasticsearch.UserInfo{ID: meta.ID}, Doc: doc}, nil
+		case elasticsearch.BulkDelete:
+			if meta.ID == "" {
+				return elasticsearch.BulkOperation{}, &bulkLineError{Line: r.line, Err: fmt.Errorf("delete requires _id")}
+			}
+			if r.softDelete {
+				del := time.Now().UTC()
+				return elasticsearch.BulkOperation{Action: name, Soft: true, User: elasticsearch.UserInfo{
+					ID:        meta.ID,
+					DeletedAt: &del,
+					UpdatedBy: r.actor,
+				}}, nil
+			}
+			return elasticsearch.BulkOperation{Action: name, User: elasticsearch.UserInfo{ID: meta.ID}}, nil
+		default:
+			return elasticsearch.BulkOperation{}, &bulkLineError{Line: r.line, Err: fmt.Errorf("unknown action %q", name)}
+		}
+	}
+
+	return elasticsearch.BulkOperation{}, io.EOF
+}
+
+func (r *bulkReader) readDocument(v interface{}) error {
+	line, err := r.readLine()
+	if err == io.EOF {
+		return &bulkLineError{Line: r.line + 1, Err: fmt.Errorf("missing document line")}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(line, v); err != nil {
+		return &bulkLineError{Line: r.line, Err: err}
+	}
+
+	return nil
+}
+
+// bulkUpdateFields decodes the members of the doc of an update action. Only
+// the fields of model.UpdateRequest can be set, each with its own type, and
+// name and job must not be emptied; fields left out keep their value.
+func bulkUpdateFields(doc map[string]json.RawMessage) (map[string]interface{}, error) {
+	if len(doc) == 0 {
+		return nil, fmt.Errorf("update requires a doc with at least one field")
+	}
+
+	fields := make(map[string]interface{}, len(doc)+2)
+	for field, raw := range doc {
+		switch field {
+		case "name", "job", "comment":
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, fmt.Errorf("field %s: %w", field, err)
+			}
+			if value == "" && field != "comment" {
+				return nil, fmt.Errorf("field %s must not be empty", field)
+			}
+			fields[field] = value
+		case "childNames":
+			var value []string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, fmt.Errorf("field %s: %w", field, err)
+			}
+			fields[field] = value
+		default:
+			return nil, fmt.Errorf("field %s cannot be updated", field)
+		}
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,108 @@
+package elastic_operation
+
+import (
+	"elastic-project/client/elasticsearch"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBulkReaderNext(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantAction   string
+		wantRejected bool
+		wantDoc      map[string]interface{}
+		wantErr      bool
+	}{
+		{
+			name:       "create",
+			body:       `{"create":{"_id":"1"}}` + "\n" + `{"name":"Ahmet","job":"Doctor"}`,
+			wantAction: elasticsearch.BulkCreate,
+		},
+		{
+			name:         "create without job",
+			body:         `{"create":{"_id":"1"}}` + "\n" + `{"name":"Ahmet"}`,
+			wantAction:   elasticsearch.BulkCreate,
+			wantRejected: true,
+		},
+		{
+			name:       "update of one field",
+			body:       `{"update":{"_id":"1"}}` + "\n" + `{"doc":{"comment":"x"}}`,
+			wantAction: elasticsearch.BulkUpdate,
+			wantDoc:    map[string]interface{}{"comment": "x"},
+		},
+		{
+			name:       "update of child names",
+			body:       `{"update":{"_id":"1"}}` + "\n" + `{"doc":{"childNames":["Ali"],"job":"Architect"}}`,
+			wantAction: elasticsearch.BulkUpdate,
+			wantDoc:    map[string]interface{}{"childNames": []string{"Ali"}, "job": "Architect"},
+		},
+		{
+			name:         "update of an unknown field",
+			body:         `{"update":{"_id":"1"}}` + "\n" + `{"doc":{"revision":7}}`,
+			wantAction:   elasticsearch.BulkUpdate,
+			wantRejected: true,
+		},
+		{
+			name:         "update emptying the name",
+			body:         `{"update":{"_id":"1"}}` + "\n" + `{"doc":{"name":""}}`,
+			wantAction:   elasticsearch.BulkUpdate,
+			wantRejected: true,
+		},
+		{
+			name:         "update with a mistyped field",
+			body:         `{"update":{"_id":"1"}}` + "\n" + `{"doc":{"childNames":"Ali"}}`,
+			wantAction:   elasticsearch.BulkUpdate,
+			wantRejected: true,
+		},
+		{
+			name:         "update without doc",
+			body:         `{"update":{"_id":"1"}}` + "\n" + `{"name":"Ahmet"}`,
+			wantAction:   elasticsearch.BulkUpdate,
+			wantRejected: true,
+		},
+		{
+			name:    "update without id",
+			body:    `{"update":{}}` + "\n" + `{"doc":{"comment":"x"}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newBulkReader(strings.NewReader(tt.body), "tester", false)
+
+			operation, err := reader.next()
+			if tt.wantErr {
+				if err == nil || err == io.EOF {
+					t.Fatalf("next() error = %v, want a line error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("next() error = %v", err)
+			}
+			if operation.Action != tt.wantAction {
+				t.Errorf("action = %s, want %s", operation.Action, tt.wantAction)
+			}
+			if (operation.Rejected != nil) != tt.wantRejected {
+				t.Fatalf("rejected = %v, want rejected %v", operation.Rejected, tt.wantRejected)
+			}
+			if tt.wantDoc == nil {
+				return
+			}
+
+			if operation.Doc["updated_by"] != "tester" || operation.Doc["updated_at"] == nil {
+				t.Errorf("doc = %v, want updated_at and updated_by", operation.Doc)
+			}
+			delete(operation.Doc, "updated_at")
+			delete(operation.Doc, "updated_by")
+			if !reflect.DeepEqual(operation.Doc, tt.wantDoc) {
+				t.Errorf("doc = %v, want %v", operation.Doc, tt.wantDoc)
+			}
+		})
+	}
+}
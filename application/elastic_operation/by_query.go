@@ -0,0 +1,188 @@
+package elastic_operation
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"time"
+)
+
+// byQuerySampleSize is how many matching users a dry run returns.
+const byQuerySampleSize = 10
+
+func (s elasticsearchService) UpdateByQuery(ctx context.Context, req model.UpdateByQueryRequest) (model.ByQueryResponse, error) {
+	query, err := byQueryClause(req.Query, req.Filters)
+	if err != nil {
+		return model.ByQueryResponse{}, err
+	}
+
+	set, err := assignments(req.Set)
+	if err != nil {
+		return model.ByQueryResponse{}, err
+	}
+
+	if req.DryRun {
+		return s.dryRun(query)
+	}
+
+	result, err := s.storage.UpdateByQuery(ctx, query, set, req.RequestsPerSecond)
+	if err != nil {
+		return model.ByQueryResponse{}, err
+	}
+
+	return toByQueryResponse(result), nil
+}
+
+// DeleteByQuery removes the matching users, or with soft delete marks them
+// as deleted.
+func (s elasticsearchService) DeleteByQuery(ctx context.Context, req model.DeleteByQueryRequest) (model.ByQueryResponse, error) {
+	query, err := byQueryClause(req.Query, req.Filters)
+	if err != nil {
+		return model.ByQueryResponse{}, err
+	}
+
+	if req.DryRun {
+		return s.dryRun(query)
+	}
+
+	if s.softDelete {
+		now := time.Now().UTC()
+		result, err := s.storage.UpdateByQuery(ctx, query, map[string]interface{}{"deleted_at": now}, req.RequestsPerSecond)
+		if err != nil {
+			return model.ByQueryResponse{}, err
+		}
+		result.Deleted, result.Updated = result.Updated, 0
+		return toByQueryResponse(result), nil
+	}
+
+	result, err := s.storage.DeleteByQuery(ctx, query, req.RequestsPerSecond)
+	if err != nil {
+		return model.ByQueryResponse{}, err
+	}
+
+	return toByQueryResponse(result), nil
+}
+
+// StartUpdateByQuery starts UpdateByQuery as a task and returns it right
+// away; its progress is available through the task service.
+func (s elasticsearchService) StartUpdateByQuery(ctx context.Context, req model.UpdateByQueryRequest) (model.TaskResponse, error) {
+	query, err := byQueryClause(req.Query, req.Filters)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	set, err := assignments(req.Set)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	taskID, err := s.storage.StartUpdateByQuery(ctx, query, set, req.RequestsPerSecond)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	return s.tasks.Track(ctx, elasticsearch.Task{ID: taskID, Kind: elasticsearch.TaskUpdateByQuery})
+}
+
+// StartDeleteByQuery is the task variant of DeleteByQuery.
+func (s elasticsearchService) StartDeleteByQuery(ctx context.Context, req model.DeleteByQueryRequest) (model.TaskResponse, error) {
+	query, err := byQueryClause(req.Query, req.Filters)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	var taskID string
+	if s.softDelete {
+		taskID, err = s.storage.StartUpdateByQuery(ctx, query, map[string]interface{}{"deleted_at": time.Now().UTC()}, req.RequestsPerSecond)
+	} else {
+		taskID, err = s.storage.StartDeleteByQuery(ctx, query, req.RequestsPerSecond)
+	}
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	return s.tasks.Track(ctx, elasticsearch.Task{ID: taskID, Kind: elasticsearch.TaskDeleteByQuery})
+}
+
+func (s elasticsearchService) dryRun(query map[string]interface{}) (model.ByQueryResponse, error) {
+	result, err := s.storage.FindByQueryClause(query, elasticsearch.SearchPage{Size: byQuerySampleSize}, nil)
+	if err != nil {
+		return model.ByQueryResponse{}, err
+	}
+
+	response := model.ByQueryResponse{
+		DryRun:   true,
+		Total:    result.Total,
+		TimedOut: result.TimedOut,
+		Sample:   make([]model.FindResponse, 0, len(result.Hits)),
+	}
+	for _, hit := range result.Hits {
+		response.Sample = append(response.Sample, toFindResponse(hit.User))
+	}
+
+	return response, nil
+}
+
+// byQueryClause builds the query selecting the users to change. It must not
+// be empty, so a forgotten filter never changes every user.
+func byQueryClause(query string, filters []model.SearchFilter) (map[string]interface{}, error) {
+	if query == "" && len(filters) == 0 {
+		return nil, policyViolation("a query or at least one filter is required")
+	}
+
+	body, err := searchBody(model.SearchRequest{Query: query, Filters: filters})
+	if err != nil {
+		return nil, err
+	}
+
+	return body["query"].(map[string]interface{}), nil
+}
+
+// assignments checks the fields to set: name and job must be non-empty
+// strings, comment a string and childNames a list of strings.
+func assignments(set map[string]interface{}) (map[string]interface{}, error) {
+	if len(set) == 0 {
+		return nil, policyViolation("set needs at least one field")
+	}
+
+	checked := make(map[string]interface{}, len(set))
+	for field, value := range set {
+		switch field {
+		case "name", "job", "comment":
+			text, ok := value.(string)
+			if !ok || (text == "" && field != "comment") {
+				return nil, policyViolation("%s must be set to a non-empty string", field)
+			}
+			checked[field] = text
+		case "childNames":
+			values, ok := value.([]interface{})
+			if !ok {
+				return nil, policyViolation("childNames must be set to a list of names")
+			}
+			names := make([]string, 0, len(values))
+			for _, v := range values {
+				name, ok := v.(string)
+				if !ok {
+					return nil, policyViolation("childNames must be set to a list of names")
+				}
+				names = append(names, name)
+			}
+			checked[field] = names
+		default:
+			return nil, policyViolation("field %q cannot be set, allowed are name, job, comment and childNames", field)
+		}
+	}
+
+	return checked, nil
+}
+
+func toByQueryResponse(result elasticsearch.ByQueryResult) model.ByQueryResponse {
+	return model.ByQueryResponse{
+		Total:            result.Total,
+		Updated:          result.Updated,
+		Deleted:          result.Deleted,
+		VersionConflicts: result.VersionConflicts,
+		TimedOut:         result.TimedOut,
+		Failures:         result.Failures,
+	}
+}
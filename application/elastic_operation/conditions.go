@@ -0,0 +1,54 @@
+package elastic_operation
+
+import (
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+)
+
+var conditionQueryTypes = []string{"match", "match_phrase", "match_phrase_prefix", "wildcard", "regexp", "fuzzy", "prefix", "term"}
+
+// searchConditions groups the conditions of the request by occurrence. The
+// single QueryType/Key/Value of the request, when given, is a must condition.
+func searchConditions(req model.FindByRequest) (elasticsearch.Conditions, error) {
+	conditions := req.Conditions
+	if req.Key != "" || req.QueryType != "" || req.Value != "" {
+		conditions = append([]model.Condition{{Occur: "must", QueryType: req.QueryType, Key: req.Key, Value: req.Value}}, conditions...)
+	}
+	if len(conditions) == 0 {
+		return elasticsearch.Conditions{}, policyViolation("at least one condition is required")
+	}
+
+	var result elasticsearch.Conditions
+	for _, c := range conditions {
+		if c.QueryType == "" {
+			c.QueryType = "match"
+		}
+		if !contains(conditionQueryTypes, c.QueryType) {
+			return elasticsearch.Conditions{}, policyViolation("unsupported query type %q, supported types are %v", c.QueryType, conditionQueryTypes)
+		}
+		if c.Key == "" {
+			return elasticsearch.Conditions{}, policyViolation("condition %s needs a key", c.QueryType)
+		}
+
+		condition := elasticsearch.Condition{QueryType: c.QueryType, Key: c.Key, Value: c.Value}
+		switch c.Occur {
+		case "", "must":
+			result.Must = append(result.Must, condition)
+		case "should":
+			result.Should = append(result.Should, condition)
+		case "must_not":
+			result.MustNot = append(result.MustNot, condition)
+		case "filter":
+			result.Filter = append(result.Filter, condition)
+		default:
+			return elasticsearch.Conditions{}, policyViolation("unsupported occurrence %q, use must, should, must_not or filter", c.Occur)
+		}
+	}
+
+	if req.MinimumShouldMatch != "" && len(result.Should) == 0 {
+		return elasticsearch.Conditions{}, policyViolation("minimumShouldMatch needs should conditions")
+	}
+	result.MinimumShouldMatch = req.MinimumShouldMatch
+
+	return result, nil
+}
@@ -2,32 +2,57 @@ package elastic_operation
 
 import (
 	"context"
+	"elastic-project/application/task_operation"
 	"elastic-project/client/elasticsearch"
 	"elastic-project/model"
+	"errors"
 	"github.com/google/uuid"
+	"io"
 	"time"
 )
 
 type elasticsearchService struct {
-	storage elasticsearch.UserInfoStorer
+	storage    elasticsearch.UserInfoStorer
+	tasks      task_operation.Service
+	policy     QueryPolicy
+	softDelete bool
 }
 
 type Service interface {
 	Create(ctx context.Context, req model.CreateRequest) (model.CreateResponse, error)
 	Update(ctx context.Context, userId string, req model.UpdateRequest) error
+	Patch(ctx context.Context, userId string, req model.PatchRequest) error
+	AddChild(ctx context.Context, userId string, req model.ChildRequest) (bool, error)
+	RemoveChild(ctx context.Context, userId string, name string) (bool, error)
 	Delete(ctx context.Context, req model.DeleteRequest) error
+	Restore(ctx context.Context, id string) error
 	Find(ctx context.Context, req model.FindRequest) (model.FindResponse, error)
-	FindByKeyAndValue(req model.FindByRequest) ([]model.FindResponse, error)
-	FindByQuery(query string) ([]model.FindResponse, error)
+	FindByKeyAndValue(req model.FindByRequest) (model.FindListResponse, error)
+	FindByQuery(req model.FindByQueryRequest) (model.FindListResponse, error)
+	Search(req model.SearchQueryRequest) (model.FindListResponse, error)
+	SearchUsers(req model.SearchRequest) (model.FindListResponse, error)
+	Bulk(ctx context.Context, body io.Reader) (model.BulkResponse, error)
+	UpdateByQuery(ctx context.Context, req model.UpdateByQueryRequest) (model.ByQueryResponse, error)
+	DeleteByQuery(ctx context.Context, req model.DeleteByQueryRequest) (model.ByQueryResponse, error)
+	StartUpdateByQuery(ctx context.Context, req model.UpdateByQueryRequest) (model.TaskResponse, error)
+	StartDeleteByQuery(ctx context.Context, req model.DeleteByQueryRequest) (model.TaskResponse, error)
+	Export(ctx context.Context, req model.ExportRequest, fn func(model.FindResponse) error) error
+	Facets(ctx context.Context, req model.FacetsRequest) (model.FacetsResponse, error)
+	Suggest(ctx context.Context, req model.SuggestRequest) ([]model.SuggestionResponse, error)
 }
 
-func NewElasticsearchService(storage elasticsearch.UserInfoStorer) Service {
-	return &elasticsearchService{storage: storage}
+// NewElasticsearchService creates the user service. With softDelete, Delete,
+// bulk deletes and delete by query only mark users as deleted and Restore
+// brings them back. Update and delete
+// by query started without waiting are followed through tasks.
+func NewElasticsearchService(storage elasticsearch.UserInfoStorer, tasks task_operation.Service, policy QueryPolicy, softDelete bool) Service {
+	return &elasticsearchService{storage: storage, tasks: tasks, policy: policy, softDelete: softDelete}
 }
 
 func (s elasticsearchService) Create(ctx context.Context, req model.CreateRequest) (model.CreateResponse, error) {
 	id := uuid.New().String()
 	cr := time.Now().UTC()
+	actor := model.ActorFromContext(ctx)
 
 	doc := elasticsearch.UserInfo{
 		ID:         id,
@@ -36,6 +61,10 @@ func (s elasticsearchService) Create(ctx context.Context, req model.CreateReques
 		ChildNames: req.ChildNames,
 		Comment:    req.Comment,
 		CreatedAt:  &cr,
+		UpdatedAt:  &cr,
+		CreatedBy:  actor,
+		UpdatedBy:  actor,
+		Revision:   1,
 	}
 
 	if err := s.storage.Insert(ctx, doc); err != nil {
@@ -46,12 +75,21 @@ func (s elasticsearchService) Create(ctx context.Context, req model.CreateReques
 }
 
 func (s elasticsearchService) Update(ctx context.Context, userId string, req model.UpdateRequest) error {
+	version, err := parseVersion(req.Version)
+	if err != nil {
+		return err
+	}
+
+	up := time.Now().UTC()
 	doc := elasticsearch.UserInfo{
 		ID:         userId,
 		Name:       req.Name,
 		Job:        req.Job,
 		ChildNames: req.ChildNames,
 		Comment:    req.Comment,
+		UpdatedAt:  &up,
+		UpdatedBy:  model.ActorFromContext(ctx),
+		Version:    version,
 	}
 
 	if err := s.storage.Update(ctx, doc); err != nil {
@@ -61,68 +99,159 @@ func (s elasticsearchService) Update(ctx context.Context, userId string, req mod
 	return nil
 }
 
+// AddChild adds a child name to the user on the Elasticsearch side, so
+// concurrent changes to the user are kept. Adding a present name is a no-op;
+// the result reports whether the user changed.
+func (s elasticsearchService) AddChild(ctx context.Context, userId string, req model.ChildRequest) (bool, error) {
+	return s.storage.AddChild(ctx, userId, req.Name)
+}
+
+// RemoveChild is the inverse of AddChild; removing a missing name is a no-op.
+func (s elasticsearchService) RemoveChild(ctx context.Context, userId string, name string) (bool, error) {
+	return s.storage.RemoveChild(ctx, userId, name)
+}
+
 func (s elasticsearchService) Delete(ctx context.Context, req model.DeleteRequest) error {
-	if err := s.storage.Delete(ctx, req.ID); err != nil {
+	version, err := parseVersion(req.Version)
+	if err != nil {
+		return err
+	}
+
+	if s.softDelete {
+		return s.storage.SoftDelete(ctx, req.ID, version)
+	}
+
+	if err := s.storage.Delete(ctx, req.ID, version); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// Restore brings back a soft deleted user. It returns model.ErrConflict if
+// the user is not deleted.
+func (s elasticsearchService) Restore(ctx context.Context, id string) error {
+	return s.storage.Undelete(ctx, id)
+}
+
 func (s elasticsearchService) Find(ctx context.Context, req model.FindRequest) (model.FindResponse, error) {
-	userInfo, err := s.storage.FindOne(ctx, req.ID)
+	userInfo, err := s.storage.FindOne(ctx, req.ID, req.IncludeDeleted)
 	if err != nil {
 		return model.FindResponse{}, err
 	}
 
-	return model.FindResponse{
-		ID:         userInfo.ID,
-		Name:       userInfo.Name,
-		Job:        userInfo.Job,
-		ChildNames: userInfo.ChildNames,
-		Comment:    userInfo.Comment,
-		CreatedAt:  userInfo.CreatedAt,
-	}, nil
+	return toFindResponse(userInfo), nil
 }
 
-func (s elasticsearchService) FindByKeyAndValue(req model.FindByRequest) ([]model.FindResponse, error) {
-	userInfos, err := s.storage.FindByKeyAndValue(req.QueryType, req.Key, req.Value)
+func (s elasticsearchService) FindByKeyAndValue(req model.FindByRequest) (model.FindListResponse, error) {
+	conditions, err := searchConditions(req)
 	if err != nil {
-		return []model.FindResponse{}, err
-	}
-
-	findResponseList := make([]model.FindResponse, 0, len(userInfos))
-	for _, userInfo := range userInfos {
-		findResponse := model.FindResponse{
-			ID:         userInfo.ID,
-			Name:       userInfo.Name,
-			Job:        userInfo.Job,
-			ChildNames: userInfo.ChildNames,
-			Comment:    userInfo.Comment,
-			CreatedAt:  userInfo.CreatedAt,
-		}
-		findResponseList = append(findResponseList, findResponse)
+		return model.FindListResponse{}, err
+	}
+
+	page, pageRequest, err := searchPage(req.PageRequest)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+	page.IncludeDeleted = req.IncludeDeleted
+
+	highlight, err := searchHighlight(req.HighlightRequest)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+
+	result, err := s.storage.FindByConditions(conditions, page, highlight)
+	if err != nil {
+		return model.FindListResponse{}, err
 	}
-	return findResponseList, nil
+
+	return findListResponse(result, pageRequest), nil
 }
 
-func (s elasticsearchService) FindByQuery(query string) ([]model.FindResponse, error) {
-	userInfos, err := s.storage.FindByQuery(query)
+func (s elasticsearchService) FindByQuery(req model.FindByQueryRequest) (model.FindListResponse, error) {
+	page, pageRequest, err := searchPage(req.PageRequest)
 	if err != nil {
-		return []model.FindResponse{}, err
-	}
-
-	findResponseList := make([]model.FindResponse, 0, len(userInfos))
-	for _, userInfo := range userInfos {
-		findResponse := model.FindResponse{
-			ID:         userInfo.ID,
-			Name:       userInfo.Name,
-			Job:        userInfo.Job,
-			ChildNames: userInfo.ChildNames,
-			Comment:    userInfo.Comment,
-			CreatedAt:  userInfo.CreatedAt,
+		return model.FindListResponse{}, err
+	}
+	page.IncludeDeleted = req.IncludeDeleted
+
+	highlight, err := searchHighlight(req.HighlightRequest)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+
+	body, err := s.policy.apply(req.Query)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+
+	if query, ok := body["query"].(map[string]interface{}); ok && s.policy.Validate {
+		validation, err := s.storage.ValidateQuery(query)
+		if err != nil {
+			return model.FindListResponse{}, err
 		}
-		findResponseList = append(findResponseList, findResponse)
+		if !validation.Valid {
+			if validation.Explanation == "" {
+				validation.Explanation = "rejected by _validate/query"
+			}
+			return model.FindListResponse{}, policyViolation("%s", validation.Explanation)
+		}
+	}
+
+	result, err := s.storage.FindByQuery(body, page, highlight)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+
+	return findListResponse(result, pageRequest), nil
+}
+
+func (s elasticsearchService) Bulk(ctx context.Context, body io.Reader) (model.BulkResponse, error) {
+	reader := newBulkReader(body, model.ActorFromContext(ctx), s.softDelete)
+
+	results, err := s.storage.Bulk(ctx, reader.next)
+	var lineErr *bulkLineError
+	if err != nil && !errors.As(err, &lineErr) {
+		return model.BulkResponse{}, err
+	}
+
+	response := model.BulkResponse{Items: make([]model.BulkItemResponse, 0, len(results)+1)}
+	for _, result := range results {
+		if result.Error != "" {
+			response.Errors = true
+		}
+		response.Items = append(response.Items, model.BulkItemResponse{
+			Action: result.Action,
+			ID:     result.ID,
+			Status: result.Status,
+			Result: result.Result,
+			Error:  result.Error,
+		})
+	}
+	if lineErr != nil {
+		response.Errors = true
+		response.Items = append(response.Items, model.BulkItemResponse{
+			Status: model.StatusBadRequest,
+			Error:  lineErr.Error(),
+		})
+	}
+
+	return response, nil
+}
+
+func toFindResponse(userInfo elasticsearch.UserInfo) model.FindResponse {
+	return model.FindResponse{
+		ID:         userInfo.ID,
+		Name:       userInfo.Name,
+		Job:        userInfo.Job,
+		ChildNames: userInfo.ChildNames,
+		Comment:    userInfo.Comment,
+		CreatedAt:  userInfo.CreatedAt,
+		UpdatedAt:  userInfo.UpdatedAt,
+		CreatedBy:  userInfo.CreatedBy,
+		UpdatedBy:  userInfo.UpdatedBy,
+		Revision:   userInfo.CurrentRevision(),
+		DeletedAt:  userInfo.DeletedAt,
+		Version:    formatVersion(userInfo.Version),
 	}
-	return findResponseList, nil
 }
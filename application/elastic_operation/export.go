@@ -0,0 +1,47 @@
+package elastic_operation
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+)
+
+func (s elasticsearchService) Export(ctx context.Context, req model.ExportRequest, fn func(model.FindResponse) error) error {
+	query, err := s.policy.exportQuery(req.Query)
+	if err != nil {
+		return err
+	}
+
+	return s.storage.Export(ctx, query, req.IncludeDeleted, func(userInfo elasticsearch.UserInfo) error {
+		return fn(toFindResponse(userInfo))
+	})
+}
+
+// exportQuery takes the "query" member of a search body in the same shape as
+// /users-by-query accepts and checks it against the policy. An empty string
+// exports everything; a body without a query is rejected rather than taken
+// for everything.
+func (policy QueryPolicy) exportQuery(jsonQuery string) (map[string]interface{}, error) {
+	if jsonQuery == "" {
+		return nil, nil
+	}
+
+	var body struct {
+		Query interface{} `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(jsonQuery), &body); err != nil {
+		return nil, fmt.Errorf("%w: %v", model.ErrInvalidQuery, err)
+	}
+	if body.Query == nil {
+		return nil, policyViolation("jsonQuery needs a query member")
+	}
+
+	clauses := 0
+	if err := policy.checkClause(body.Query, &clauses); err != nil {
+		return nil, err
+	}
+
+	return body.Query.(map[string]interface{}), nil
+}
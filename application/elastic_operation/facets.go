@@ -0,0 +1,97 @@
+package elastic_operation
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"fmt"
+)
+
+const (
+	defaultFacetInterval = "week"
+	defaultFacetJobSize  = 10
+	maxFacetJobSize      = 100
+)
+
+var facetIntervals = map[string]bool{"day": true, "week": true, "month": true, "quarter": true, "year": true}
+
+func (s elasticsearchService) Facets(ctx context.Context, req model.FacetsRequest) (model.FacetsResponse, error) {
+	options := elasticsearch.FacetOptions{JobSize: req.JobSize, CalendarInterval: req.Interval, IncludeDeleted: req.IncludeDeleted}
+	if options.JobSize == 0 {
+		options.JobSize = defaultFacetJobSize
+	}
+	if options.JobSize < 0 || options.JobSize > maxFacetJobSize {
+		return model.FacetsResponse{}, fmt.Errorf("%w: jobSize must be between 1 and %d", model.ErrInvalidQuery, maxFacetJobSize)
+	}
+	if options.CalendarInterval == "" {
+		options.CalendarInterval = defaultFacetInterval
+	}
+	if !facetIntervals[options.CalendarInterval] {
+		return model.FacetsResponse{}, fmt.Errorf("%w: unknown interval %q", model.ErrInvalidQuery, options.CalendarInterval)
+	}
+
+	query, err := facetQuery(req)
+	if err != nil {
+		return model.FacetsResponse{}, err
+	}
+
+	result, err := s.storage.Facets(ctx, query, options)
+	if err != nil {
+		return model.FacetsResponse{}, err
+	}
+
+	response := model.FacetsResponse{
+		Total:      result.Total,
+		Jobs:       make([]model.TermBucketResponse, 0, len(result.Jobs)),
+		CreatedAt:  make([]model.DateBucketResponse, 0, len(result.CreatedAt)),
+		ChildCount: make([]model.HistogramBucketResponse, 0, len(result.ChildCount)),
+	}
+	for _, bucket := range result.Jobs {
+		response.Jobs = append(response.Jobs, model.TermBucketResponse{Key: bucket.Key, Count: bucket.Count})
+	}
+	for _, bucket := range result.CreatedAt {
+		response.CreatedAt = append(response.CreatedAt, model.DateBucketResponse{Key: bucket.Key, Count: bucket.Count})
+	}
+	for _, bucket := range result.ChildCount {
+		response.ChildCount = append(response.ChildCount, model.HistogramBucketResponse{Key: bucket.Key, Count: bucket.Count})
+	}
+
+	return response, nil
+}
+
+// facetQuery combines the conditions and the search query and filters of the
+// request. It returns nil when the request selects every user.
+func facetQuery(req model.FacetsRequest) (map[string]interface{}, error) {
+	var clauses []interface{}
+
+	if req.QueryType != "" || req.Key != "" || req.Value != "" || len(req.Conditions) > 0 {
+		conditions, err := searchConditions(model.FindByRequest{
+			QueryType:          req.QueryType,
+			Key:                req.Key,
+			Value:              req.Value,
+			Conditions:         req.Conditions,
+			MinimumShouldMatch: req.MinimumShouldMatch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, conditions.Query())
+	}
+
+	if req.Query != "" || len(req.Filters) > 0 {
+		body, err := searchBody(model.SearchRequest{Query: req.Query, Filters: req.Filters})
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, body["query"])
+	}
+
+	switch len(clauses) {
+	case 0:
+		return nil, nil
+	case 1:
+		return clauses[0].(map[string]interface{}), nil
+	default:
+		return map[string]interface{}{"bool": map[string]interface{}{"must": clauses}}, nil
+	}
+}
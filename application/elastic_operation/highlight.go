@@ -0,0 +1,41 @@
+package elastic_operation
+
+import (
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"fmt"
+)
+
+const (
+	defaultHighlightPreTag       = "<em>"
+	defaultHighlightPostTag      = "</em>"
+	defaultHighlightFragmentSize = 100
+	maxHighlightFragmentSize     = 1000
+)
+
+// searchHighlight returns nil unless highlighting was asked for.
+func searchHighlight(req model.HighlightRequest) (*elasticsearch.Highlight, error) {
+	if !req.Highlight {
+		return nil, nil
+	}
+
+	highlight := &elasticsearch.Highlight{
+		PreTag:       req.PreTag,
+		PostTag:      req.PostTag,
+		FragmentSize: req.FragmentSize,
+	}
+	if highlight.PreTag == "" {
+		highlight.PreTag = defaultHighlightPreTag
+	}
+	if highlight.PostTag == "" {
+		highlight.PostTag = defaultHighlightPostTag
+	}
+	if highlight.FragmentSize == 0 {
+		highlight.FragmentSize = defaultHighlightFragmentSize
+	}
+	if highlight.FragmentSize < 0 || highlight.FragmentSize > maxHighlightFragmentSize {
+		return nil, fmt.Errorf("%w: fragmentSize must be between 1 and %d", model.ErrInvalidQuery, maxHighlightFragmentSize)
+	}
+
+	return highlight, nil
+}
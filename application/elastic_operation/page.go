@@ -0,0 +1,109 @@
+package elastic_operation
+
+import (
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"encoding/base64"
+	"encoding/json"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+	// maxResultWindow mirrors index.max_result_window; deeper pages need a cursor.
+	maxResultWindow = 10000
+)
+
+// searchPage validates the requested page and translates it into from/size or
+// search_after. The returned PageRequest has its defaults filled in.
+func searchPage(req model.PageRequest) (elasticsearch.SearchPage, model.PageRequest, error) {
+	if req.Size == 0 {
+		req.Size = defaultPageSize
+	}
+	if req.Size < 0 || req.Size > maxPageSize {
+		return elasticsearch.SearchPage{}, req, model.ErrInvalidPage
+	}
+
+	if req.Cursor != "" {
+		searchAfter, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return elasticsearch.SearchPage{}, req, err
+		}
+		req.Page = 0
+		return elasticsearch.SearchPage{Size: req.Size, SearchAfter: searchAfter}, req, nil
+	}
+
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.Page < 0 || req.Page*req.Size > maxResultWindow {
+		return elasticsearch.SearchPage{}, req, model.ErrInvalidPage
+	}
+
+	return elasticsearch.SearchPage{From: (req.Page - 1) * req.Size, Size: req.Size}, req, nil
+}
+
+func encodeCursor(sort []interface{}) string {
+	if len(sort) == 0 {
+		return ""
+	}
+
+	bdy, err := json.Marshal(sort)
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bdy)
+}
+
+func decodeCursor(cursor string) ([]interface{}, error) {
+	bdy, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, model.ErrInvalidCursor
+	}
+
+	var sort []interface{}
+	if err := json.Unmarshal(bdy, &sort); err != nil || len(sort) == 0 {
+		return nil, model.ErrInvalidCursor
+	}
+
+	return sort, nil
+}
+
+func findListResponse(result elasticsearch.SearchResult, page model.PageRequest) model.FindListResponse {
+	response := model.FindListResponse{
+		Total:    result.Total,
+		Page:     page.Page,
+		Size:     page.Size,
+		TimedOut: result.TimedOut,
+		Shards: model.ShardsResponse{
+			Total:      result.Shards.Total,
+			Successful: result.Shards.Successful,
+			Skipped:    result.Shards.Skipped,
+			Failed:     result.Shards.Failed,
+		},
+		Items: make([]model.FindResponse, 0, len(result.Hits)),
+	}
+	for _, failure := range result.Shards.Failures {
+		response.Shards.Failures = append(response.Shards.Failures, model.ShardFailureResponse{
+			Index:  failure.Index,
+			Shard:  failure.Shard,
+			Node:   failure.Node,
+			Type:   failure.Reason.Type,
+			Reason: failure.Reason.Reason,
+		})
+	}
+	for _, hit := range result.Hits {
+		item := toFindResponse(hit.User)
+		item.DocumentID = hit.ID
+		item.Score = hit.Score
+		item.Sort = hit.Sort
+		item.Highlight = hit.Highlight
+		response.Items = append(response.Items, item)
+	}
+	if len(result.Hits) == page.Size && page.Size > 0 {
+		response.NextCursor = encodeCursor(result.Hits[len(result.Hits)-1].Sort)
+	}
+
+	return response
+}
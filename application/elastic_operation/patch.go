@@ -0,0 +1,124 @@
+package elastic_operation
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/application/jsonpatch"
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+
+	// patchAttempts bounds how often a patch without If-Match is reapplied
+	// when the user changed between reading and writing it.
+	patchAttempts = 3
+)
+
+// Patch applies a merge patch or JSON patch to the stored user. The write is
+// conditional on the version the patch was applied to, so concurrent changes
+// are never overwritten: with If-Match a change in between is a conflict,
+// without it the patch is applied again to the new version.
+func (s elasticsearchService) Patch(ctx context.Context, userId string, req model.PatchRequest) error {
+	apply, err := patchFunc(req.ContentType)
+	if err != nil {
+		return err
+	}
+
+	version, err := parseVersion(req.Version)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		current, err := s.storage.FindOne(ctx, userId, false)
+		if err != nil {
+			return err
+		}
+		if version != nil && *version != *current.Version {
+			return model.ErrConflict
+		}
+
+		patched, err := patchUser(current, req.Patch, apply)
+		if err != nil {
+			return err
+		}
+
+		up := time.Now().UTC()
+		doc := elasticsearch.UserInfo{
+			ID:         userId,
+			Name:       patched.Name,
+			Job:        patched.Job,
+			ChildNames: patched.ChildNames,
+			Comment:    patched.Comment,
+			UpdatedAt:  &up,
+			UpdatedBy:  model.ActorFromContext(ctx),
+			Version:    current.Version,
+		}
+
+		err = s.storage.Update(ctx, doc)
+		if model.ErrConflict == err && version == nil && attempt < patchAttempts {
+			continue
+		}
+		return err
+	}
+}
+
+func patchFunc(contentType string) (func(doc, patch []byte) ([]byte, error), error) {
+	switch contentType {
+	case MergePatchContentType:
+		return jsonpatch.Merge, nil
+	case JSONPatchContentType:
+		return jsonpatch.Apply, nil
+	}
+	return nil, model.ErrUnsupportedPatch
+}
+
+// patchUser applies the patch to the fields a client may change. The result
+// must still be a valid user: unknown members or an empty name or job reject
+// the patch.
+func patchUser(current elasticsearch.UserInfo, patch []byte, apply func(doc, patch []byte) ([]byte, error)) (model.UpdateRequest, error) {
+	fields := model.UpdateRequest{
+		Name:       current.Name,
+		Job:        current.Job,
+		ChildNames: current.ChildNames,
+		Comment:    current.Comment,
+	}
+	if fields.ChildNames == nil {
+		fields.ChildNames = []string{}
+	}
+
+	doc, err := json.Marshal(fields)
+	if err != nil {
+		return model.UpdateRequest{}, fmt.Errorf("patch: marshall: %w", err)
+	}
+
+	doc, err = apply(doc, patch)
+	var opErr *jsonpatch.OperationError
+	switch {
+	case errors.As(err, &opErr):
+		return model.UpdateRequest{}, fmt.Errorf("%w: %v", model.ErrPatchFailed, err)
+	case errors.Is(err, jsonpatch.ErrInvalidPatch):
+		return model.UpdateRequest{}, fmt.Errorf("%w: %v", model.ErrInvalidPatch, err)
+	case err != nil:
+		return model.UpdateRequest{}, fmt.Errorf("patch: %w", err)
+	}
+
+	var patched model.UpdateRequest
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return model.UpdateRequest{}, fmt.Errorf("%w: patched user: %v", model.ErrPatchFailed, err)
+	}
+	if patched.Name == "" || patched.Job == "" {
+		return model.UpdateRequest{}, fmt.Errorf("%w: patched user needs a name and a job", model.ErrPatchFailed)
+	}
+
+	return patched, nil
+}
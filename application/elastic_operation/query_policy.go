@@ -0,0 +1,318 @@
+package elastic_operation
+
+import (
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QueryPolicy limits what a raw search body sent to /users-by-query may do.
+// DeniedClauses wins over AllowedClauses; an empty AllowedClauses allows every
+// clause type that is not denied.
+type QueryPolicy struct {
+	AllowedClauses []string
+	DeniedClauses  []string
+	// AllowedBodyKeys lists the top level members of the search body; anything
+	// else, like aggs or script_fields, is rejected.
+	AllowedBodyKeys []string
+	MaxClauses      int
+	MaxSize         int
+	// Timeout is sent as the search timeout, so a slow query returns partial
+	// results instead of holding the shards.
+	Timeout time.Duration
+	// Validate checks the query with _validate/query before running it.
+	Validate bool
+}
+
+func DefaultQueryPolicy() QueryPolicy {
+	return QueryPolicy{
+		AllowedClauses: []string{
+			"bool", "constant_score", "dis_max", "boosting",
+			"match", "match_phrase", "match_phrase_prefix", "match_bool_prefix", "multi_match",
+			"term", "terms", "range", "exists", "ids", "prefix", "wildcard", "regexp", "fuzzy",
+			"match_all", "match_none",
+		},
+		DeniedClauses: []string{
+			"script", "script_score", "function_score", "query_string", "wrapper",
+			"more_like_this", "percolate", "terms_set",
+		},
+		AllowedBodyKeys: []string{"query", "sort", "_source", "size", "min_score", "track_scores"},
+		MaxClauses:      64,
+		MaxSize:         maxPageSize,
+		Timeout:         5 * time.Second,
+	}
+}
+
+// compoundClauses maps the compound clause types to the members holding
+// nested clauses.
+var compoundClauses = map[string][]string{
+	"bool":           {"must", "should", "must_not", "filter"},
+	"constant_score": {"filter"},
+	"dis_max":        {"queries"},
+	"boosting":       {"positive", "negative"},
+}
+
+// apply decodes the search body, checks it against the policy and sets the
+// search timeout.
+func (policy QueryPolicy) apply(jsonQuery string) (map[string]interface{}, error) {
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(jsonQuery), &body); err != nil {
+		return nil, fmt.Errorf("%w: %v", model.ErrInvalidQuery, err)
+	}
+
+	for key, value := range body {
+		if !contains(policy.AllowedBodyKeys, key) {
+			return nil, policyViolation("%q is not allowed in the search body, allowed members are %s", key, strings.Join(policy.AllowedBodyKeys, ", "))
+		}
+
+		switch key {
+		case "size":
+			size, ok := value.(float64)
+			if !ok || size < 0 || size != float64(int(size)) {
+				return nil, policyViolation("size must be a non-negative integer")
+			}
+			if policy.MaxSize > 0 && int(size) > policy.MaxSize {
+				return nil, policyViolation("size %d exceeds the maximum of %d", int(size), policy.MaxSize)
+			}
+		case "sort":
+			if err := checkSort(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if query, ok := body["query"]; ok {
+		clauses := 0
+		if err := policy.checkClause(query, &clauses); err != nil {
+			return nil, err
+		}
+	}
+
+	if policy.Timeout > 0 {
+		body["timeout"] = fmt.Sprintf("%dms", policy.Timeout.Milliseconds())
+	}
+
+	return body, nil
+}
+
+func (policy QueryPolicy) checkClause(value interface{}, clauses *int) error {
+	clause, ok := value.(map[string]interface{})
+	if !ok || len(clause) != 1 {
+		return policyViolation("a query clause must be an object with a single clause type")
+	}
+
+	for clauseType, body := range clause {
+		*clauses++
+		if policy.MaxClauses > 0 && *clauses > policy.MaxClauses {
+			return policyViolation("query has more than %d clauses", policy.MaxClauses)
+		}
+
+		if contains(policy.DeniedClauses, clauseType) {
+			return policyViolation("%q queries are not allowed", clauseType)
+		}
+		if len(policy.AllowedClauses) > 0 && !contains(policy.AllowedClauses, clauseType) {
+			return policyViolation("%q queries are not allowed, allowed clauses are %s", clauseType, strings.Join(policy.AllowedClauses, ", "))
+		}
+
+		switch clauseType {
+		case "wildcard", "regexp":
+			if err := checkLeadingWildcard(clauseType, body); err != nil {
+				return err
+			}
+		case "terms":
+			if err := checkTermsLookup(body); err != nil {
+				return err
+			}
+		}
+
+		members, ok := compoundClauses[clauseType]
+		if !ok {
+			continue
+		}
+		compound, ok := body.(map[string]interface{})
+		if !ok {
+			return policyViolation("%q must be an object", clauseType)
+		}
+		for _, member := range members {
+			nested, ok := compound[member]
+			if !ok {
+				continue
+			}
+			if list, ok := nested.([]interface{}); ok {
+				for _, item := range list {
+					if err := policy.checkClause(item, clauses); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := policy.checkClause(nested, clauses); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkLeadingWildcard rejects patterns that start with a wildcard; they
+// have to scan every term of the field.
+func checkLeadingWildcard(clauseType string, body interface{}) error {
+	fields, ok := body.(map[string]interface{})
+	if !ok {
+		return policyViolation("%q must be an object", clauseType)
+	}
+
+	for field, value := range fields {
+		pattern, ok := value.(string)
+		if options, isObject := value.(map[string]interface{}); isObject {
+			pattern, ok = options["value"].(string)
+			if !ok {
+				pattern, ok = options["wildcard"].(string)
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		leading := strings.HasPrefix(pattern, "*") || strings.HasPrefix(pattern, "?")
+		if clauseType == "regexp" {
+			leading = leadingRegexpWildcard(pattern)
+		}
+		if leading {
+			return policyViolation("leading wildcard in %q on %s is not allowed", clauseType, field)
+		}
+	}
+
+	return nil
+}
+
+// leadingRegexpWildcard reports whether the first atom of the Lucene regular
+// expression can match arbitrary terms: ., @ or a complement, a character
+// class or group followed by a quantifier, or a group whose alternatives
+// start like that, as in (.*)x, [a-z]*x or .{0,}x.
+func leadingRegexpWildcard(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+
+	var end int
+	switch pattern[0] {
+	case '.', '@', '~':
+		return true
+	case '[':
+		end = closingBracket(pattern, '[', ']')
+	case '(':
+		end = closingBracket(pattern, '(', ')')
+		if end < 0 {
+			return false
+		}
+		for _, alternative := range splitAlternatives(pattern[1:end]) {
+			if leadingRegexpWildcard(alternative) {
+				return true
+			}
+		}
+	default:
+		return false
+	}
+	if end < 0 || end+1 >= len(pattern) {
+		return false
+	}
+
+	return strings.ContainsRune("*+?{", rune(pattern[end+1]))
+}
+
+// closingBracket returns the index of the bracket closing the one at the
+// start of pattern, skipping escaped characters and nested brackets, or -1.
+func closingBracket(pattern string, open byte, close byte) int {
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitAlternatives splits the top level alternatives of a group body at |.
+func splitAlternatives(body string) []string {
+	var (
+		alternatives []string
+		depth, start int
+	)
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			i++
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case '|':
+			if depth == 0 {
+				alternatives = append(alternatives, body[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(alternatives, body[start:])
+}
+
+// checkTermsLookup rejects terms lookups, which fetch the terms from a
+// document of any index the cluster holds.
+func checkTermsLookup(body interface{}) error {
+	fields, ok := body.(map[string]interface{})
+	if !ok {
+		return policyViolation("%q must be an object", "terms")
+	}
+
+	for field, value := range fields {
+		if lookup, ok := value.(map[string]interface{}); ok {
+			if _, ok := lookup["index"]; ok {
+				return policyViolation("terms lookup on %s is not allowed", field)
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkSort(value interface{}) error {
+	sorts, ok := value.([]interface{})
+	if !ok {
+		sorts = []interface{}{value}
+	}
+
+	for _, sort := range sorts {
+		if fields, ok := sort.(map[string]interface{}); ok {
+			if _, ok := fields["_script"]; ok {
+				return policyViolation("script sorting is not allowed")
+			}
+		}
+	}
+
+	return nil
+}
+
+func policyViolation(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", model.ErrInvalidQuery, fmt.Sprintf(format, args...))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package elastic_operation
+
+import (
+	"elastic-project/application/kql"
+	"elastic-project/model"
+)
+
+// searchFields is the whitelist of fields the query language can address.
+var searchFields = map[string]kql.Field{
+	"id":         {Name: "id", Type: kql.Keyword},
+	"name":       {Name: "name", Type: kql.Text},
+	"job":        {Name: "job", Type: kql.Text},
+	"comment":    {Name: "comment", Type: kql.Text},
+	"childNames": {Name: "childNames", Type: kql.Text},
+	"created_at": {Name: "created_at", Type: kql.Date},
+	"updated_at": {Name: "updated_at", Type: kql.Date},
+	"created_by": {Name: "created_by", Type: kql.Keyword},
+	"updated_by": {Name: "updated_by", Type: kql.Keyword},
+}
+
+func (s elasticsearchService) Search(req model.SearchQueryRequest) (model.FindListResponse, error) {
+	query, err := kql.Parse(req.Query, searchFields)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+
+	page, pageRequest, err := searchPage(req.PageRequest)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+	page.IncludeDeleted = req.IncludeDeleted
+
+	highlight, err := searchHighlight(req.HighlightRequest)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+
+	result, err := s.storage.FindByQueryClause(query, page, highlight)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+
+	return findListResponse(result, pageRequest), nil
+}
@@ -0,0 +1,202 @@
+package elastic_operation
+
+import (
+	"elastic-project/model"
+	"fmt"
+)
+
+// exactFields maps the fields accepted by term, terms, range and prefix
+// filters to the indexed field holding their exact value.
+var exactFields = map[string]string{
+	"id":         "id",
+	"name":       "name.keyword",
+	"job":        "job.keyword",
+	"childNames": "childNames.keyword",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"created_by": "created_by",
+	"updated_by": "updated_by",
+}
+
+var sortFields = map[string]string{
+	"_score":     "_score",
+	"id":         "id",
+	"name":       "name.keyword",
+	"job":        "job.keyword",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"created_by": "created_by",
+	"updated_by": "updated_by",
+}
+
+var sourceFields = []string{"id", "name", "job", "childNames", "comment", "created_at", "updated_at", "created_by", "updated_by"}
+
+func (s elasticsearchService) SearchUsers(req model.SearchRequest) (model.FindListResponse, error) {
+	body, err := searchBody(req)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+	if s.policy.Timeout > 0 {
+		body["timeout"] = fmt.Sprintf("%dms", s.policy.Timeout.Milliseconds())
+	}
+
+	page, pageRequest, err := searchPage(req.PageRequest)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+	page.IncludeDeleted = req.IncludeDeleted
+
+	highlight, err := searchHighlight(req.HighlightRequest)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+
+	result, err := s.storage.FindByQuery(body, page, highlight)
+	if err != nil {
+		return model.FindListResponse{}, err
+	}
+
+	return findListResponse(result, pageRequest), nil
+}
+
+// searchBody translates the request into a search body: the free text query
+// goes into must, the filters into filter of a bool query.
+func searchBody(req model.SearchRequest) (map[string]interface{}, error) {
+	var must, filter []interface{}
+
+	if req.Query != "" {
+		must = append(must, map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":  req.Query,
+				"fields": []string{"name", "job", "comment", "childNames"},
+			},
+		})
+	}
+
+	for _, f := range req.Filters {
+		clause, err := filterClause(f)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, clause)
+	}
+
+	query := map[string]interface{}{"match_all": map[string]interface{}{}}
+	if len(must) > 0 || len(filter) > 0 {
+		boolQuery := map[string]interface{}{}
+		if len(must) > 0 {
+			boolQuery["must"] = must
+		}
+		if len(filter) > 0 {
+			boolQuery["filter"] = filter
+		}
+		query = map[string]interface{}{"bool": boolQuery}
+	}
+
+	body := map[string]interface{}{"query": query}
+
+	if len(req.Sort) > 0 {
+		sort, err := sortClause(req.Sort)
+		if err != nil {
+			return nil, err
+		}
+		body["sort"] = sort
+	}
+
+	if len(req.Fields) > 0 {
+		includes := []string{"id"}
+		for _, field := range req.Fields {
+			if !contains(sourceFields, field) {
+				return nil, policyViolation("unknown field %q in fields", field)
+			}
+			if field != "id" {
+				includes = append(includes, field)
+			}
+		}
+		body["_source"] = includes
+	}
+
+	return body, nil
+}
+
+func filterClause(f model.SearchFilter) (map[string]interface{}, error) {
+	set := 0
+	for _, isSet := range []bool{f.Term != nil, f.Terms != nil, f.Range != nil, f.Exists != nil, f.Prefix != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, policyViolation("filter on %q must set exactly one of term, terms, range, exists or prefix", f.Field)
+	}
+
+	if f.Exists != nil {
+		if !contains(sourceFields, f.Field) {
+			return nil, policyViolation("unknown filter field %q", f.Field)
+		}
+		exists := map[string]interface{}{"exists": map[string]interface{}{"field": f.Field}}
+		if *f.Exists {
+			return exists, nil
+		}
+		return map[string]interface{}{"bool": map[string]interface{}{"must_not": []interface{}{exists}}}, nil
+	}
+
+	field, ok := exactFields[f.Field]
+	if !ok {
+		return nil, policyViolation("field %q cannot be filtered by value", f.Field)
+	}
+
+	switch {
+	case f.Term != nil:
+		return map[string]interface{}{"term": map[string]interface{}{field: *f.Term}}, nil
+	case f.Terms != nil:
+		if len(f.Terms) == 0 {
+			return nil, policyViolation("terms filter on %q needs at least one value", f.Field)
+		}
+		return map[string]interface{}{"terms": map[string]interface{}{field: f.Terms}}, nil
+	case f.Prefix != nil:
+		if f.Field == "created_at" || f.Field == "updated_at" {
+			return nil, policyViolation("prefix filter is not supported on %s", f.Field)
+		}
+		return map[string]interface{}{"prefix": map[string]interface{}{field: *f.Prefix}}, nil
+	}
+
+	bounds := map[string]interface{}{}
+	for operator, value := range map[string]string{"gt": f.Range.GT, "gte": f.Range.GTE, "lt": f.Range.LT, "lte": f.Range.LTE} {
+		if value != "" {
+			bounds[operator] = value
+		}
+	}
+	if len(bounds) == 0 {
+		return nil, policyViolation("range filter on %q needs a bound", f.Field)
+	}
+	return map[string]interface{}{"range": map[string]interface{}{field: bounds}}, nil
+}
+
+// sortClause translates the requested sort and appends id as a tie breaker,
+// so cursors built from the sort values stay stable.
+func sortClause(sorts []model.SearchSort) ([]interface{}, error) {
+	clauses := make([]interface{}, 0, len(sorts)+1)
+	tieBreaker := true
+	for _, sort := range sorts {
+		field, ok := sortFields[sort.Field]
+		if !ok {
+			return nil, policyViolation("field %q cannot be sorted on", sort.Field)
+		}
+		order := sort.Order
+		if order == "" {
+			order = "asc"
+			if field == "_score" {
+				order = "desc"
+			}
+		}
+		if field == "id" {
+			tieBreaker = false
+		}
+		clauses = append(clauses, map[string]interface{}{field: order})
+	}
+	if tieBreaker {
+		clauses = append(clauses, map[string]interface{}{"id": "asc"})
+	}
+	return clauses, nil
+}
@@ -0,0 +1,52 @@
+package elastic_operation
+
+import (
+	"context"
+	"elastic-project/model"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultSuggestSize = 10
+	maxSuggestSize     = 50
+)
+
+var suggestFields = map[string]bool{"name": true, "job": true}
+
+func (s elasticsearchService) Suggest(ctx context.Context, req model.SuggestRequest) ([]model.SuggestionResponse, error) {
+	if !suggestFields[req.Field] {
+		return nil, fmt.Errorf("%w: field must be name or job", model.ErrInvalidQuery)
+	}
+	prefix := strings.TrimSpace(req.Prefix)
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: prefix is required", model.ErrInvalidQuery)
+	}
+	size := req.Size
+	if size == 0 {
+		size = defaultSuggestSize
+	}
+	if size < 0 || size > maxSuggestSize {
+		return nil, fmt.Errorf("%w: size must be between 1 and %d", model.ErrInvalidQuery, maxSuggestSize)
+	}
+
+	suggestions, err := s.storage.Suggest(ctx, req.Field, prefix, req.Job, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Storage collapses exact duplicates; values differing only in case are
+	// folded here, keeping the best ranked spelling.
+	seen := make(map[string]bool, len(suggestions))
+	response := make([]model.SuggestionResponse, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		key := strings.ToLower(suggestion.Text)
+		if suggestion.Text == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		response = append(response, model.SuggestionResponse{Text: suggestion.Text, Score: suggestion.Score})
+	}
+
+	return response, nil
+}
@@ -0,0 +1,33 @@
+package elastic_operation
+
+import (
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"fmt"
+)
+
+// formatVersion turns a document version into the opaque token handed out as
+// an ETag. parseVersion is its inverse.
+func formatVersion(version *elasticsearch.Version) string {
+	if version == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d.%d", version.PrimaryTerm, version.SeqNo)
+}
+
+func parseVersion(token string) (*elasticsearch.Version, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var version elasticsearch.Version
+	if _, err := fmt.Sscanf(token, "%d.%d", &version.PrimaryTerm, &version.SeqNo); err != nil {
+		return nil, model.ErrConflict
+	}
+	if formatVersion(&version) != token {
+		return nil, model.ErrConflict
+	}
+
+	return &version, nil
+}
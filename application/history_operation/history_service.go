@@ -0,0 +1,90 @@
+package history_operation
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"time"
+)
+
+type historyService struct {
+	history elasticsearch.HistoryStorer
+	storage elasticsearch.UserInfoStorer
+}
+
+type Service interface {
+	History(ctx context.Context, id string) ([]model.HistoryResponse, error)
+	Revision(ctx context.Context, id string, revision int64) (model.HistoryResponse, error)
+	Restore(ctx context.Context, id string, revision int64) error
+}
+
+func NewHistoryService(history elasticsearch.HistoryStorer, storage elasticsearch.UserInfoStorer) Service {
+	return &historyService{history: history, storage: storage}
+}
+
+// History lists the previous revisions of the user, newest first. A user
+// that was never changed has no history.
+func (s historyService) History(ctx context.Context, id string) ([]model.HistoryResponse, error) {
+	entries, err := s.history.FindHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]model.HistoryResponse, 0, len(entries))
+	for _, entry := range entries {
+		responses = append(responses, toHistoryResponse(entry))
+	}
+
+	return responses, nil
+}
+
+func (s historyService) Revision(ctx context.Context, id string, revision int64) (model.HistoryResponse, error) {
+	entry, err := s.history.FindRevision(ctx, id, revision)
+	if err != nil {
+		return model.HistoryResponse{}, err
+	}
+
+	return toHistoryResponse(entry), nil
+}
+
+// Restore writes the revision back as the current user. The restore is a
+// change like any other: it gets a new revision and the replaced one goes to
+// the history.
+func (s historyService) Restore(ctx context.Context, id string, revision int64) error {
+	entry, err := s.history.FindRevision(ctx, id, revision)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	user := entry.User
+	user.ID = id
+	user.UpdatedAt = &now
+	user.UpdatedBy = model.ActorFromContext(ctx)
+	user.Version = nil
+
+	return s.storage.Replace(ctx, user)
+}
+
+func toHistoryResponse(entry elasticsearch.HistoryEntry) model.HistoryResponse {
+	user := entry.User
+	return model.HistoryResponse{
+		Revision:  entry.Revision,
+		Action:    entry.Action,
+		Actor:     entry.Actor,
+		Timestamp: entry.Timestamp,
+		User: model.FindResponse{
+			ID:         user.ID,
+			Name:       user.Name,
+			Job:        user.Job,
+			ChildNames: user.ChildNames,
+			Comment:    user.Comment,
+			CreatedAt:  user.CreatedAt,
+			UpdatedAt:  user.UpdatedAt,
+			CreatedBy:  user.CreatedBy,
+			UpdatedBy:  user.UpdatedBy,
+			Revision:   entry.Revision,
+			DeletedAt:  user.DeletedAt,
+		},
+	}
+}
@@ -0,0 +1,375 @@
+package import_operation
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	importBatchSize = 1000
+	// importRefreshInterval is applied to the user index while at least one
+	// import runs, so bulk requests are not slowed down by refreshes.
+	importRefreshInterval = "30s"
+)
+
+type importService struct {
+	jobs         elasticsearch.ImportJobStorer
+	storage      elasticsearch.UserInfoStorer
+	indexManager elasticsearch.IndexManager
+	dir          string
+	validate     *validator.Validate
+
+	mu     sync.Mutex
+	active int
+	// previous is the refresh interval to restore once no import runs.
+	previous *string
+}
+
+type Service interface {
+	Start(ctx context.Context, req model.ImportRequest, file io.Reader) (model.ImportJobResponse, error)
+	Find(ctx context.Context, id string) (model.ImportJobResponse, error)
+	ErrorReport(ctx context.Context, id string, fn func(model.ImportErrorResponse) error) error
+	Resume(ctx context.Context) error
+}
+
+func NewImportService(
+	jobs elasticsearch.ImportJobStorer,
+	storage elasticsearch.UserInfoStorer,
+	indexManager elasticsearch.IndexManager,
+	dir string) Service {
+	validate := validator.New()
+	validate.SetTagName("binding")
+
+	return &importService{
+		jobs:         jobs,
+		storage:      storage,
+		indexManager: indexManager,
+		dir:          dir,
+		validate:     validate,
+	}
+}
+
+// Start stores the uploaded file and queues an import job for it. The job
+// runs in the background; its progress is available through Find.
+func (s *importService) Start(ctx context.Context, req model.ImportRequest, file io.Reader) (model.ImportJobResponse, error) {
+	format := req.Format
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(req.FileName), ".")
+	}
+	if format != FormatCSV && format != FormatNDJSON {
+		return model.ImportJobResponse{}, model.ErrInvalidFormat
+	}
+
+	id := uuid.New().String()
+	path := filepath.Join(s.dir, id+"."+format)
+	if err := saveFile(path, file); err != nil {
+		return model.ImportJobResponse{}, fmt.Errorf("start import: %w", err)
+	}
+
+	now := time.Now().UTC()
+	job := elasticsearch.ImportJob{
+		ID:        id,
+		Format:    format,
+		FileName:  req.FileName,
+		Path:      path,
+		Status:    elasticsearch.ImportStatusQueued,
+		CreatedBy: model.ActorFromContext(ctx),
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+	if err := s.jobs.SaveJob(ctx, job); err != nil {
+		_ = os.Remove(path)
+		return model.ImportJobResponse{}, err
+	}
+
+	go s.run(job)
+
+	return toImportJobResponse(job), nil
+}
+
+func (s *importService) Find(ctx context.Context, id string) (model.ImportJobResponse, error) {
+	job, err := s.jobs.FindJob(ctx, id)
+	if err != nil {
+		return model.ImportJobResponse{}, err
+	}
+
+	return toImportJobResponse(job), nil
+}
+
+func (s *importService) ErrorReport(ctx context.Context, id string, fn func(model.ImportErrorResponse) error) error {
+	if _, err := s.jobs.FindJob(ctx, id); err != nil {
+		return err
+	}
+
+	return s.jobs.FindRejectedRows(ctx, id, func(row elasticsearch.RejectedRow) error {
+		return fn(model.ImportErrorResponse{Row: row.Row, Reason: row.Reason, Raw: row.Raw})
+	})
+}
+
+// Resume restarts the jobs that were queued or running when the application
+// stopped. They continue after the last checkpointed row.
+func (s *importService) Resume(ctx context.Context) error {
+	jobs, err := s.jobs.FindUnfinishedJobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The imports that stopped left the refresh interval relaxed; restore the
+	// one they recorded once the resumed jobs are done.
+	for _, job := range jobs {
+		if job.RefreshInterval != nil {
+			s.mu.Lock()
+			s.previous = job.RefreshInterval
+			s.mu.Unlock()
+			break
+		}
+	}
+
+	for _, job := range jobs {
+		log.Printf("resuming import %s after row %d", job.ID, job.Processed)
+		go s.run(job)
+	}
+
+	return nil
+}
+
+func (s *importService) run(job elasticsearch.ImportJob) {
+	ctx := context.Background()
+
+	s.relaxRefresh(ctx, &job)
+	defer s.restoreRefresh(ctx)
+
+	err := s.process(ctx, &job)
+
+	now := time.Now().UTC()
+	job.UpdatedAt = &now
+	job.FinishedAt = &now
+	job.Status = elasticsearch.ImportStatusCompleted
+	if err != nil {
+		job.Status = elasticsearch.ImportStatusFailed
+		job.Error = err.Error()
+	}
+
+	if err := s.jobs.SaveJob(ctx, job); err != nil {
+		log.Printf("import %s: cannot save final state: %v", job.ID, err)
+	}
+}
+
+func (s *importService) process(ctx context.Context, job *elasticsearch.ImportJob) error {
+	file, err := os.Open(job.Path)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	reader, err := newRowReader(job.Format, file)
+	if err != nil {
+		return err
+	}
+
+	for skipped := 0; skipped < job.Processed; skipped++ {
+		if _, err := reader.next(); err != nil {
+			return fmt.Errorf("skip to row %d: %w", job.Processed, err)
+		}
+	}
+
+	job.Status = elasticsearch.ImportStatusRunning
+	if err := s.jobs.SaveJob(ctx, *job); err != nil {
+		return err
+	}
+
+	for {
+		batch, readErr := readBatch(reader, importBatchSize)
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("read row %d: %w", job.Processed+len(batch)+1, readErr)
+		}
+
+		if len(batch) > 0 {
+			if err := s.importBatch(ctx, job, batch); err != nil {
+				return err
+			}
+
+			now := time.Now().UTC()
+			job.Processed += len(batch)
+			job.UpdatedAt = &now
+			if err := s.jobs.SaveJob(ctx, *job); err != nil {
+				return err
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+func readBatch(reader rowReader, size int) ([]importRow, error) {
+	batch := make([]importRow, 0, size)
+	for len(batch) < size {
+		row, err := reader.next()
+		if err != nil {
+			return batch, err
+		}
+		batch = append(batch, row)
+	}
+	return batch, nil
+}
+
+// importBatch validates the rows, bulk creates the valid ones and records
+// the rest as rejected. Document ids derive from the job and row number, so a
+// batch repeated after a restart reports conflicts instead of duplicating users.
+func (s *importService) importBatch(ctx context.Context, job *elasticsearch.ImportJob, batch []importRow) error {
+	var (
+		rejected   []elasticsearch.RejectedRow
+		operations []elasticsearch.BulkOperation
+		rows       []importRow
+	)
+
+	reject := func(row importRow, reason string) {
+		rejected = append(rejected, elasticsearch.RejectedRow{JobID: job.ID, Row: row.Number, Reason: reason, Raw: row.Raw})
+	}
+
+	for _, row := range batch {
+		if row.ParseErr != nil {
+			reject(row, row.ParseErr.Error())
+			continue
+		}
+		if err := s.validate.Struct(row.Request); err != nil {
+			reject(row, err.Error())
+			continue
+		}
+
+		cr := time.Now().UTC()
+		operations = append(operations, elasticsearch.BulkOperation{
+			Action: elasticsearch.BulkCreate,
+			User: elasticsearch.UserInfo{
+				ID:         uuid.NewSHA1(uuid.NameSpaceURL, []byte(fmt.Sprintf("import:%s:%d", job.ID, row.Number))).String(),
+				Name:       row.Request.Name,
+				Job:        row.Request.Job,
+				ChildNames: row.Request.ChildNames,
+				Comment:    row.Request.Comment,
+				CreatedAt:  &cr,
+				UpdatedAt:  &cr,
+				CreatedBy:  job.CreatedBy,
+				UpdatedBy:  job.CreatedBy,
+				Revision:   1,
+			},
+		})
+		rows = append(rows, row)
+	}
+
+	if len(operations) > 0 {
+		position := 0
+		results, err := s.storage.Bulk(ctx, func() (elasticsearch.BulkOperation, error) {
+			if position == len(operations) {
+				return elasticsearch.BulkOperation{}, io.EOF
+			}
+			position++
+			return operations[position-1], nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			switch {
+			case result.Error == "" || result.Status == 409:
+				job.Indexed++
+			default:
+				reject(rows[result.Position], result.Error)
+			}
+		}
+	}
+
+	if err := s.jobs.SaveRejectedRows(ctx, rejected); err != nil {
+		return err
+	}
+	job.Rejected += len(rejected)
+
+	return nil
+}
+
+// relaxRefresh relaxes the refresh interval when the first import starts and
+// records the interval it replaced in the job.
+func (s *importService) relaxRefresh(ctx context.Context, job *elasticsearch.ImportJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active++
+	if s.active == 1 {
+		if s.previous == nil {
+			current, err := s.indexManager.RefreshInterval(ctx)
+			if err != nil {
+				log.Printf("cannot read refresh interval before import: %v", err)
+				return
+			}
+			s.previous = &current
+		}
+		if err := s.indexManager.SetRefreshInterval(ctx, importRefreshInterval); err != nil {
+			log.Printf("cannot relax refresh interval for import: %v", err)
+		}
+	}
+	job.RefreshInterval = s.previous
+}
+
+// restoreRefresh puts the recorded refresh interval back when the last
+// import finished.
+func (s *importService) restoreRefresh(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active--
+	if s.active > 0 || s.previous == nil {
+		return
+	}
+	if err := s.indexManager.SetRefreshInterval(ctx, *s.previous); err != nil {
+		log.Printf("cannot restore refresh interval after import: %v", err)
+	}
+	s.previous = nil
+}
+
+func saveFile(path string, file io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		_ = os.Remove(path)
+		return err
+	}
+
+	return out.Close()
+}
+
+func toImportJobResponse(job elasticsearch.ImportJob) model.ImportJobResponse {
+	return model.ImportJobResponse{
+		ID:         job.ID,
+		Status:     job.Status,
+		Format:     job.Format,
+		FileName:   job.FileName,
+		Processed:  job.Processed,
+		Indexed:    job.Indexed,
+		Rejected:   job.Rejected,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		UpdatedAt:  job.UpdatedAt,
+		FinishedAt: job.FinishedAt,
+	}
+}
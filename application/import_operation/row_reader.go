@@ -0,0 +1,144 @@
+package import_operation
+
+import (
+	"bufio"
+	"bytes"
+	"elastic-project/model"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+
+	maxNDJSONLineSize = 1024 * 1024
+)
+
+// importRow is one data row of an import file. ParseErr is set when the row
+// could not be read into a CreateRequest; the row is then rejected.
+type importRow struct {
+	Number   int
+	Request  model.CreateRequest
+	Raw      string
+	ParseErr error
+}
+
+type rowReader interface {
+	// next returns the next data row, or io.EOF after the last one. Any other
+	// error means the file itself cannot be read any further.
+	next() (importRow, error)
+}
+
+func newRowReader(format string, r io.Reader) (rowReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVRowReader(r)
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxNDJSONLineSize)
+		return &ndjsonRowReader{scanner: scanner}, nil
+	default:
+		return nil, model.ErrInvalidFormat
+	}
+}
+
+// csvRowReader reads a CSV file whose header names the CreateRequest fields:
+// name, job, childNames and comment. childNames are separated by "|".
+type csvRowReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	number  int
+}
+
+func newCSVRowReader(r io.Reader) (*csvRowReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return &csvRowReader{reader: reader, columns: map[string]int{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, fmt.Errorf("csv header: missing name column")
+	}
+
+	return &csvRowReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvRowReader) next() (importRow, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return importRow{}, io.EOF
+	}
+
+	r.number++
+	row := importRow{Number: r.number, Raw: strings.Join(record, ",")}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		row.ParseErr = parseErr
+		return row, nil
+	}
+	if err != nil {
+		return importRow{}, err
+	}
+
+	column := func(name string) string {
+		i, ok := r.columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	row.Request = model.CreateRequest{
+		Name:    column("name"),
+		Job:     column("job"),
+		Comment: column("comment"),
+	}
+	if childNames := column("childNames"); childNames != "" {
+		row.Request.ChildNames = strings.Split(childNames, "|")
+	}
+
+	return row, nil
+}
+
+// ndjsonRowReader reads one CreateRequest JSON object per line.
+type ndjsonRowReader struct {
+	scanner *bufio.Scanner
+	number  int
+}
+
+func (r *ndjsonRowReader) next() (importRow, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		r.number++
+		row := importRow{Number: r.number, Raw: string(line)}
+		if err := json.Unmarshal(line, &row.Request); err != nil {
+			row.ParseErr = err
+		}
+		return row, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
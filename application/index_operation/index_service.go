@@ -0,0 +1,73 @@
+package index_operation
+
+import (
+	"context"
+	"elastic-project/application/task_operation"
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+)
+
+type indexService struct {
+	indexManager elasticsearch.IndexManager
+	tasks        task_operation.Service
+}
+
+type Service interface {
+	Reindex(ctx context.Context) (model.ReindexResponse, error)
+	StartReindex(ctx context.Context) (model.TaskResponse, error)
+	Rollback(ctx context.Context, req model.RollbackRequest) error
+	StartAuditBackfill(ctx context.Context) (model.TaskResponse, error)
+}
+
+func NewIndexService(indexManager elasticsearch.IndexManager, tasks task_operation.Service) Service {
+	return &indexService{indexManager: indexManager, tasks: tasks}
+}
+
+func (s indexService) Reindex(ctx context.Context) (model.ReindexResponse, error) {
+	result, err := s.indexManager.Reindex(ctx)
+	if err != nil {
+		return model.ReindexResponse{}, err
+	}
+
+	return model.ReindexResponse{
+		Source:         result.Source,
+		Destination:    result.Destination,
+		Documents:      result.DestinationCount,
+		MappingVersion: result.MappingVersion,
+	}, nil
+}
+
+// StartReindex starts copying into the next index as a task. The task
+// service moves the alias once the copy completed.
+func (s indexService) StartReindex(ctx context.Context) (model.TaskResponse, error) {
+	task, err := s.indexManager.StartReindex(ctx)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	return s.tasks.Track(ctx, elasticsearch.Task{
+		ID:          task.TaskID,
+		Kind:        elasticsearch.TaskReindex,
+		Source:      task.Source,
+		Destination: task.Destination,
+	})
+}
+
+func (s indexService) Rollback(ctx context.Context, req model.RollbackRequest) error {
+	if err := s.indexManager.Rollback(ctx, req.Index); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// StartAuditBackfill fills the audit fields of users written before they
+// existed, as an update by query task.
+func (s indexService) StartAuditBackfill(ctx context.Context) (model.TaskResponse, error) {
+	taskID, err := s.indexManager.StartAuditBackfill(ctx)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	return s.tasks.Track(ctx, elasticsearch.Task{ID: taskID, Kind: elasticsearch.TaskUpdateByQuery})
+}
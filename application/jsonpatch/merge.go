@@ -0,0 +1,66 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to a JSON document:
+//
+//	{"comment":null,"job":"doctor"}
+//	[{"op":"test","path":"/job","value":"engineer"},{"op":"add","path":"/childNames/-","value":"Ali"}]
+//
+// Both work on the decoded document and return it encoded again; the input is
+// never modified.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidPatch is returned for patches or documents that are not valid
+// JSON, or JSON Patch operations that are malformed.
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// OperationError is returned when a well formed JSON Patch operation cannot be
+// applied to the document, including a failed test. Index is the 0-based
+// position of the operation in the patch.
+type OperationError struct {
+	Index   int
+	Op      string
+	Message string
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %d (%s): %s", e.Index, e.Op, e.Message)
+}
+
+// Merge applies a JSON Merge Patch: members of the patch replace those of the
+// document, objects are merged recursively and null removes a member.
+func Merge(doc, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, fmt.Errorf("%w: document: %v", ErrInvalidPatch, err)
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	return json.Marshal(mergeValue(target, changes))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	merged, ok := target.(map[string]interface{})
+	if !ok {
+		merged = map[string]interface{}{}
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = mergeValue(merged[key], value)
+	}
+	return merged
+}
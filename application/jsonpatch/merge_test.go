@@ -0,0 +1,61 @@
+package jsonpatch
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestMergeRFC7396Examples runs the examples of RFC 7396 appendix A.
+func TestMergeRFC7396Examples(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{doc: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{doc: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{doc: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{doc: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{doc: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{doc: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{doc: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{doc: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{doc: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+		{doc: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+		{doc: `{"a":"foo"}`, patch: `null`, want: `null`},
+		{doc: `{"a":"foo"}`, patch: `"bar"`, want: `"bar"`},
+		{doc: `{"e":null}`, patch: `{"a":1}`, want: `{"e":null,"a":1}`},
+		{doc: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+		{doc: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.doc+" "+tt.patch, func(t *testing.T) {
+			got, err := Merge([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestMergeInvalidPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+	}{
+		{name: "patch", doc: `{"a":"b"}`, patch: `{"a":`},
+		{name: "document", doc: `{"a":`, patch: `{"a":"c"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Merge([]byte(tt.doc), []byte(tt.patch))
+			if !errors.Is(err, ErrInvalidPatch) {
+				t.Fatalf("Merge() error = %v, want ErrInvalidPatch", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type operation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// Apply applies the operations of a JSON Patch in order. The patch applies as
+// a whole or not at all: the first operation that fails stops it.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, fmt.Errorf("%w: document: %v", ErrInvalidPatch, err)
+	}
+
+	var operations []operation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	for i, op := range operations {
+		var err error
+		target, err = applyOperation(target, op)
+		if err != nil {
+			if opErr, ok := err.(*OperationError); ok {
+				opErr.Index, opErr.Op = i, op.Op
+			}
+			return nil, err
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc interface{}, op operation) (interface{}, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: %s operation needs a path", ErrInvalidPatch, op.Op)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: %s operation needs a value", ErrInvalidPatch, op.Op)
+		}
+		var value interface{}
+		if err := json.Unmarshal(*op.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			if _, err := get(doc, path); err != nil {
+				return nil, err
+			}
+			if len(path) > 0 {
+				if doc, _, err = remove(doc, path); err != nil {
+					return nil, err
+				}
+			}
+			return add(doc, path, value)
+		default:
+			current, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, &OperationError{Message: fmt.Sprintf("%s does not hold the tested value", *op.Path)}
+			}
+			return doc, nil
+		}
+	case "remove":
+		if len(path) == 0 {
+			return nil, &OperationError{Message: "the whole document cannot be removed"}
+		}
+		doc, _, err = remove(doc, path)
+		return doc, err
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: %s operation needs from", ErrInvalidPatch, op.Op)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			return add(doc, path, deepCopy(value))
+		}
+
+		if *op.From == *op.Path {
+			return doc, nil
+		}
+		if strings.HasPrefix(*op.Path, *op.From+"/") || len(from) == 0 {
+			return nil, &OperationError{Message: fmt.Sprintf("%s cannot be moved into itself", *op.From)}
+		}
+		if doc, _, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	}
+
+	return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped tokens.
+// The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, missing(token)
+			}
+			node = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, missing(token)
+		}
+	}
+	return node, nil
+}
+
+// add sets the value at path and returns the document, which is replaced
+// for an empty path. In arrays the value is inserted, "-" appends it.
+func add(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, missing(token)
+		}
+		child, err := add(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+	case []interface{}:
+		if len(rest) == 0 {
+			i := len(n)
+			if token != "-" {
+				var err error
+				if i, err = arrayIndex(token, len(n)); err != nil {
+					return nil, err
+				}
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		i, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		if n[i], err = add(n[i], rest, value); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+
+	return nil, missing(token)
+}
+
+// remove deletes the value at a non-empty path and returns the document and
+// the removed value.
+func remove(node interface{}, path []string) (interface{}, interface{}, error) {
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, missing(token)
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+		child, removed, err := remove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = child
+		return n, removed, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+		child, removed, err := remove(n[i], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[i] = child
+		return n, removed, nil
+	}
+
+	return nil, nil, missing(token)
+}
+
+// arrayIndex parses an array index token, which must not exceed max.
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, &OperationError{Message: fmt.Sprintf("%q is not an array index", token)}
+	}
+	if i > max {
+		return 0, &OperationError{Message: fmt.Sprintf("array index %d is out of bounds", i)}
+	}
+	return i, nil
+}
+
+func missing(token string) error {
+	return &OperationError{Message: fmt.Sprintf("%q does not exist", token)}
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			c[key] = deepCopy(child)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, child := range v {
+			c[i] = deepCopy(child)
+		}
+		return c
+	}
+	return value
+}
@@ -0,0 +1,171 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestApplyRFC6902Examples runs the examples of RFC 6902 appendix A.
+func TestApplyRFC6902Examples(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo":["all","grass","cows","eat"]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:  `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name:  "A.8 testing a value: success",
+			doc:   `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			want:  `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:    "A.9 testing a value: error",
+			doc:     `{"baz":"qux"}`,
+			patch:   `[{"op":"test","path":"/baz","value":"bar"}]`,
+			wantErr: true,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want:  `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want:  `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			name:    "A.12 adding to a nonexistent target",
+			doc:     `{"foo":"bar"}`,
+			patch:   `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			wantErr: true,
+		},
+		{
+			// encoding/json keeps the last of the repeated op members, so the
+			// patch fails as a remove of a missing member.
+			name:    "A.13 invalid JSON Patch document",
+			doc:     `{"foo":"bar"}`,
+			patch:   `[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`,
+			wantErr: true,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10}]`,
+			want:  `{"/":9,"~1":10}`,
+		},
+		{
+			name:    "A.15 comparing strings and numbers",
+			doc:     `{"/":9,"~1":10}`,
+			patch:   `[{"op":"test","path":"/~01","value":"10"}]`,
+			wantErr: true,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+			if tt.wantErr {
+				var opErr *OperationError
+				if !errors.As(err, &opErr) {
+					t.Fatalf("Apply() error = %v, want an *OperationError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestApplyInvalidPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{name: "not an array", patch: `{"op":"add","path":"/baz","value":"qux"}`},
+		{name: "missing path", patch: `[{"op":"add","value":"qux"}]`},
+		{name: "missing value", patch: `[{"op":"add","path":"/baz"}]`},
+		{name: "unknown op", patch: `[{"op":"merge","path":"/baz","value":"qux"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Apply([]byte(`{"foo":"bar"}`), []byte(tt.patch))
+			if !errors.Is(err, ErrInvalidPatch) {
+				t.Fatalf("Apply() error = %v, want ErrInvalidPatch", err)
+			}
+		})
+	}
+}
+
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("result %s is not JSON: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("expected %s is not JSON: %v", want, err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,227 @@
+package kql
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenQuoted
+	tokenColon
+	tokenLParen
+	tokenRParen
+	tokenRange
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of query"
+	case tokenWord:
+		return "word"
+	case tokenQuoted:
+		return "quoted phrase"
+	case tokenColon:
+		return `":"`
+	case tokenLParen:
+		return `"("`
+	case tokenRParen:
+		return `")"`
+	case tokenRange:
+		return "range operator"
+	case tokenAnd:
+		return `"and"`
+	case tokenOr:
+		return `"or"`
+	case tokenNot:
+		return `"not"`
+	default:
+		return "token"
+	}
+}
+
+// token is a lexeme of the query. Position is the 1-based character offset
+// of its first character, as reported in syntax errors.
+//
+// For words containing an unescaped * or ?, Wildcard is set and Pattern holds
+// the word in wildcard syntax with escaped characters kept escaped.
+type token struct {
+	Kind     tokenKind
+	Text     string
+	Pattern  string
+	Wildcard bool
+	Position int
+}
+
+type lexer struct {
+	input    string
+	offset   int
+	position int
+	// previous is the kind of the last token and listDepth the parentheses
+	// open in a field:(...) list. Words after a ":" or a range operator and
+	// words in such a list are values and may contain ":", as in
+	// 2022-01-31T10:00:00Z.
+	previous  tokenKind
+	listDepth int
+}
+
+func tokenize(input string) ([]token, error) {
+	l := &lexer{input: input, position: 1}
+
+	var tokens []token
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		switch {
+		case tok.Kind == tokenLParen && (l.previous == tokenColon || l.listDepth > 0):
+			l.listDepth++
+		case tok.Kind == tokenRParen && l.listDepth > 0:
+			l.listDepth--
+		}
+		l.previous = tok.Kind
+		if tok.Kind == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) peek() (rune, int) {
+	if l.offset >= len(l.input) {
+		return 0, 0
+	}
+	return utf8.DecodeRuneInString(l.input[l.offset:])
+}
+
+func (l *lexer) advance(width int) {
+	l.offset += width
+	l.position++
+}
+
+func (l *lexer) next() (token, error) {
+	for {
+		r, width := l.peek()
+		if width == 0 || !unicode.IsSpace(r) {
+			break
+		}
+		l.advance(width)
+	}
+
+	start := l.position
+	r, width := l.peek()
+	if width == 0 {
+		return token{Kind: tokenEOF, Position: start}, nil
+	}
+
+	switch r {
+	case ':':
+		l.advance(width)
+		return token{Kind: tokenColon, Text: ":", Position: start}, nil
+	case '(':
+		l.advance(width)
+		return token{Kind: tokenLParen, Text: "(", Position: start}, nil
+	case ')':
+		l.advance(width)
+		return token{Kind: tokenRParen, Text: ")", Position: start}, nil
+	case '<', '>':
+		l.advance(width)
+		text := string(r)
+		if next, nextWidth := l.peek(); next == '=' {
+			l.advance(nextWidth)
+			text += "="
+		}
+		return token{Kind: tokenRange, Text: text, Position: start}, nil
+	case '"':
+		return l.quoted(start)
+	}
+
+	return l.word(start), nil
+}
+
+func (l *lexer) quoted(start int) (token, error) {
+	l.advance(1)
+
+	var text strings.Builder
+	for {
+		r, width := l.peek()
+		if width == 0 {
+			return token{}, &SyntaxError{Position: start, Message: "unterminated quoted phrase"}
+		}
+		l.advance(width)
+
+		switch r {
+		case '"':
+			return token{Kind: tokenQuoted, Text: text.String(), Position: start}, nil
+		case '\\':
+			escaped, escapedWidth := l.peek()
+			if escapedWidth == 0 {
+				return token{}, &SyntaxError{Position: start, Message: "unterminated quoted phrase"}
+			}
+			l.advance(escapedWidth)
+			text.WriteRune(escaped)
+		default:
+			text.WriteRune(r)
+		}
+	}
+}
+
+func (l *lexer) word(start int) token {
+	var (
+		text     strings.Builder
+		pattern  strings.Builder
+		wildcard bool
+		escaped  bool
+	)
+	value := l.previous == tokenColon || l.previous == tokenRange || l.listDepth > 0
+	for {
+		r, width := l.peek()
+		if width == 0 || unicode.IsSpace(r) || strings.ContainsRune(`()<>"`, r) || (r == ':' && !value) {
+			break
+		}
+		l.advance(width)
+
+		if r == '\\' {
+			next, nextWidth := l.peek()
+			if nextWidth == 0 {
+				break
+			}
+			l.advance(nextWidth)
+			escaped = true
+			text.WriteRune(next)
+			if next == '*' || next == '?' || next == '\\' {
+				pattern.WriteRune('\\')
+			}
+			pattern.WriteRune(next)
+			continue
+		}
+
+		if r == '*' || r == '?' {
+			wildcard = true
+		}
+		text.WriteRune(r)
+		pattern.WriteRune(r)
+	}
+
+	word := text.String()
+	if !escaped {
+		switch strings.ToLower(word) {
+		case "and":
+			return token{Kind: tokenAnd, Text: word, Position: start}
+		case "or":
+			return token{Kind: tokenOr, Text: word, Position: start}
+		case "not":
+			return token{Kind: tokenNot, Text: word, Position: start}
+		}
+	}
+	return token{Kind: tokenWord, Text: word, Pattern: pattern.String(), Wildcard: wildcard, Position: start}
+}
@@ -0,0 +1,369 @@
+// Package kql translates a small KQL-like query language into an
+// Elasticsearch query clause:
+//
+//	job:engineer and (name:meh* or comment:"team lead")
+//	not childNames:*
+//	created_at >= 2022-01-31T10:00:00Z
+//
+// Terms combine with and, or, not and parentheses; adjacent terms without an
+// operator are combined with and. A field may take a list of values, as in
+// job:(engineer or doctor). Values may contain ":" but must not start with a
+// wildcard, and only whitelisted fields can be queried.
+package kql
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type FieldType int
+
+const (
+	Text FieldType = iota
+	Keyword
+	Date
+)
+
+// Field describes a queryable field: the Elasticsearch field it maps to and
+// how values are matched against it.
+type Field struct {
+	Name string
+	Type FieldType
+}
+
+// SyntaxError is returned for queries that cannot be parsed or use fields
+// that are not whitelisted. Position is the 1-based character offset.
+type SyntaxError struct {
+	Position int
+	Message  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Position, e.Message)
+}
+
+var (
+	// datePattern accepts the strict_date_optional_time dates of the mapping
+	// and epoch milliseconds.
+	datePattern = regexp.MustCompile(`^(\d{4}(-\d{2}(-\d{2}(T\d{2}(:\d{2}(:\d{2}([.,]\d{1,9})?)?)?(Z|[+-]\d{2}(:?\d{2})?)?)?)?)?|-?\d+)$`)
+	// dateMathPattern accepts the additions, subtractions and roundings that
+	// may follow now or a date and ||, e.g. the -7d/d of now-7d/d.
+	dateMathPattern = regexp.MustCompile(`^([+-]\d+[yMwdhHms]|/[yMwdhHms])*$`)
+)
+
+type parser struct {
+	tokens []token
+	index  int
+	fields map[string]Field
+}
+
+// Parse turns the query into an Elasticsearch query clause. An empty query
+// matches every document.
+func Parse(query string, fields map[string]Field) (map[string]interface{}, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, fields: fields}
+	if p.peek().Kind == tokenEOF {
+		return map[string]interface{}{"match_all": map[string]interface{}{}}, nil
+	}
+
+	clause, err := p.orExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.Kind != tokenEOF {
+		return nil, p.unexpected(tok, "and, or or end of query")
+	}
+
+	return clause, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.index]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.index]
+	if tok.Kind != tokenEOF {
+		p.index++
+	}
+	return tok
+}
+
+func (p *parser) unexpected(tok token, expected string) error {
+	found := tok.Kind.String()
+	if tok.Text != "" {
+		found = fmt.Sprintf("%q", tok.Text)
+	}
+	return &SyntaxError{Position: tok.Position, Message: fmt.Sprintf("expected %s, found %s", expected, found)}
+}
+
+func (p *parser) orExpr() (map[string]interface{}, error) {
+	return p.binary(tokenOr, p.andExpr, func(clauses []interface{}) map[string]interface{} {
+		return boolQuery("should", clauses, true)
+	})
+}
+
+func (p *parser) andExpr() (map[string]interface{}, error) {
+	first, err := p.notExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	clauses := []interface{}{first}
+	for {
+		tok := p.peek()
+		switch tok.Kind {
+		case tokenAnd:
+			p.next()
+		case tokenWord, tokenNot, tokenLParen:
+		default:
+			if len(clauses) == 1 {
+				return first, nil
+			}
+			return boolQuery("must", clauses, false), nil
+		}
+
+		clause, err := p.notExpr()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+}
+
+func (p *parser) binary(
+	operator tokenKind,
+	operand func() (map[string]interface{}, error),
+	combine func([]interface{}) map[string]interface{}) (map[string]interface{}, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	clauses := []interface{}{first}
+	for p.peek().Kind == operator {
+		p.next()
+		clause, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+
+	if len(clauses) == 1 {
+		return first, nil
+	}
+	return combine(clauses), nil
+}
+
+func (p *parser) notExpr() (map[string]interface{}, error) {
+	if p.peek().Kind != tokenNot {
+		return p.primary()
+	}
+
+	p.next()
+	clause, err := p.notExpr()
+	if err != nil {
+		return nil, err
+	}
+	return boolQuery("must_not", []interface{}{clause}, false), nil
+}
+
+func (p *parser) primary() (map[string]interface{}, error) {
+	tok := p.next()
+	switch tok.Kind {
+	case tokenLParen:
+		clause, err := p.orExpr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.Kind != tokenRParen {
+			return nil, p.unexpected(closing, `")"`)
+		}
+		return clause, nil
+	case tokenWord:
+		return p.fieldExpr(tok)
+	default:
+		return nil, p.unexpected(tok, "field name or (")
+	}
+}
+
+func (p *parser) fieldExpr(name token) (map[string]interface{}, error) {
+	field, ok := p.fields[name.Text]
+	if !ok {
+		return nil, &SyntaxError{Position: name.Position, Message: fmt.Sprintf("unknown field %q, allowed fields are %s", name.Text, p.fieldNames())}
+	}
+
+	tok := p.next()
+	switch tok.Kind {
+	case tokenColon:
+		return p.valueExpr(field)
+	case tokenRange:
+		return p.rangeExpr(field, tok)
+	default:
+		return nil, p.unexpected(tok, `":" or a range operator`)
+	}
+}
+
+func (p *parser) valueExpr(field Field) (map[string]interface{}, error) {
+	tok := p.next()
+	switch tok.Kind {
+	case tokenWord, tokenQuoted:
+		return valueQuery(field, tok)
+	case tokenLParen:
+		return p.valueList(field)
+	default:
+		return nil, p.unexpected(tok, "value")
+	}
+}
+
+// valueList parses the values of field:(a or b and c) up to the closing
+// parenthesis, using the same operator precedence as the rest of the query.
+func (p *parser) valueList(field Field) (map[string]interface{}, error) {
+	var orExpr, andExpr, value func() (map[string]interface{}, error)
+
+	value = func() (map[string]interface{}, error) {
+		tok := p.next()
+		switch tok.Kind {
+		case tokenWord, tokenQuoted:
+			return valueQuery(field, tok)
+		case tokenNot:
+			clause, err := value()
+			if err != nil {
+				return nil, err
+			}
+			return boolQuery("must_not", []interface{}{clause}, false), nil
+		case tokenLParen:
+			clause, err := orExpr()
+			if err != nil {
+				return nil, err
+			}
+			if closing := p.next(); closing.Kind != tokenRParen {
+				return nil, p.unexpected(closing, `")"`)
+			}
+			return clause, nil
+		default:
+			return nil, p.unexpected(tok, "value")
+		}
+	}
+	andExpr = func() (map[string]interface{}, error) {
+		return p.binary(tokenAnd, value, func(clauses []interface{}) map[string]interface{} {
+			return boolQuery("must", clauses, false)
+		})
+	}
+	orExpr = func() (map[string]interface{}, error) {
+		return p.binary(tokenOr, andExpr, func(clauses []interface{}) map[string]interface{} {
+			return boolQuery("should", clauses, true)
+		})
+	}
+
+	clause, err := orExpr()
+	if err != nil {
+		return nil, err
+	}
+	if closing := p.next(); closing.Kind != tokenRParen {
+		return nil, p.unexpected(closing, `")"`)
+	}
+	return clause, nil
+}
+
+func (p *parser) rangeExpr(field Field, operator token) (map[string]interface{}, error) {
+	if field.Type != Date {
+		return nil, &SyntaxError{Position: operator.Position, Message: fmt.Sprintf("range operators are not supported on %s", field.Name)}
+	}
+
+	tok := p.next()
+	if tok.Kind != tokenWord && tok.Kind != tokenQuoted {
+		return nil, p.unexpected(tok, "value")
+	}
+	if err := checkDate(field, tok); err != nil {
+		return nil, err
+	}
+
+	operators := map[string]string{"<": "lt", "<=": "lte", ">": "gt", ">=": "gte"}
+	return map[string]interface{}{
+		"range": map[string]interface{}{
+			field.Name: map[string]interface{}{operators[operator.Text]: tok.Text},
+		},
+	}, nil
+}
+
+func (p *parser) fieldNames() string {
+	names := make([]string, 0, len(p.fields))
+	for name := range p.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func valueQuery(field Field, tok token) (map[string]interface{}, error) {
+	if tok.Kind == tokenWord && tok.Text == "*" {
+		return map[string]interface{}{"exists": map[string]interface{}{"field": field.Name}}, nil
+	}
+
+	if field.Type == Date {
+		if err := checkDate(field, tok); err != nil {
+			return nil, err
+		}
+	}
+
+	if tok.Kind == tokenWord && tok.Wildcard {
+		if strings.HasPrefix(tok.Pattern, "*") || strings.HasPrefix(tok.Pattern, "?") {
+			return nil, &SyntaxError{Position: tok.Position, Message: fmt.Sprintf("leading wildcard in %q on %s is not allowed", tok.Text, field.Name)}
+		}
+		return map[string]interface{}{
+			"wildcard": map[string]interface{}{
+				field.Name: map[string]interface{}{"value": tok.Pattern, "case_insensitive": true},
+			},
+		}, nil
+	}
+
+	switch field.Type {
+	case Keyword, Date:
+		return map[string]interface{}{"term": map[string]interface{}{field.Name: tok.Text}}, nil
+	}
+
+	if tok.Kind == tokenQuoted {
+		return map[string]interface{}{"match_phrase": map[string]interface{}{field.Name: tok.Text}}, nil
+	}
+	return map[string]interface{}{"match": map[string]interface{}{field.Name: tok.Text}}, nil
+}
+
+// checkDate rejects values of a date field that Elasticsearch would fail to
+// parse, instead of failing the whole search there.
+func checkDate(field Field, tok token) error {
+	if tok.Kind == tokenWord && tok.Wildcard {
+		return &SyntaxError{Position: tok.Position, Message: fmt.Sprintf("wildcards are not supported on %s", field.Name)}
+	}
+	if !isDate(tok.Text) {
+		return &SyntaxError{Position: tok.Position, Message: fmt.Sprintf("%q is not a date, expected e.g. 2022-01-31, 2022-01-31T10:00:00Z or now-7d", tok.Text)}
+	}
+	return nil
+}
+
+func isDate(text string) bool {
+	if strings.HasPrefix(text, "now") {
+		return dateMathPattern.MatchString(text[len("now"):])
+	}
+	anchor, math := text, ""
+	if i := strings.Index(text, "||"); i >= 0 {
+		anchor, math = text[:i], text[i+2:]
+	}
+	return datePattern.MatchString(anchor) && dateMathPattern.MatchString(math)
+}
+
+func boolQuery(occur string, clauses []interface{}, minimumShouldMatch bool) map[string]interface{} {
+	query := map[string]interface{}{occur: clauses}
+	if minimumShouldMatch {
+		query["minimum_should_match"] = 1
+	}
+	return map[string]interface{}{"bool": query}
+}
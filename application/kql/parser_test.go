@@ -0,0 +1,251 @@
+package kql
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testFields = map[string]Field{
+	"id":         {Name: "id", Type: Keyword},
+	"name":       {Name: "name", Type: Text},
+	"job":        {Name: "job", Type: Text},
+	"comment":    {Name: "comment", Type: Text},
+	"created_at": {Name: "created_at", Type: Date},
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "empty query",
+			query: "  ",
+			want:  `{"match_all":{}}`,
+		},
+		{
+			name:  "text and keyword fields",
+			query: "name:ahmet id:42",
+			want:  `{"bool":{"must":[{"match":{"name":"ahmet"}},{"term":{"id":"42"}}]}}`,
+		},
+		{
+			name:  "and binds tighter than or",
+			query: "name:a or name:b and job:c",
+			want: `{"bool":{"minimum_should_match":1,"should":[{"match":{"name":"a"}},` +
+				`{"bool":{"must":[{"match":{"name":"b"}},{"match":{"job":"c"}}]}}]}}`,
+		},
+		{
+			name:  "not binds tighter than and",
+			query: "not name:a and job:c",
+			want:  `{"bool":{"must":[{"bool":{"must_not":[{"match":{"name":"a"}}]}},{"match":{"job":"c"}}]}}`,
+		},
+		{
+			name:  "parentheses",
+			query: "(name:a or name:b) job:c",
+			want: `{"bool":{"must":[{"bool":{"minimum_should_match":1,"should":[{"match":{"name":"a"}},{"match":{"name":"b"}}]}},` +
+				`{"match":{"job":"c"}}]}}`,
+		},
+		{
+			name:  "value list",
+			query: "job:(engineer or not doctor)",
+			want: `{"bool":{"minimum_should_match":1,"should":[{"match":{"job":"engineer"}},` +
+				`{"bool":{"must_not":[{"match":{"job":"doctor"}}]}}]}}`,
+		},
+		{
+			name:  "operators are case insensitive",
+			query: "name:a OR name:b",
+			want:  `{"bool":{"minimum_should_match":1,"should":[{"match":{"name":"a"}},{"match":{"name":"b"}}]}}`,
+		},
+		{
+			name:  "quoted phrase with escaped quotes",
+			query: `comment:"team \"lead\""`,
+			want:  `{"match_phrase":{"comment":"team \"lead\""}}`,
+		},
+		{
+			name:  "quoted operator is a value",
+			query: `name:"and"`,
+			want:  `{"match_phrase":{"name":"and"}}`,
+		},
+		{
+			name:  "escaped operator is a value",
+			query: `name:\or`,
+			want:  `{"match":{"name":"or"}}`,
+		},
+		{
+			name:  "escaped wildcard",
+			query: `name:a\*b`,
+			want:  `{"match":{"name":"a*b"}}`,
+		},
+		{
+			name:  "wildcard keeps escaped characters escaped",
+			query: `name:me\*h*`,
+			want:  `{"wildcard":{"name":{"case_insensitive":true,"value":"me\\*h*"}}}`,
+		},
+		{
+			name:  "exists",
+			query: "not comment:*",
+			want:  `{"bool":{"must_not":[{"exists":{"field":"comment"}}]}}`,
+		},
+		{
+			name:  "value with a colon",
+			query: "id:urn:user:1",
+			want:  `{"term":{"id":"urn:user:1"}}`,
+		},
+		{
+			name:  "range on a date with a time",
+			query: "created_at >= 2022-01-31T10:00:00Z",
+			want:  `{"range":{"created_at":{"gte":"2022-01-31T10:00:00Z"}}}`,
+		},
+		{
+			name:  "range on date math",
+			query: "created_at<now-7d/d",
+			want:  `{"range":{"created_at":{"lt":"now-7d/d"}}}`,
+		},
+		{
+			name:  "dates with a time in a value list",
+			query: `created_at:(2022-01-31T10:00:00Z or "2022-02-01T10:00:00+03:00")`,
+			want: `{"bool":{"minimum_should_match":1,"should":[{"term":{"created_at":"2022-01-31T10:00:00Z"}},` +
+				`{"term":{"created_at":"2022-02-01T10:00:00+03:00"}}]}}`,
+		},
+		{
+			name:  "field names after a value list",
+			query: "job:(a or b) name:c",
+			want: `{"bool":{"must":[{"bool":{"minimum_should_match":1,"should":[{"match":{"job":"a"}},{"match":{"job":"b"}}]}},` +
+				`{"match":{"name":"c"}}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.query, testFields)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.query, err)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		wantPosition int
+		wantMessage  string
+	}{
+		{
+			name:         "unknown field",
+			query:        "name:a and salary:1",
+			wantPosition: 12,
+			wantMessage:  `unknown field "salary", allowed fields are comment, created_at, id, job, name`,
+		},
+		{
+			name:         "missing value",
+			query:        "name:",
+			wantPosition: 6,
+			wantMessage:  "expected value, found end of query",
+		},
+		{
+			name:         "missing colon",
+			query:        "name ahmet",
+			wantPosition: 6,
+			wantMessage:  `expected ":" or a range operator, found "ahmet"`,
+		},
+		{
+			name:         "operator without operand",
+			query:        "and name:a",
+			wantPosition: 1,
+			wantMessage:  `expected field name or (, found "and"`,
+		},
+		{
+			name:         "unclosed parenthesis",
+			query:        "(name:a or name:b",
+			wantPosition: 18,
+			wantMessage:  `expected ")", found end of query`,
+		},
+		{
+			name:         "unclosed value list",
+			query:        "name:(a or b",
+			wantPosition: 13,
+			wantMessage:  `expected ")", found end of query`,
+		},
+		{
+			name:         "stray parenthesis",
+			query:        "name:a )",
+			wantPosition: 8,
+			wantMessage:  `expected and, or or end of query, found ")"`,
+		},
+		{
+			name:         "unterminated quoted phrase",
+			query:        `name:a comment:"team`,
+			wantPosition: 16,
+			wantMessage:  "unterminated quoted phrase",
+		},
+		{
+			name:         "range on a text field",
+			query:        "job >= 2022",
+			wantPosition: 5,
+			wantMessage:  "range operators are not supported on job",
+		},
+		{
+			name:         "range on something else than a date",
+			query:        "created_at >= yesterday",
+			wantPosition: 15,
+			wantMessage:  `"yesterday" is not a date, expected e.g. 2022-01-31, 2022-01-31T10:00:00Z or now-7d`,
+		},
+		{
+			name:         "wildcard on a date",
+			query:        "created_at:2022*",
+			wantPosition: 12,
+			wantMessage:  "wildcards are not supported on created_at",
+		},
+		{
+			name:         "leading wildcard",
+			query:        "name:*x",
+			wantPosition: 6,
+			wantMessage:  `leading wildcard in "*x" on name is not allowed`,
+		},
+		{
+			name:         "leading wildcard in a value list",
+			query:        "job:(dev or ?ev)",
+			wantPosition: 13,
+			wantMessage:  `leading wildcard in "?ev" on job is not allowed`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.query, testFields)
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Parse(%q) error = %v, want a SyntaxError", tt.query, err)
+			}
+			if syntaxErr.Position != tt.wantPosition || syntaxErr.Message != tt.wantMessage {
+				t.Errorf("Parse(%q) error = %v, want position %d: %s", tt.query, err, tt.wantPosition, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func assertJSONEqual(t *testing.T, got interface{}, want string) {
+	t.Helper()
+
+	bdy, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(bdy, &gotValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("want is not JSON: %v", err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("got %s, want %s", bdy, want)
+	}
+}
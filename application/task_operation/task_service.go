@@ -0,0 +1,208 @@
+package task_operation
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch"
+	"elastic-project/model"
+	"log"
+	"sync"
+	"time"
+)
+
+type taskService struct {
+	tasks        elasticsearch.TaskStorer
+	indexManager elasticsearch.IndexManager
+	interval     time.Duration
+
+	// mu serializes refreshes, so a finished reindex moves the alias once.
+	mu sync.Mutex
+}
+
+type Service interface {
+	Track(ctx context.Context, task elasticsearch.Task) (model.TaskResponse, error)
+	Find(ctx context.Context, id string) (model.TaskResponse, error)
+	Cancel(ctx context.Context, id string) (model.TaskResponse, error)
+	Run(ctx context.Context)
+}
+
+// NewTaskService creates the service following tasks started with
+// wait_for_completion=false. Run refreshes the unfinished ones every
+// interval, so a reindex is finished even if nobody asks for it.
+func NewTaskService(tasks elasticsearch.TaskStorer, indexManager elasticsearch.IndexManager, interval time.Duration) Service {
+	return &taskService{
+		tasks:        tasks,
+		indexManager: indexManager,
+		interval:     interval,
+	}
+}
+
+// Track records a task that was just started.
+func (s *taskService) Track(ctx context.Context, task elasticsearch.Task) (model.TaskResponse, error) {
+	now := time.Now().UTC()
+	task.Status = elasticsearch.TaskStatusRunning
+	task.CreatedBy = model.ActorFromContext(ctx)
+	task.CreatedAt = &now
+	task.UpdatedAt = &now
+
+	if err := s.tasks.SaveTask(ctx, task); err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	return toTaskResponse(task), nil
+}
+
+func (s *taskService) Find(ctx context.Context, id string) (model.TaskResponse, error) {
+	task, err := s.tasks.FindTask(ctx, id)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	task, err = s.refresh(ctx, task)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	return toTaskResponse(task), nil
+}
+
+// Cancel asks Elasticsearch to cancel a running task. It returns
+// model.ErrConflict if the task already finished.
+func (s *taskService) Cancel(ctx context.Context, id string) (model.TaskResponse, error) {
+	task, err := s.tasks.FindTask(ctx, id)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+	if task.Status != elasticsearch.TaskStatusRunning {
+		return model.TaskResponse{}, model.ErrConflict
+	}
+
+	if err := s.tasks.CancelTask(ctx, id); err != nil && model.ErrNotFound != err {
+		return model.TaskResponse{}, err
+	}
+
+	task, err = s.refresh(ctx, task)
+	if err != nil {
+		return model.TaskResponse{}, err
+	}
+
+	return toTaskResponse(task), nil
+}
+
+// Run refreshes the unfinished tasks right away and then every interval
+// until ctx is done. Tasks left running by a previous start are picked up.
+func (s *taskService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		tasks, err := s.tasks.FindUnfinishedTasks(ctx)
+		if err != nil {
+			log.Printf("cannot find unfinished tasks: %v", err)
+		}
+		for _, task := range tasks {
+			if _, err := s.refresh(ctx, task); err != nil {
+				log.Printf("cannot refresh task %s: %v", task.ID, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh updates a running task from the tasks API. Once the task completed
+// it records the outcome; a completed reindex is finished by moving the alias,
+// a failed or cancelled one drops its destination index.
+func (s *taskService) refresh(ctx context.Context, task elasticsearch.Task) (elasticsearch.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if task.Status != elasticsearch.TaskStatusRunning {
+		return task, nil
+	}
+	current, err := s.tasks.FindTask(ctx, task.ID)
+	if err != nil {
+		return task, err
+	}
+	if current.Status != elasticsearch.TaskStatusRunning {
+		return current, nil
+	}
+
+	now := time.Now().UTC()
+	task.UpdatedAt = &now
+
+	state, err := s.tasks.FetchTaskState(ctx, task.ID)
+	switch {
+	case model.ErrNotFound == err:
+		state = elasticsearch.TaskState{Completed: true, Error: "task is no longer known to Elasticsearch"}
+	case err != nil:
+		return task, err
+	}
+
+	task.Progress = state.Progress
+	task.Failures = state.Failures
+	if state.Completed {
+		task.FinishedAt = &now
+		task.Status, task.Error = s.outcome(ctx, task, state)
+	}
+
+	if err := s.tasks.SaveTask(ctx, task); err != nil {
+		return task, err
+	}
+
+	return task, nil
+}
+
+func (s *taskService) outcome(ctx context.Context, task elasticsearch.Task, state elasticsearch.TaskState) (string, string) {
+	status, reason := elasticsearch.TaskStatusCompleted, ""
+	switch {
+	case state.Cancelled:
+		status = elasticsearch.TaskStatusCancelled
+	case state.Error != "":
+		status, reason = elasticsearch.TaskStatusFailed, state.Error
+	case len(state.Failures) > 0 && task.Kind == elasticsearch.TaskReindex:
+		status, reason = elasticsearch.TaskStatusFailed, "documents could not be copied"
+	}
+
+	if task.Kind != elasticsearch.TaskReindex {
+		return status, reason
+	}
+
+	if status != elasticsearch.TaskStatusCompleted {
+		if err := s.indexManager.AbortReindex(ctx, task.Source, task.Destination); err != nil {
+			log.Printf("task %s: %v", task.ID, err)
+		}
+		return status, reason
+	}
+
+	if _, err := s.indexManager.FinishReindex(ctx, task.Source, task.Destination); err != nil {
+		return elasticsearch.TaskStatusFailed, err.Error()
+	}
+	return status, reason
+}
+
+func toTaskResponse(task elasticsearch.Task) model.TaskResponse {
+	return model.TaskResponse{
+		ID:          task.ID,
+		Kind:        task.Kind,
+		Status:      task.Status,
+		Source:      task.Source,
+		Destination: task.Destination,
+		Progress: model.TaskProgressResponse{
+			Total:            task.Progress.Total,
+			Created:          task.Progress.Created,
+			Updated:          task.Progress.Updated,
+			Deleted:          task.Progress.Deleted,
+			VersionConflicts: task.Progress.VersionConflicts,
+		},
+		Failures:   task.Failures,
+		Error:      task.Error,
+		CreatedBy:  task.CreatedBy,
+		CreatedAt:  task.CreatedAt,
+		UpdatedAt:  task.UpdatedAt,
+		FinishedAt: task.FinishedAt,
+	}
+}
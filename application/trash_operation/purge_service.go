@@ -0,0 +1,54 @@
+package trash_operation
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch"
+	"log"
+	"time"
+)
+
+type purgeService struct {
+	storage   elasticsearch.UserInfoStorer
+	retention time.Duration
+	interval  time.Duration
+}
+
+type Service interface {
+	Purge(ctx context.Context) (int64, error)
+	Run(ctx context.Context)
+}
+
+// NewPurgeService creates the job that hard deletes users soft deleted more
+// than retention ago. Run checks every interval.
+func NewPurgeService(storage elasticsearch.UserInfoStorer, retention time.Duration, interval time.Duration) Service {
+	return &purgeService{
+		storage:   storage,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+func (s *purgeService) Purge(ctx context.Context) (int64, error) {
+	return s.storage.PurgeDeleted(ctx, time.Now().Add(-s.retention))
+}
+
+// Run purges once right away and then every interval until ctx is done.
+func (s *purgeService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := s.Purge(ctx)
+		if err != nil {
+			log.Printf("cannot purge deleted users: %v", err)
+		} else if purged > 0 {
+			log.Printf("purged %d users deleted more than %s ago", purged, s.retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
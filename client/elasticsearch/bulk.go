@@ -0,0 +1,215 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	BulkCreate = "create"
+	BulkIndex  = "index"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+
+	// bulkIndexScript replaces an existing user with params.user but keeps
+	// when and by whom it was created and counts on its revision.
+	bulkIndexScript = `def createdAt = ctx._source.created_at; def createdBy = ctx._source.created_by;
+def revision = ctx._source.revision == null ? 1 : ctx._source.revision;
+ctx._source.clear(); ctx._source.putAll(params.user);
+if (createdAt != null) { ctx._source.created_at = createdAt; }
+if (createdBy != null) { ctx._source.created_by = createdBy; }
+ctx._source.revision = revision + 1;`
+
+	// bulkUpdateScript sets the fields in params.doc, leaving the others as
+	// they are, and counts on the revision. Deleted users are left alone.
+	bulkUpdateScript = `if (ctx._source.deleted_at != null) { ctx.op = 'noop'; return; }
+ctx._source.putAll(params.doc);
+ctx._source.revision = (ctx._source.revision == null ? 1 : ctx._source.revision) + 1;`
+
+	// bulkSoftDeleteScript sets deleted_at of a user that is not deleted yet.
+	bulkSoftDeleteScript = `if (ctx._source.deleted_at != null) { ctx.op = 'noop'; return; }
+ctx._source.deleted_at = params.deleted_at; ctx._source.updated_at = params.deleted_at; ctx._source.updated_by = params.updated_by;
+ctx._source.revision = (ctx._source.revision == null ? 1 : ctx._source.revision) + 1;`
+)
+
+// BulkOperation is a single action of a bulk request. An operation with
+// Rejected set is not sent; it is reported as a 400 item with that error. An
+// update sets the fields of Doc, by their JSON name, on the user User.ID. A
+// delete with Soft set marks the user as deleted at User.DeletedAt instead of
+// removing it.
+type BulkOperation struct {
+	Action   string
+	User     UserInfo
+	Doc      map[string]interface{}
+	Soft     bool
+	Rejected error
+}
+
+type BulkItemResult struct {
+	Position int
+	Action   string
+	ID       string
+	Status   int
+	Result   string
+	Error    string
+}
+
+// Bulk streams the operations returned by next through a bulk indexer until
+// next returns io.EOF. Any other error from next stops reading; the items
+// added so far are still flushed and their results returned with the error.
+func (p UserInfoStorage) Bulk(ctx context.Context, next func() (BulkOperation, error)) ([]BulkItemResult, error) {
+	var (
+		mu      sync.Mutex
+		results []BulkItemResult
+	)
+	record := func(result BulkItemResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, result)
+	}
+
+	indexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Client: p.elastic.client,
+		Index:  p.elastic.alias,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bulk: indexer: %w", err)
+	}
+
+	var nextErr error
+	for position := 0; ; position++ {
+		operation, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			nextErr = err
+			break
+		}
+
+		if operation.Rejected != nil {
+			record(BulkItemResult{Position: position, Action: operation.Action, ID: operation.User.ID, Status: http.StatusBadRequest, Error: operation.Rejected.Error()})
+			continue
+		}
+
+		item, err := bulkIndexerItem(position, operation, record)
+		if err != nil {
+			record(BulkItemResult{Position: position, Action: operation.Action, ID: operation.User.ID, Error: err.Error()})
+			continue
+		}
+
+		if err := indexer.Add(ctx, item); err != nil {
+			record(BulkItemResult{Position: position, Action: operation.Action, ID: operation.User.ID, Error: err.Error()})
+		}
+	}
+
+	if err := indexer.Close(ctx); err != nil {
+		return nil, fmt.Errorf("bulk: close: %w", err)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Position < results[j].Position
+	})
+
+	return results, nextErr
+}
+
+func bulkIndexerItem(position int, operation BulkOperation, record func(BulkItemResult)) (esutil.BulkIndexerItem, error) {
+	item := esutil.BulkIndexerItem{
+		Action:     operation.Action,
+		DocumentID: operation.User.ID,
+		OnSuccess: func(_ context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+			result := res.Result
+			if operation.Soft && result == "updated" {
+				result = "deleted"
+			}
+			record(BulkItemResult{
+				Position: position,
+				Action:   operation.Action,
+				ID:       res.DocumentID,
+				Status:   res.Status,
+				Result:   result,
+			})
+		},
+		OnFailure: func(_ context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+			result := BulkItemResult{
+				Position: position,
+				Action:   operation.Action,
+				ID:       item.DocumentID,
+				Status:   res.Status,
+				Error:    res.Error.Type + ": " + res.Error.Reason,
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			record(result)
+		},
+	}
+
+	switch operation.Action {
+	case BulkCreate:
+		bdy, err := json.Marshal(operation.User)
+		if err != nil {
+			return esutil.BulkIndexerItem{}, fmt.Errorf("marshall: %w", err)
+		}
+		item.Body = bytes.NewReader(bdy)
+	case BulkIndex:
+		// An index is sent as an upsert, so overwriting a user keeps its
+		// creation fields and counts on its revision.
+		bdy, err := json.Marshal(map[string]interface{}{
+			"script": map[string]interface{}{
+				"lang":   "painless",
+				"source": bulkIndexScript,
+				"params": map[string]interface{}{"user": operation.User},
+			},
+			"upsert": operation.User,
+		})
+		if err != nil {
+			return esutil.BulkIndexerItem{}, fmt.Errorf("marshall: %w", err)
+		}
+		item.Action = BulkUpdate
+		item.Body = bytes.NewReader(bdy)
+	case BulkUpdate:
+		bdy, err := json.Marshal(map[string]interface{}{
+			"script": map[string]interface{}{
+				"lang":   "painless",
+				"source": bulkUpdateScript,
+				"params": map[string]interface{}{"doc": operation.Doc},
+			},
+		})
+		if err != nil {
+			return esutil.BulkIndexerItem{}, fmt.Errorf("marshall: %w", err)
+		}
+		item.Body = bytes.NewReader(bdy)
+	case BulkDelete:
+		if !operation.Soft {
+			break
+		}
+		bdy, err := json.Marshal(map[string]interface{}{
+			"script": map[string]interface{}{
+				"lang":   "painless",
+				"source": bulkSoftDeleteScript,
+				"params": map[string]interface{}{
+					"deleted_at": operation.User.DeletedAt,
+					"updated_by": operation.User.UpdatedBy,
+				},
+			},
+		})
+		if err != nil {
+			return esutil.BulkIndexerItem{}, fmt.Errorf("marshall: %w", err)
+		}
+		item.Action = BulkUpdate
+		item.Body = bytes.NewReader(bdy)
+	default:
+		return esutil.BulkIndexerItem{}, fmt.Errorf("unknown action %q", operation.Action)
+	}
+
+	return item, nil
+}
@@ -0,0 +1,57 @@
+package elasticsearch
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch/estest"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestUserInfoStorageBulkUpdate(t *testing.T) {
+	es, storage := newTestStorage(t)
+	es.On(http.MethodPost, "/user_alias/_bulk", estest.JSON(http.StatusOK, map[string]interface{}{
+		"took": 1, "errors": false,
+		"items": []interface{}{
+			map[string]interface{}{"update": map[string]interface{}{"_index": "user_v1", "_id": "1", "status": 200, "result": "noop"}},
+		},
+	}))
+
+	operations := []BulkOperation{{Action: BulkUpdate, User: UserInfo{ID: "1"}, Doc: map[string]interface{}{"comment": "x"}}}
+	results, err := storage.Bulk(context.Background(), func() (BulkOperation, error) {
+		if len(operations) == 0 {
+			return BulkOperation{}, io.EOF
+		}
+		operation := operations[0]
+		operations = operations[1:]
+		return operation, nil
+	})
+	if err != nil {
+		t.Fatalf("Bulk() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Result != "noop" {
+		t.Errorf("results = %+v, want one noop", results)
+	}
+
+	requests := assertRequests(t, es, "POST /user_alias/_bulk")
+	lines := requests[0].Lines()
+	if len(lines) != 2 {
+		t.Fatalf("bulk body has %d lines, want 2", len(lines))
+	}
+	var update struct {
+		Script struct {
+			Source string                 `json:"source"`
+			Params map[string]interface{} `json:"params"`
+		} `json:"script"`
+	}
+	if err := (estest.Request{Body: lines[1]}).Decode(&update); err != nil {
+		t.Fatalf("update line %s: %v", lines[1], err)
+	}
+	if update.Script.Source != bulkUpdateScript {
+		t.Errorf("script = %q, want bulkUpdateScript", update.Script.Source)
+	}
+	if !reflect.DeepEqual(update.Script.Params, map[string]interface{}{"doc": map[string]interface{}{"comment": "x"}}) {
+		t.Errorf("params = %v, want only the comment", update.Script.Params)
+	}
+}
@@ -0,0 +1,179 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"time"
+)
+
+const setFieldsScript = `for (entry in params.set.entrySet()) { ctx._source[entry.getKey()] = entry.getValue(); }
+ctx._source.updated_at = params.updated_at; ctx._source.updated_by = params.updated_by;
+ctx._source.revision = (ctx._source.revision == null ? 1 : ctx._source.revision) + 1;`
+
+// ByQueryResult summarizes an update or delete by query. Failures holds the
+// reasons of documents that could not be changed.
+type ByQueryResult struct {
+	Total            int64
+	Updated          int64
+	Deleted          int64
+	VersionConflicts int64
+	TimedOut         bool
+	Failures         []string
+}
+
+// UpdateByQuery sets the given fields on every user matching the query that
+// is not soft deleted, and bumps their audit fields and revision. Users
+// changed concurrently are skipped and counted as version conflicts. A
+// requestsPerSecond above 0 throttles the batches. Previous revisions are not
+// recorded in the history.
+func (p UserInfoStorage) UpdateByQuery(ctx context.Context, query map[string]interface{}, set map[string]interface{}, requestsPerSecond int) (ByQueryResult, error) {
+	res, err := p.updateByQuery(ctx, query, set, requestsPerSecond, true)
+	if err != nil {
+		return ByQueryResult{}, err
+	}
+	defer res.Body.Close()
+
+	return decodeByQuery("update by query", res)
+}
+
+// StartUpdateByQuery runs UpdateByQuery as a task and returns its id without
+// waiting for it.
+func (p UserInfoStorage) StartUpdateByQuery(ctx context.Context, query map[string]interface{}, set map[string]interface{}, requestsPerSecond int) (string, error) {
+	res, err := p.updateByQuery(ctx, query, set, requestsPerSecond, false)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	return decodeTaskID("update by query", res)
+}
+
+func (p UserInfoStorage) updateByQuery(ctx context.Context, query map[string]interface{}, set map[string]interface{}, requestsPerSecond int, wait bool) (*esapi.Response, error) {
+	bdy, err := json.Marshal(map[string]interface{}{
+		"query": excludeDeleted(query),
+		"script": map[string]interface{}{
+			"source": setFieldsScript,
+			"lang":   "painless",
+			"params": map[string]interface{}{
+				"set":        set,
+				"updated_at": time.Now().UTC(),
+				"updated_by": model.ActorFromContext(ctx),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update by query: marshall: %w", err)
+	}
+
+	es := p.elastic.client
+	opts := []func(*esapi.UpdateByQueryRequest){
+		es.UpdateByQuery.WithContext(ctx),
+		es.UpdateByQuery.WithBody(bytes.NewReader(bdy)),
+		es.UpdateByQuery.WithConflicts("proceed"),
+		es.UpdateByQuery.WithWaitForCompletion(wait),
+		es.UpdateByQuery.WithRefresh(true),
+	}
+	if requestsPerSecond > 0 {
+		opts = append(opts, es.UpdateByQuery.WithRequestsPerSecond(requestsPerSecond))
+	}
+
+	res, err := es.UpdateByQuery([]string{p.elastic.alias}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("update by query: request: %w", err)
+	}
+
+	return res, nil
+}
+
+// DeleteByQuery removes every user matching the query that is not soft
+// deleted. Throttling and conflicts are handled like in UpdateByQuery.
+func (p UserInfoStorage) DeleteByQuery(ctx context.Context, query map[string]interface{}, requestsPerSecond int) (ByQueryResult, error) {
+	res, err := p.deleteByQuery(ctx, query, requestsPerSecond, true)
+	if err != nil {
+		return ByQueryResult{}, err
+	}
+	defer res.Body.Close()
+
+	return decodeByQuery("delete by query", res)
+}
+
+// StartDeleteByQuery runs DeleteByQuery as a task and returns its id without
+// waiting for it.
+func (p UserInfoStorage) StartDeleteByQuery(ctx context.Context, query map[string]interface{}, requestsPerSecond int) (string, error) {
+	res, err := p.deleteByQuery(ctx, query, requestsPerSecond, false)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	return decodeTaskID("delete by query", res)
+}
+
+func (p UserInfoStorage) deleteByQuery(ctx context.Context, query map[string]interface{}, requestsPerSecond int, wait bool) (*esapi.Response, error) {
+	bdy, err := json.Marshal(map[string]interface{}{"query": excludeDeleted(query)})
+	if err != nil {
+		return nil, fmt.Errorf("delete by query: marshall: %w", err)
+	}
+
+	es := p.elastic.client
+	opts := []func(*esapi.DeleteByQueryRequest){
+		es.DeleteByQuery.WithContext(ctx),
+		es.DeleteByQuery.WithConflicts("proceed"),
+		es.DeleteByQuery.WithWaitForCompletion(wait),
+		es.DeleteByQuery.WithRefresh(true),
+	}
+	if requestsPerSecond > 0 {
+		opts = append(opts, es.DeleteByQuery.WithRequestsPerSecond(requestsPerSecond))
+	}
+
+	res, err := es.DeleteByQuery([]string{p.elastic.alias}, bytes.NewReader(bdy), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("delete by query: request: %w", err)
+	}
+
+	return res, nil
+}
+
+func decodeByQuery(op string, res *esapi.Response) (ByQueryResult, error) {
+	if res.StatusCode == 400 {
+		return ByQueryResult{}, fmt.Errorf("%s: %w: %s", op, model.ErrInvalidQuery, res.String())
+	}
+
+	if res.IsError() {
+		return ByQueryResult{}, fmt.Errorf("%s: response: %s", op, res.String())
+	}
+
+	var body struct {
+		Total            int64 `json:"total"`
+		Updated          int64 `json:"updated"`
+		Deleted          int64 `json:"deleted"`
+		VersionConflicts int64 `json:"version_conflicts"`
+		TimedOut         bool  `json:"timed_out"`
+		Failures         []struct {
+			ID    string `json:"id"`
+			Cause struct {
+				Reason string `json:"reason"`
+			} `json:"cause"`
+		} `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return ByQueryResult{}, fmt.Errorf("%s: decode: %w", op, err)
+	}
+
+	result := ByQueryResult{
+		Total:            body.Total,
+		Updated:          body.Updated,
+		Deleted:          body.Deleted,
+		VersionConflicts: body.VersionConflicts,
+		TimedOut:         body.TimedOut,
+	}
+	for _, failure := range body.Failures {
+		result.Failures = append(result.Failures, fmt.Sprintf("%s: %s", failure.ID, failure.Cause.Reason))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,129 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"time"
+)
+
+const (
+	addChildScript = `if (ctx._source.deleted_at != null) { ctx.op = 'noop'; return; }
+if (ctx._source.childNames == null) { ctx._source.childNames = []; }
+if (ctx._source.childNames.contains(params.name)) { ctx.op = 'noop'; return; }
+ctx._source.childNames.add(params.name);
+ctx._source.updated_at = params.updated_at; ctx._source.updated_by = params.updated_by;
+ctx._source.revision = (ctx._source.revision == null ? 1 : ctx._source.revision) + 1;`
+
+	removeChildScript = `if (ctx._source.deleted_at != null) { ctx.op = 'noop'; return; }
+if (ctx._source.childNames == null || !ctx._source.childNames.removeIf(c -> c == params.name)) { ctx.op = 'noop'; return; }
+ctx._source.updated_at = params.updated_at; ctx._source.updated_by = params.updated_by;
+ctx._source.revision = (ctx._source.revision == null ? 1 : ctx._source.revision) + 1;`
+
+	// childRetriesOnConflict is how often a child update is retried when the
+	// user changed between reading and writing it.
+	childRetriesOnConflict = 5
+)
+
+// AddChild adds name to the child names of the user, unless it is there
+// already. It reports whether the user changed.
+func (p UserInfoStorage) AddChild(ctx context.Context, id string, name string) (bool, error) {
+	return p.updateChildren(ctx, id, name, true)
+}
+
+// RemoveChild removes every occurrence of name from the child names of the
+// user. It reports whether the user changed.
+func (p UserInfoStorage) RemoveChild(ctx context.Context, id string, name string) (bool, error) {
+	return p.updateChildren(ctx, id, name, false)
+}
+
+// updateChildren changes the child names with a script, so the rest of the
+// user is never rewritten. Like Update it reads the user, stores it in the
+// history and runs the script conditional on the revision it read; when the
+// user changed meanwhile it starts over.
+func (p UserInfoStorage) updateChildren(ctx context.Context, id string, name string, add bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		changed, err := p.updateChildrenOnce(ctx, id, name, add)
+		if model.ErrConflict != err || attempt == childRetriesOnConflict {
+			return changed, err
+		}
+	}
+}
+
+func (p UserInfoStorage) updateChildrenOnce(ctx context.Context, id string, name string, add bool) (bool, error) {
+	previous, err := p.findPrevious(ctx, id, nil, false)
+	if err != nil {
+		return false, err
+	}
+	if containsChild(previous.ChildNames, name) == add {
+		return false, nil
+	}
+
+	source := removeChildScript
+	if add {
+		source = addChildScript
+	}
+	bdy, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": source,
+			"params": map[string]interface{}{
+				"name":       name,
+				"updated_at": time.Now().UTC(),
+				"updated_by": model.ActorFromContext(ctx),
+			},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("update children: marshall: %w", err)
+	}
+
+	if err := p.elastic.saveHistory(ctx, previous, HistoryUpdate); err != nil {
+		return false, err
+	}
+
+	req := esapi.UpdateRequest{
+		Index:         p.elastic.alias,
+		DocumentID:    id,
+		Body:          bytes.NewReader(bdy),
+		IfSeqNo:       &previous.Version.SeqNo,
+		IfPrimaryTerm: &previous.Version.PrimaryTerm,
+	}
+
+	res, err := req.Do(ctx, p.elastic.client)
+	if err != nil {
+		return false, fmt.Errorf("update children: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if writeBlocked(res) {
+		return false, model.ErrWriteBlocked
+	}
+
+	if res.StatusCode == 404 {
+		return false, model.ErrNotFound
+	}
+
+	if res.StatusCode == 409 {
+		return false, model.ErrConflict
+	}
+
+	if res.IsError() {
+		return false, fmt.Errorf("update children: response: %s", res.String())
+	}
+
+	var body struct {
+		Result string `json:"result"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return false, fmt.Errorf("update children: decode: %w", err)
+	}
+
+	return body.Result == "updated", nil
+}
@@ -0,0 +1,112 @@
+package elasticsearch
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch/estest"
+	"elastic-project/model"
+	"net/http"
+	"testing"
+)
+
+func TestUserInfoStorageAddChild(t *testing.T) {
+	update := "POST " + userPath + "/_update"
+
+	tests := []struct {
+		name         string
+		child        string
+		get          estest.Response
+		update       []estest.Response
+		wantChanged  bool
+		wantErr      error
+		wantRequests []string
+	}{
+		{
+			name:         "added",
+			child:        "Zeynep",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			update:       []estest.Response{estest.Updated("user_v1", "1")},
+			wantChanged:  true,
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, update},
+		},
+		{
+			name:         "there already",
+			child:        "Ayşe",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			wantRequests: []string{"GET " + userPath},
+		},
+		{
+			name:         "missing",
+			child:        "Zeynep",
+			get:          estest.Fixture(http.StatusNotFound, "get_not_found"),
+			wantErr:      model.ErrNotFound,
+			wantRequests: []string{"GET " + userPath},
+		},
+		{
+			name:         "changed concurrently",
+			child:        "Zeynep",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			update:       []estest.Response{estest.Conflict("user_v1", "1"), estest.Updated("user_v1", "1")},
+			wantChanged:  true,
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, update, "GET " + userPath, "PUT " + historyPath, update},
+		},
+		{
+			name:         "deleted concurrently",
+			child:        "Zeynep",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			update:       []estest.Response{estest.NotFound("user_v1", "1")},
+			wantErr:      model.ErrNotFound,
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, update},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es, storage := newTestStorage(t)
+			es.On(http.MethodGet, userPath, tt.get)
+			if len(tt.update) > 0 {
+				es.On(http.MethodPost, userPath+"/_update", tt.update...)
+			}
+			es.On(http.MethodPut, historyPath, estest.Created("user_history", "1_2"))
+
+			changed, err := storage.AddChild(context.Background(), "1", tt.child)
+			assertError(t, err, tt.wantErr, "")
+			if changed != tt.wantChanged {
+				t.Errorf("AddChild() = %v, want %v", changed, tt.wantChanged)
+			}
+
+			requests := assertRequests(t, es, tt.wantRequests...)
+			if len(requests) < 3 {
+				return
+			}
+			assertHistory(t, requests[1], HistoryUpdate)
+			assertQuery(t, requests[2], "if_seq_no", "3")
+			assertQuery(t, requests[2], "if_primary_term", "1")
+			var body struct {
+				Script struct {
+					Source string                 `json:"source"`
+					Params map[string]interface{} `json:"params"`
+				} `json:"script"`
+			}
+			if err := requests[2].Decode(&body); err != nil {
+				t.Fatalf("update body %s: %v", requests[2].Body, err)
+			}
+			if body.Script.Source != addChildScript || body.Script.Params["name"] != tt.child {
+				t.Errorf("update body = %s, want the add child script for %s", requests[2].Body, tt.child)
+			}
+		})
+	}
+}
+
+func TestUserInfoStorageAddChildGivesUp(t *testing.T) {
+	es, storage := newTestStorage(t)
+	es.On(http.MethodGet, userPath, estest.Fixture(http.StatusOK, "get_found"))
+	es.On(http.MethodPut, historyPath, estest.Created("user_history", "1_2"))
+	es.On(http.MethodPost, userPath+"/_update", estest.Conflict("user_v1", "1"))
+
+	_, err := storage.AddChild(context.Background(), "1", "Zeynep")
+	assertError(t, err, model.ErrConflict, "")
+
+	if got, want := len(es.Requests()), 3*(childRetriesOnConflict+1); got != want {
+		t.Errorf("%d requests, want %d", got, want)
+	}
+}
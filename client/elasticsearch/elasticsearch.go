@@ -1,16 +1,22 @@
 package elasticsearch
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/elastic/go-elasticsearch/v7"
+	"strings"
 )
 
 type ElasticSearch struct {
-	client *elasticsearch.Client
-	index  string
-	alias  string
+	client   *elasticsearch.Client
+	index    string
+	alias    string
+	analysis AnalysisConfig
 }
 
-func New(addresses []string) (*ElasticSearch, error) {
+func New(addresses []string, analysis AnalysisConfig) (*ElasticSearch, error) {
 	cfg := elasticsearch.Config{
 		Addresses: addresses,
 	}
@@ -21,34 +27,33 @@ func New(addresses []string) (*ElasticSearch, error) {
 	}
 
 	return &ElasticSearch{
-		client: client,
+		client:   client,
+		analysis: analysis,
 	}, nil
 }
 
+// CreateIndex points the alias at the first generation of the index, creating
+// it with the current mapping, unless the alias already exists.
 func (e *ElasticSearch) CreateIndex(index string) error {
 	e.index = index
 	e.alias = index + "_alias"
 
-	res, err := e.client.Indices.Exists([]string{e.index})
+	res, err := e.client.Indices.ExistsAlias([]string{e.alias})
 	if err != nil {
-		return fmt.Errorf("cannot check index existence: %w", err)
+		return fmt.Errorf("cannot check index alias existence: %w", err)
 	}
 	if res.StatusCode == 200 {
 		return nil
 	}
 	if res.StatusCode != 404 {
-		return fmt.Errorf("error in index existence response: %s", res.String())
+		return fmt.Errorf("error in index alias existence response: %s", res.String())
 	}
 
-	res, err = e.client.Indices.Create(e.index)
-	if err != nil {
+	if err := e.createPhysicalIndex(context.Background(), e.physicalIndex(1)); err != nil {
 		return fmt.Errorf("cannot create index: %w", err)
 	}
-	if res.IsError() {
-		return fmt.Errorf("error in index creation response: %s", res.String())
-	}
 
-	res, err = e.client.Indices.PutAlias([]string{e.index}, e.alias)
+	res, err = e.client.Indices.PutAlias([]string{e.physicalIndex(1)}, e.alias)
 	if err != nil {
 		return fmt.Errorf("cannot create index alias: %w", err)
 	}
@@ -59,6 +64,166 @@ func (e *ElasticSearch) CreateIndex(index string) error {
 	return nil
 }
 
+// CheckMapping compares the mapping of the index behind the alias with the one
+// defined in userIndexDefinition and returns the differences, if any.
+func (e *ElasticSearch) CheckMapping() ([]string, error) {
+	res, err := e.client.Indices.GetMapping(e.client.Indices.GetMapping.WithIndex(e.alias))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get index mapping: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error in index mapping response: %s", res.String())
+	}
+
+	var body map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("cannot decode index mapping: %w", err)
+	}
+
+	var drift []string
+	for index, mapping := range body {
+		indexDrift, err := diffMapping(userIndexDefinition(e.analysis).Mappings, mapping.Mappings)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compare index mapping: %w", err)
+		}
+		for _, d := range indexDrift {
+			drift = append(drift, fmt.Sprintf("%s: %s", index, d))
+		}
+	}
+
+	return drift, nil
+}
+
+// RefreshInterval returns index.refresh_interval of the index behind the
+// alias, or an empty string if the Elasticsearch default applies.
+func (e *ElasticSearch) RefreshInterval(ctx context.Context) (string, error) {
+	res, err := e.client.Indices.GetSettings(
+		e.client.Indices.GetSettings.WithContext(ctx),
+		e.client.Indices.GetSettings.WithIndex(e.alias),
+		e.client.Indices.GetSettings.WithName("index.refresh_interval"),
+	)
+	if err != nil {
+		return "", fmt.Errorf("refresh interval: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", fmt.Errorf("refresh interval: response: %s", res.String())
+	}
+
+	var indices map[string]struct {
+		Settings struct {
+			Index struct {
+				RefreshInterval string `json:"refresh_interval"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return "", fmt.Errorf("refresh interval: decode: %w", err)
+	}
+
+	for _, index := range indices {
+		return index.Settings.Index.RefreshInterval, nil
+	}
+
+	return "", nil
+}
+
+// SetRefreshInterval changes index.refresh_interval of the index behind the
+// alias. An empty interval restores the Elasticsearch default.
+func (e *ElasticSearch) SetRefreshInterval(ctx context.Context, interval string) error {
+	var value interface{}
+	if interval != "" {
+		value = interval
+	}
+
+	bdy, err := json.Marshal(map[string]interface{}{
+		"index": map[string]interface{}{"refresh_interval": value},
+	})
+	if err != nil {
+		return fmt.Errorf("set refresh interval: marshall: %w", err)
+	}
+
+	res, err := e.client.Indices.PutSettings(bytes.NewReader(bdy),
+		e.client.Indices.PutSettings.WithContext(ctx),
+		e.client.Indices.PutSettings.WithIndex(e.alias),
+	)
+	if err != nil {
+		return fmt.Errorf("set refresh interval: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("set refresh interval: response: %s", res.String())
+	}
+
+	return nil
+}
+
 type document struct {
-	Source interface{} `json:"_source"`
+	SeqNo       int         `json:"_seq_no"`
+	PrimaryTerm int         `json:"_primary_term"`
+	Source      interface{} `json:"_source"`
+}
+
+// searchIndex runs the query against index and calls fn with the _source of
+// every hit, in order.
+func (e ElasticSearch) searchIndex(ctx context.Context, index string, query map[string]interface{}, fn func(json.RawMessage) error) error {
+	bdy, err := json.Marshal(query)
+	if err != nil {
+		return fmt.Errorf("search: marshall: %w", err)
+	}
+
+	es := e.client
+	res, err := es.Search(es.Search.WithContext(ctx), es.Search.WithIndex(index), es.Search.WithBody(bytes.NewReader(bdy)))
+	if err != nil {
+		return fmt.Errorf("search: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("search: response: %s", res.String())
+	}
+
+	var response searchResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return fmt.Errorf("search: decode: %w", err)
+	}
+
+	for _, hit := range response.Hits.Hits {
+		if err := fn(hit.Source); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createIndices creates every index of the map with its definition, unless
+// it already exists.
+func (e *ElasticSearch) createIndices(indices map[string]string) error {
+	for index, definition := range indices {
+		exists, err := e.indexExists(context.Background(), index)
+		if err != nil {
+			return fmt.Errorf("cannot check index existence: %w", err)
+		}
+		if exists {
+			continue
+		}
+
+		res, err := e.client.Indices.Create(index, e.client.Indices.Create.WithBody(strings.NewReader(definition)))
+		if err != nil {
+			return fmt.Errorf("cannot create index: %w", err)
+		}
+		res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error in index creation response: %s", res.String())
+		}
+	}
+
+	return nil
 }
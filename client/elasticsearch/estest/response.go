@@ -0,0 +1,196 @@
+package estest
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// Response is an answer of the fake cluster. A zero Status is sent as 200.
+// Header values replace the default headers of the same name.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// JSON answers with v marshalled to JSON.
+func JSON(status int, v interface{}) Response {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("estest: marshall response: %v", err))
+	}
+	return Response{Status: status, Body: body}
+}
+
+// Raw answers with body as is, e.g. to send JSON that does not decode.
+func Raw(status int, body string) Response {
+	return Response{Status: status, Body: []byte(body)}
+}
+
+// Fixture answers with fixtures/<name>.json. The fixtures hold responses as
+// Elasticsearch 7.17 sends them:
+//
+//	info                   GET /
+//	get_found              a found document with _seq_no and _primary_term
+//	get_not_found          a missing document
+//	index_not_found        index_not_found_exception
+//	version_conflict       version_conflict_engine_exception
+//	too_many_requests      es_rejected_execution_exception
+//	search_hits            two users with scores, sort values and highlights
+//	search_malformed_hits  hits with missing or mistyped _source fields
+//	bulk_mixed             a created, a conflicting and a missing item
+//	by_query               an update by query that skipped a version conflict
+func Fixture(status int, name string) Response {
+	body, err := fixtures.ReadFile("fixtures/" + name + ".json")
+	if err != nil {
+		panic(fmt.Sprintf("estest: unknown fixture %q", name))
+	}
+	return Response{Status: status, Body: body}
+}
+
+// Error answers with the error envelope of Elasticsearch.
+func Error(status int, errorType string, reason string) Response {
+	cause := map[string]interface{}{"type": errorType, "reason": reason}
+	return JSON(status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"root_cause": []interface{}{cause},
+			"type":       errorType,
+			"reason":     reason,
+		},
+		"status": status,
+	})
+}
+
+// Found answers a get with the document source.
+func Found(index string, id string, seqNo int, primaryTerm int, source interface{}) Response {
+	return JSON(http.StatusOK, map[string]interface{}{
+		"_index":        index,
+		"_type":         "_doc",
+		"_id":           id,
+		"_version":      seqNo + 1,
+		"_seq_no":       seqNo,
+		"_primary_term": primaryTerm,
+		"found":         true,
+		"_source":       source,
+	})
+}
+
+// NotFound answers a get, update or delete of a missing document.
+func NotFound(index string, id string) Response {
+	return JSON(http.StatusNotFound, map[string]interface{}{
+		"_index": index,
+		"_type":  "_doc",
+		"_id":    id,
+		"found":  false,
+	})
+}
+
+// Conflict answers a write whose if_seq_no, if_primary_term or op_type=create
+// does not hold.
+func Conflict(index string, id string) Response {
+	return Error(http.StatusConflict, "version_conflict_engine_exception",
+		fmt.Sprintf("[%s]: version conflict", id))
+}
+
+// TooManyRequests answers as a node whose write queue is full.
+func TooManyRequests() Response {
+	return Fixture(http.StatusTooManyRequests, "too_many_requests")
+}
+
+// Created, Updated, Deleted and Noop answer single document writes with the
+// matching result.
+func Created(index string, id string) Response {
+	return writeResult(http.StatusCreated, index, id, "created")
+}
+
+func Updated(index string, id string) Response {
+	return writeResult(http.StatusOK, index, id, "updated")
+}
+
+func Deleted(index string, id string) Response {
+	return writeResult(http.StatusOK, index, id, "deleted")
+}
+
+func Noop(index string, id string) Response {
+	return writeResult(http.StatusOK, index, id, "noop")
+}
+
+func writeResult(status int, index string, id string, result string) Response {
+	return JSON(status, map[string]interface{}{
+		"_index":        index,
+		"_type":         "_doc",
+		"_id":           id,
+		"_version":      1,
+		"result":        result,
+		"_shards":       map[string]interface{}{"total": 2, "successful": 1, "failed": 0},
+		"_seq_no":       0,
+		"_primary_term": 1,
+	})
+}
+
+// Acknowledged answers index and alias administration calls.
+func Acknowledged() Response {
+	return JSON(http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+// Exists answers a HEAD request with 200, Missing with 404.
+func Exists() Response {
+	return Response{Status: http.StatusOK}
+}
+
+func Missing() Response {
+	return Response{Status: http.StatusNotFound}
+}
+
+// Hit is a search hit. A nil Score is sent as null, like for hits sorted on
+// fields only.
+type Hit struct {
+	Index     string
+	ID        string
+	Score     *float64
+	Sort      []interface{}
+	Highlight map[string][]string
+	Source    interface{}
+}
+
+// Hits answers a search with the hits and the total.
+func Hits(total int64, hits ...Hit) Response {
+	encoded := make([]interface{}, 0, len(hits))
+	for _, hit := range hits {
+		h := map[string]interface{}{
+			"_index":  hit.Index,
+			"_type":   "_doc",
+			"_id":     hit.ID,
+			"_score":  hit.Score,
+			"_source": hit.Source,
+		}
+		if hit.Sort != nil {
+			h["sort"] = hit.Sort
+		}
+		if hit.Highlight != nil {
+			h["highlight"] = hit.Highlight
+		}
+		encoded = append(encoded, h)
+	}
+
+	return JSON(http.StatusOK, map[string]interface{}{
+		"took":      1,
+		"timed_out": false,
+		"_shards":   map[string]interface{}{"total": 1, "successful": 1, "skipped": 0, "failed": 0},
+		"hits": map[string]interface{}{
+			"total":     map[string]interface{}{"value": total, "relation": "eq"},
+			"max_score": nil,
+			"hits":      encoded,
+		},
+	})
+}
+
+// Score returns a pointer to score, for Hit.Score.
+func Score(score float64) *float64 {
+	return &score
+}
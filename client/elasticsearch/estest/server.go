@@ -0,0 +1,222 @@
+// Package estest provides a fake Elasticsearch HTTP server for storage tests.
+// It records every request it receives and answers them with responses
+// scripted per method and path, so tests can check the bodies the storage
+// sends and how it handles any answer, including errors and malformed hits.
+//
+// A test points the client at the server and scripts the calls it expects:
+//
+//	es := estest.NewServer()
+//	defer es.Close()
+//	es.On(http.MethodGet, "/user_alias/_doc/*", estest.Found("user_v1", "1", 3, 1, user))
+//	elastic, _ := elasticsearch.New(es.Addresses(), elasticsearch.AnalysisConfig{})
+//
+// The package does not depend on client/elasticsearch, so tests inside that
+// package can use it too.
+package estest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sync"
+)
+
+// Request is a request received by the server.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// Decode unmarshals the JSON body of the request into v.
+func (r Request) Decode(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Lines splits an NDJSON body, like the one of a bulk request, into its
+// non-empty lines.
+func (r Request) Lines() [][]byte {
+	var lines [][]byte
+	for _, line := range bytes.Split(r.Body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Responder computes the response to a request, for answers that depend on
+// what was sent.
+type Responder func(Request) Response
+
+type route struct {
+	method    string
+	pattern   string
+	responses []Response
+	responder Responder
+	served    int
+}
+
+func (r *route) matches(req Request) bool {
+	if r.method != "" && r.method != req.Method {
+		return false
+	}
+	ok, err := path.Match(r.pattern, req.Path)
+	return err == nil && ok
+}
+
+func (r *route) respond(req Request) Response {
+	if r.responder != nil {
+		return r.responder(req)
+	}
+	response := r.responses[len(r.responses)-1]
+	if r.served < len(r.responses) {
+		response = r.responses[r.served]
+	}
+	r.served++
+	return response
+}
+
+// Server is a fake Elasticsearch cluster with a single node. Every response
+// carries the X-Elastic-Product header the client checks for, and GET / is
+// answered with the info of a 7.17 cluster unless scripted otherwise.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	routes    []*route
+	requests  []Request
+	unmatched []Request
+}
+
+// NewServer starts a fake cluster. Close it when the test is done.
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Addresses returns the addresses to configure the client with.
+func (s *Server) Addresses() []string {
+	return []string{s.URL}
+}
+
+// On scripts the responses to requests with the method, or any method if
+// empty, and a path matching pattern, as in path.Match. The responses are
+// served in order and the last one is repeated. A later script for the same
+// requests takes precedence.
+func (s *Server) On(method string, pattern string, responses ...Response) {
+	if len(responses) == 0 {
+		panic("estest: On needs at least one response")
+	}
+	s.addRoute(&route{method: method, pattern: pattern, responses: responses})
+}
+
+// OnFunc is like On but computes every response with responder.
+func (s *Server) OnFunc(method string, pattern string, responder Responder) {
+	s.addRoute(&route{method: method, pattern: pattern, responder: responder})
+}
+
+func (s *Server) addRoute(r *route) {
+	if _, err := path.Match(r.pattern, ""); err != nil {
+		panic(fmt.Sprintf("estest: invalid pattern %q: %v", r.pattern, err))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = append(s.routes, r)
+}
+
+// Requests returns the requests received so far, in order. The product check
+// the client sends before its first request is left out.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// Unmatched returns the requests no script matched. They were answered with
+// a 501 error.
+func (s *Server) Unmatched() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.unmatched...)
+}
+
+// Reset forgets the scripts and the recorded requests, e.g. between the cases
+// of a table driven test.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = nil
+	s.requests = nil
+	s.unmatched = nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+
+	write(w, req, s.respond(req))
+}
+
+func (s *Server) respond(req Request) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.routes) - 1; i >= 0; i-- {
+		if s.routes[i].matches(req) {
+			s.requests = append(s.requests, req)
+			return s.routes[i].respond(req)
+		}
+	}
+
+	if req.Method == http.MethodGet && req.Path == "/" {
+		return Fixture(http.StatusOK, "info")
+	}
+
+	s.requests = append(s.requests, req)
+	s.unmatched = append(s.unmatched, req)
+	return Error(http.StatusNotImplemented, "estest_unmatched_request",
+		fmt.Sprintf("no response scripted for %s %s", req.Method, req.Path))
+}
+
+func write(w http.ResponseWriter, req Request, response Response) {
+	header := w.Header()
+	header.Set("X-Elastic-Product", "Elasticsearch")
+	header.Set("Content-Type", "application/json; charset=UTF-8")
+	for key, values := range response.Header {
+		header.Del(key)
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+
+	status := response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+
+	// Like Elasticsearch, HEAD requests only get the status.
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(response.Body)
+	}
+}
@@ -0,0 +1,168 @@
+package estest
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type exchange struct {
+	method     string
+	path       string
+	body       string
+	wantStatus int
+	wantBody   string
+}
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		name          string
+		script        func(s *Server)
+		exchanges     []exchange
+		wantRecorded  int
+		wantUnmatched int
+	}{
+		{
+			name: "responses in order and the last repeated",
+			script: func(s *Server) {
+				s.On(http.MethodGet, "/user_alias/_doc/*", Raw(http.StatusOK, "first"), Raw(http.StatusNotFound, "second"))
+			},
+			exchanges: []exchange{
+				{method: http.MethodGet, path: "/user_alias/_doc/1", wantStatus: http.StatusOK, wantBody: "first"},
+				{method: http.MethodGet, path: "/user_alias/_doc/2", wantStatus: http.StatusNotFound, wantBody: "second"},
+				{method: http.MethodGet, path: "/user_alias/_doc/3", wantStatus: http.StatusNotFound, wantBody: "second"},
+			},
+			wantRecorded: 3,
+		},
+		{
+			name: "later scripts take precedence",
+			script: func(s *Server) {
+				s.On("", "/user_alias/*", Raw(http.StatusOK, "any"))
+				s.On(http.MethodPost, "/user_alias/_search", Raw(http.StatusOK, "search"))
+			},
+			exchanges: []exchange{
+				{method: http.MethodPost, path: "/user_alias/_search", wantStatus: http.StatusOK, wantBody: "search"},
+				{method: http.MethodPut, path: "/user_alias/_settings", wantStatus: http.StatusOK, wantBody: "any"},
+			},
+			wantRecorded: 2,
+		},
+		{
+			name: "responder sees the request",
+			script: func(s *Server) {
+				s.OnFunc(http.MethodPost, "/_bulk", func(req Request) Response {
+					return Raw(http.StatusOK, strings.Repeat("x", len(req.Lines())))
+				})
+			},
+			exchanges: []exchange{
+				{method: http.MethodPost, path: "/_bulk", body: "{}\n\n{}\n", wantStatus: http.StatusOK, wantBody: "xx"},
+			},
+			wantRecorded: 1,
+		},
+		{
+			name: "unscripted requests",
+			exchanges: []exchange{
+				{method: http.MethodDelete, path: "/user_v1", wantStatus: http.StatusNotImplemented, wantBody: "no response scripted for DELETE /user_v1"},
+			},
+			wantRecorded:  1,
+			wantUnmatched: 1,
+		},
+		{
+			name: "product check",
+			exchanges: []exchange{
+				{method: http.MethodGet, path: "/", wantStatus: http.StatusOK, wantBody: `"number": "7.17.1"`},
+			},
+		},
+		{
+			name: "head requests get no body",
+			script: func(s *Server) {
+				s.On(http.MethodHead, "/_alias/user_alias", Raw(http.StatusOK, "ignored"))
+			},
+			exchanges: []exchange{
+				{method: http.MethodHead, path: "/_alias/user_alias", wantStatus: http.StatusOK},
+			},
+			wantRecorded: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer()
+			defer s.Close()
+			if tt.script != nil {
+				tt.script(s)
+			}
+
+			for _, e := range tt.exchanges {
+				status, body, product := send(t, s, e.method, e.path, e.body)
+				if status != e.wantStatus {
+					t.Errorf("%s %s status = %d, want %d", e.method, e.path, status, e.wantStatus)
+				}
+				if (e.wantBody == "" && body != "") || !strings.Contains(body, e.wantBody) {
+					t.Errorf("%s %s body = %q, want %q", e.method, e.path, body, e.wantBody)
+				}
+				if product != "Elasticsearch" {
+					t.Errorf("%s %s X-Elastic-Product = %q", e.method, e.path, product)
+				}
+			}
+
+			if got := len(s.Requests()); got != tt.wantRecorded {
+				t.Errorf("recorded %d requests, want %d", got, tt.wantRecorded)
+			}
+			if got := len(s.Unmatched()); got != tt.wantUnmatched {
+				t.Errorf("%d unmatched requests, want %d", got, tt.wantUnmatched)
+			}
+		})
+	}
+}
+
+func TestServerReset(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.On(http.MethodGet, "/user_alias/_doc/1", Found("user_v1", "1", 3, 1, map[string]interface{}{"id": "1"}))
+	send(t, s, http.MethodGet, "/user_alias/_doc/1", "")
+	send(t, s, http.MethodGet, "/user_alias/_doc/2", "")
+	s.Reset()
+
+	if len(s.Requests()) != 0 || len(s.Unmatched()) != 0 {
+		t.Fatalf("Reset() kept %d requests and %d unmatched", len(s.Requests()), len(s.Unmatched()))
+	}
+	if status, _, _ := send(t, s, http.MethodGet, "/user_alias/_doc/1", ""); status != http.StatusNotImplemented {
+		t.Fatalf("status after Reset() = %d, want %d", status, http.StatusNotImplemented)
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	entries, err := fixtures.ReadDir("fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		var v interface{}
+		if err := (Request{Body: Fixture(http.StatusOK, name).Body}).Decode(&v); err != nil {
+			t.Errorf("fixture %s is not JSON: %v", name, err)
+		}
+	}
+}
+
+func send(t *testing.T, s *Server, method string, path string, body string) (int, string, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	received, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(received), res.Header.Get("X-Elastic-Product")
+}
@@ -0,0 +1,136 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	exportBatchSize = 1000
+	exportKeepAlive = "1m"
+)
+
+// Export walks every user matching query, or the whole index when query is
+// nil, calling fn for each of them. It pages with search_after over a point
+// in time, so the export is consistent and never held in memory. Soft
+// deleted users are skipped unless includeDeleted is set.
+func (p UserInfoStorage) Export(ctx context.Context, query map[string]interface{}, includeDeleted bool, fn func(UserInfo) error) error {
+	pitID, err := p.openPointInTime(ctx)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	defer func() {
+		p.closePointInTime(pitID)
+	}()
+
+	var searchAfter []interface{}
+	for {
+		body := map[string]interface{}{
+			"size":             exportBatchSize,
+			"pit":              map[string]interface{}{"id": pitID, "keep_alive": exportKeepAlive},
+			"sort":             []interface{}{map[string]interface{}{"_shard_doc": "asc"}},
+			"track_total_hits": false,
+		}
+		if query != nil {
+			body["query"] = query
+		}
+		if !includeDeleted {
+			body["query"] = excludeDeleted(body["query"])
+		}
+		if searchAfter != nil {
+			body["search_after"] = searchAfter
+		}
+
+		result, err := p.exportBatch(ctx, body)
+		if err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		if result.PitID != "" {
+			pitID = result.PitID
+		}
+
+		for _, hit := range result.Hits {
+			if err := fn(hit.User); err != nil {
+				return err
+			}
+		}
+
+		if len(result.Hits) < exportBatchSize {
+			return nil
+		}
+		searchAfter = result.Hits[len(result.Hits)-1].Sort
+	}
+}
+
+func (p UserInfoStorage) exportBatch(ctx context.Context, body map[string]interface{}) (SearchResult, error) {
+	bdy, err := json.Marshal(body)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("batch: marshall: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	es := p.elastic.client
+	res, err := es.Search(es.Search.WithContext(ctx), es.Search.WithBody(bytes.NewReader(bdy)))
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("batch: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return SearchResult{}, fmt.Errorf("batch: response: %s", res.String())
+	}
+
+	result, err := decodeSearchResponse(res.Body)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("batch: decode: %w", err)
+	}
+
+	return result, nil
+}
+
+func (p UserInfoStorage) openPointInTime(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	es := p.elastic.client
+	res, err := es.OpenPointInTime([]string{p.elastic.alias}, exportKeepAlive, es.OpenPointInTime.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("open point in time: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", fmt.Errorf("open point in time: response: %s", res.String())
+	}
+
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("open point in time: decode: %w", err)
+	}
+
+	return body.ID, nil
+}
+
+// closePointInTime releases the point in time early; it expires on its own
+// after exportKeepAlive, so failures are ignored.
+func (p UserInfoStorage) closePointInTime(pitID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	es := p.elastic.client
+	res, err := es.ClosePointInTime(
+		es.ClosePointInTime.WithContext(ctx),
+		es.ClosePointInTime.WithBody(strings.NewReader(fmt.Sprintf(`{"id":%q}`, pitID))),
+	)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+}
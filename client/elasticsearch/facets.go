@@ -0,0 +1,144 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// FacetOptions size the buckets. Soft deleted users are left out unless
+// IncludeDeleted is set.
+type FacetOptions struct {
+	JobSize          int
+	CalendarInterval string
+	IncludeDeleted   bool
+}
+
+type FacetBucket struct {
+	Key   string
+	Count int64
+}
+
+type DateFacetBucket struct {
+	Key   time.Time
+	Count int64
+}
+
+type HistogramFacetBucket struct {
+	Key   float64
+	Count int64
+}
+
+type FacetResult struct {
+	Total      int64
+	Jobs       []FacetBucket
+	CreatedAt  []DateFacetBucket
+	ChildCount []HistogramFacetBucket
+}
+
+type facetResponse struct {
+	Hits struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+	} `json:"hits"`
+	Aggregations struct {
+		Jobs struct {
+			Buckets []struct {
+				Key      string `json:"key"`
+				DocCount int64  `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"jobs"`
+		CreatedAt struct {
+			Buckets []struct {
+				Key      int64 `json:"key"`
+				DocCount int64 `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"created_at"`
+		ChildCount struct {
+			Buckets []struct {
+				Key      float64 `json:"key"`
+				DocCount int64   `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"child_count"`
+	} `json:"aggregations"`
+}
+
+// childCountScript counts the child names in the source rather than in the
+// doc values of childNames.keyword, which hold distinct names only and leave
+// out names longer than ignore_above.
+const childCountScript = `def names = params._source.childNames;
+if (names == null) { return 0; }
+return names instanceof List ? names.size() : 1;`
+
+// Facets counts the users matching query per job, per created_at interval and
+// per number of children. A nil query counts every user.
+func (p UserInfoStorage) Facets(ctx context.Context, query map[string]interface{}, options FacetOptions) (FacetResult, error) {
+	body := map[string]interface{}{
+		"size":             0,
+		"track_total_hits": true,
+		"aggs": map[string]interface{}{
+			"jobs": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "job.keyword", "size": options.JobSize},
+			},
+			"created_at": map[string]interface{}{
+				"date_histogram": map[string]interface{}{
+					"field":             "created_at",
+					"calendar_interval": options.CalendarInterval,
+					"min_doc_count":     1,
+				},
+			},
+			"child_count": map[string]interface{}{
+				"histogram": map[string]interface{}{
+					"script":   map[string]interface{}{"lang": "painless", "source": childCountScript},
+					"interval": 1,
+				},
+			},
+		},
+	}
+	if query != nil {
+		body["query"] = query
+	}
+	if !options.IncludeDeleted {
+		body["query"] = excludeDeleted(body["query"])
+	}
+
+	bdy, err := json.Marshal(body)
+	if err != nil {
+		return FacetResult{}, fmt.Errorf("facets: marshall: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	es := p.elastic.client
+	res, err := es.Search(es.Search.WithContext(ctx), es.Search.WithIndex(p.elastic.alias), es.Search.WithBody(bytes.NewReader(bdy)))
+	if err != nil {
+		return FacetResult{}, fmt.Errorf("facets: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return FacetResult{}, fmt.Errorf("facets: response: %s", res.String())
+	}
+
+	var response facetResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return FacetResult{}, fmt.Errorf("facets: decode: %w", err)
+	}
+
+	result := FacetResult{Total: response.Hits.Total.Value}
+	for _, bucket := range response.Aggregations.Jobs.Buckets {
+		result.Jobs = append(result.Jobs, FacetBucket{Key: bucket.Key, Count: bucket.DocCount})
+	}
+	for _, bucket := range response.Aggregations.CreatedAt.Buckets {
+		result.CreatedAt = append(result.CreatedAt, DateFacetBucket{Key: time.UnixMilli(bucket.Key).UTC(), Count: bucket.DocCount})
+	}
+	for _, bucket := range response.Aggregations.ChildCount.Buckets {
+		result.ChildCount = append(result.ChildCount, HistogramFacetBucket{Key: bucket.Key, Count: bucket.DocCount})
+	}
+
+	return result, nil
+}
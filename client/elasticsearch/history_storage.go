@@ -0,0 +1,185 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"time"
+)
+
+const (
+	historyIndex = "user_history"
+
+	HistoryUpdate  = "update"
+	HistoryDelete  = "delete"
+	HistoryRestore = "restore"
+
+	maxHistoryEntries = 1000
+)
+
+type HistoryStorage struct {
+	elastic ElasticSearch
+	timeout time.Duration
+}
+
+type HistoryStorer interface {
+	FindHistory(ctx context.Context, userID string) ([]HistoryEntry, error)
+	FindRevision(ctx context.Context, userID string, revision int64) (HistoryEntry, error)
+}
+
+// HistoryEntry is a revision of a user as it was before it got replaced.
+// Action, Actor and Timestamp describe the change that replaced it.
+type HistoryEntry struct {
+	UserID    string     `json:"user_id"`
+	Revision  int64      `json:"revision"`
+	Action    string     `json:"action"`
+	Actor     string     `json:"actor"`
+	Timestamp *time.Time `json:"timestamp"`
+	User      UserInfo   `json:"user"`
+}
+
+func NewHistoryStorage(elastic ElasticSearch) HistoryStorer {
+	return &HistoryStorage{
+		elastic: elastic,
+		timeout: time.Second * 10,
+	}
+}
+
+// CreateHistoryIndex creates the index holding previous revisions of users,
+// unless it already exists. The user itself is stored but not indexed, so
+// later mapping changes of the user index do not affect it.
+func (e *ElasticSearch) CreateHistoryIndex() error {
+	return e.createIndices(map[string]string{
+		historyIndex: `{"mappings":{"properties":{
+			"user_id":{"type":"keyword"},"revision":{"type":"long"},"action":{"type":"keyword"},
+			"actor":{"type":"keyword"},"timestamp":{"type":"date"},
+			"user":{"type":"object","enabled":false}}}}`,
+	})
+}
+
+func (p HistoryStorage) FindHistory(ctx context.Context, userID string) ([]HistoryEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	entries, err := p.elastic.findHistory(ctx, userID, maxHistoryEntries)
+	if err != nil {
+		return nil, fmt.Errorf("find history: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (p HistoryStorage) FindRevision(ctx context.Context, userID string, revision int64) (HistoryEntry, error) {
+	req := esapi.GetRequest{
+		Index:      historyIndex,
+		DocumentID: historyID(userID, revision),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	res, err := req.Do(ctx, p.elastic.client)
+	if err != nil {
+		return HistoryEntry{}, fmt.Errorf("find revision: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return HistoryEntry{}, model.ErrNotFound
+	}
+
+	if res.IsError() {
+		return HistoryEntry{}, fmt.Errorf("find revision: response: %s", res.String())
+	}
+
+	var (
+		entry HistoryEntry
+		body  document
+	)
+	body.Source = &entry
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return HistoryEntry{}, fmt.Errorf("find revision: decode: %w", err)
+	}
+
+	return entry, nil
+}
+
+// findHistory returns the newest revisions of the user first.
+func (e ElasticSearch) findHistory(ctx context.Context, userID string, size int) ([]HistoryEntry, error) {
+	query := map[string]interface{}{
+		"size": size,
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"user_id": userID},
+		},
+		"sort": []interface{}{map[string]interface{}{"revision": "desc"}},
+	}
+
+	var entries []HistoryEntry
+	err := e.searchIndex(ctx, historyIndex, query, func(source json.RawMessage) error {
+		var entry HistoryEntry
+		if err := json.Unmarshal(source, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// saveHistory stores the user as it is before the change described by
+// action. It runs before that change, which is conditional on the revision.
+// Entries are keyed by user and revision and never overwritten; an entry that
+// exists already was left by an earlier change of the same revision that
+// failed, and is kept.
+func (e ElasticSearch) saveHistory(ctx context.Context, previous UserInfo, action string) error {
+	now := time.Now().UTC()
+	previous.Revision = previous.CurrentRevision()
+	entry := HistoryEntry{
+		UserID:    previous.ID,
+		Revision:  previous.Revision,
+		Action:    action,
+		Actor:     model.ActorFromContext(ctx),
+		Timestamp: &now,
+		User:      previous,
+	}
+
+	bdy, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("save history: marshall: %w", err)
+	}
+
+	req := esapi.IndexRequest{
+		Index:      historyIndex,
+		DocumentID: historyID(entry.UserID, entry.Revision),
+		Body:       bytes.NewReader(bdy),
+		OpType:     "create",
+	}
+
+	res, err := req.Do(ctx, e.client)
+	if err != nil {
+		return fmt.Errorf("save history: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 409 {
+		return nil
+	}
+
+	if res.IsError() {
+		return fmt.Errorf("save history: response: %s", res.String())
+	}
+
+	return nil
+}
+
+func historyID(userID string, revision int64) string {
+	return fmt.Sprintf("%s_%d", userID, revision)
+}
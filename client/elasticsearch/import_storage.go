@@ -0,0 +1,269 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"time"
+)
+
+const (
+	importJobIndex   = "user_imports"
+	importErrorIndex = "user_import_errors"
+
+	ImportStatusQueued    = "queued"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+type ImportJobStorage struct {
+	elastic ElasticSearch
+	timeout time.Duration
+}
+
+type ImportJobStorer interface {
+	SaveJob(ctx context.Context, job ImportJob) error
+	FindJob(ctx context.Context, id string) (ImportJob, error)
+	FindUnfinishedJobs(ctx context.Context) ([]ImportJob, error)
+	SaveRejectedRows(ctx context.Context, rows []RejectedRow) error
+	FindRejectedRows(ctx context.Context, jobID string, fn func(RejectedRow) error) error
+}
+
+type ImportJob struct {
+	ID         string     `json:"id"`
+	Format     string     `json:"format"`
+	FileName   string     `json:"file_name"`
+	Path       string     `json:"path"`
+	Status     string     `json:"status"`
+	Processed  int        `json:"processed"`
+	Indexed    int        `json:"indexed"`
+	Rejected   int        `json:"rejected"`
+	Error      string     `json:"error,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// RefreshInterval is the refresh interval of the user index before the
+	// imports relaxed it, empty for the default. It is kept while the job
+	// runs, so a job resumed after a crash can still restore it.
+	RefreshInterval *string `json:"refresh_interval,omitempty"`
+}
+
+type RejectedRow struct {
+	JobID  string `json:"job_id"`
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
+	Raw    string `json:"raw"`
+}
+
+func NewImportJobStorage(elastic ElasticSearch) ImportJobStorer {
+	return &ImportJobStorage{
+		elastic: elastic,
+		timeout: time.Second * 10,
+	}
+}
+
+// CreateImportIndices creates the indices holding import jobs and their
+// rejected rows, unless they already exist.
+func (e *ElasticSearch) CreateImportIndices() error {
+	indices := map[string]string{
+		importJobIndex: `{"mappings":{"properties":{
+			"id":{"type":"keyword"},"format":{"type":"keyword"},"file_name":{"type":"keyword"},
+			"path":{"type":"keyword","index":false},"status":{"type":"keyword"},
+			"processed":{"type":"integer"},"indexed":{"type":"integer"},"rejected":{"type":"integer"},
+			"error":{"type":"text"},"created_by":{"type":"keyword"},"created_at":{"type":"date"},"updated_at":{"type":"date"},
+			"finished_at":{"type":"date"},"refresh_interval":{"type":"keyword","index":false}}}}`,
+		importErrorIndex: `{"mappings":{"properties":{
+			"job_id":{"type":"keyword"},"row":{"type":"integer"},
+			"reason":{"type":"text"},"raw":{"type":"text","index":false}}}}`,
+	}
+
+	return e.createIndices(indices)
+}
+
+func (p ImportJobStorage) SaveJob(ctx context.Context, job ImportJob) error {
+	bdy, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("save job: marshall: %w", err)
+	}
+
+	req := esapi.IndexRequest{
+		Index:      importJobIndex,
+		DocumentID: job.ID,
+		Body:       bytes.NewReader(bdy),
+		Refresh:    "true",
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	res, err := req.Do(ctx, p.elastic.client)
+	if err != nil {
+		return fmt.Errorf("save job: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("save job: response: %s", res.String())
+	}
+
+	return nil
+}
+
+func (p ImportJobStorage) FindJob(ctx context.Context, id string) (ImportJob, error) {
+	req := esapi.GetRequest{
+		Index:      importJobIndex,
+		DocumentID: id,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	res, err := req.Do(ctx, p.elastic.client)
+	if err != nil {
+		return ImportJob{}, fmt.Errorf("find job: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return ImportJob{}, model.ErrNotFound
+	}
+
+	if res.IsError() {
+		return ImportJob{}, fmt.Errorf("find job: response: %s", res.String())
+	}
+
+	var (
+		job  ImportJob
+		body document
+	)
+	body.Source = &job
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return ImportJob{}, fmt.Errorf("find job: decode: %w", err)
+	}
+
+	return job, nil
+}
+
+func (p ImportJobStorage) FindUnfinishedJobs(ctx context.Context) ([]ImportJob, error) {
+	query := map[string]interface{}{
+		"size": 100,
+		"query": map[string]interface{}{
+			"terms": map[string]interface{}{
+				"status": []string{ImportStatusQueued, ImportStatusRunning},
+			},
+		},
+		"sort": []interface{}{map[string]interface{}{"created_at": "asc"}},
+	}
+
+	var jobs []ImportJob
+	err := p.searchIndex(ctx, importJobIndex, query, func(source json.RawMessage) error {
+		var job ImportJob
+		if err := json.Unmarshal(source, &job); err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find unfinished jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// SaveRejectedRows stores rows keyed by job and row number, so saving the
+// same batch twice after a restart does not duplicate the error report.
+func (p ImportJobStorage) SaveRejectedRows(ctx context.Context, rows []RejectedRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, row := range rows {
+		action := map[string]interface{}{
+			"index": map[string]interface{}{"_index": importErrorIndex, "_id": fmt.Sprintf("%s_%d", row.JobID, row.Row)},
+		}
+		if err := encoder.Encode(action); err != nil {
+			return fmt.Errorf("save rejected rows: marshall: %w", err)
+		}
+		if err := encoder.Encode(row); err != nil {
+			return fmt.Errorf("save rejected rows: marshall: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	es := p.elastic.client
+	res, err := es.Bulk(&buffer, es.Bulk.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("save rejected rows: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("save rejected rows: response: %s", res.String())
+	}
+
+	var body struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return fmt.Errorf("save rejected rows: decode: %w", err)
+	}
+	if body.Errors {
+		return fmt.Errorf("save rejected rows: some rows were not stored")
+	}
+
+	return nil
+}
+
+func (p ImportJobStorage) FindRejectedRows(ctx context.Context, jobID string, fn func(RejectedRow) error) error {
+	const batchSize = 1000
+
+	var searchAfter []interface{}
+	for {
+		query := map[string]interface{}{
+			"size": batchSize,
+			"query": map[string]interface{}{
+				"term": map[string]interface{}{"job_id": jobID},
+			},
+			"sort": []interface{}{map[string]interface{}{"row": "asc"}},
+		}
+		if searchAfter != nil {
+			query["search_after"] = searchAfter
+		}
+
+		count := 0
+		err := p.searchIndex(ctx, importErrorIndex, query, func(source json.RawMessage) error {
+			var row RejectedRow
+			if err := json.Unmarshal(source, &row); err != nil {
+				return err
+			}
+			count++
+			searchAfter = []interface{}{row.Row}
+			return fn(row)
+		})
+		if err != nil {
+			return fmt.Errorf("find rejected rows: %w", err)
+		}
+
+		if count < batchSize {
+			return nil
+		}
+	}
+}
+
+func (p ImportJobStorage) searchIndex(ctx context.Context, index string, query map[string]interface{}, fn func(json.RawMessage) error) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	return p.elastic.searchIndex(ctx, index, query, fn)
+}
@@ -0,0 +1,224 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// UserMappingVersion identifies the revision of the user index mapping. Bump it
+// whenever userIndexDefinition changes; it is stored in the index _meta so the
+// live index can be traced back to the definition it was created from.
+const UserMappingVersion = 7
+
+const (
+	AnalyzerStandard = "standard"
+	// AnalyzerTurkishFolding lowercases with Turkish rules, so "ISIL" becomes
+	// "ısıl", and adds an ASCII folded token next to the original one, so
+	// "sukru" finds "Şükrü".
+	AnalyzerTurkishFolding = "turkish_folding"
+	// AnalyzerTurkishICU tokenizes and folds with ICU. It needs the
+	// analysis-icu plugin on every node.
+	AnalyzerTurkishICU = "turkish_icu"
+)
+
+// AnalysisConfig selects the analyzer of each user text field. Fields that
+// are not listed use AnalyzerTurkishFolding.
+type AnalysisConfig struct {
+	FieldAnalyzers map[string]string
+}
+
+func (c AnalysisConfig) analyzer(field string) string {
+	if analyzer, ok := c.FieldAnalyzers[field]; ok {
+		return analyzer
+	}
+	return AnalyzerTurkishFolding
+}
+
+func (c AnalysisConfig) usesICU() bool {
+	for _, analyzer := range c.FieldAnalyzers {
+		if analyzer == AnalyzerTurkishICU {
+			return true
+		}
+	}
+	return false
+}
+
+type indexDefinition struct {
+	Settings indexSettings `json:"settings"`
+	Mappings indexMapping  `json:"mappings"`
+}
+
+type indexSettings struct {
+	Analysis analysisSettings `json:"analysis"`
+}
+
+type analysisSettings struct {
+	Filter   map[string]interface{} `json:"filter"`
+	Analyzer map[string]interface{} `json:"analyzer"`
+}
+
+type indexMapping struct {
+	Dynamic    string                  `json:"dynamic"`
+	Meta       mappingMeta             `json:"_meta"`
+	Properties map[string]fieldMapping `json:"properties"`
+}
+
+type mappingMeta struct {
+	Version int `json:"version"`
+}
+
+type fieldMapping struct {
+	Type           string                  `json:"type"`
+	Format         string                  `json:"format,omitempty"`
+	Analyzer       string                  `json:"analyzer,omitempty"`
+	IgnoreAbove    int                     `json:"ignore_above,omitempty"`
+	MaxShingleSize int                     `json:"max_shingle_size,omitempty"`
+	CopyTo         []string                `json:"copy_to,omitempty"`
+	Fields         map[string]fieldMapping `json:"fields,omitempty"`
+}
+
+func keywordField() fieldMapping {
+	return fieldMapping{Type: "keyword"}
+}
+
+func dateField() fieldMapping {
+	return fieldMapping{Type: "date", Format: "strict_date_optional_time||epoch_millis"}
+}
+
+func textField(analyzer string) fieldMapping {
+	return fieldMapping{Type: "text", Analyzer: analyzer}
+}
+
+func textWithKeywordField(analyzer string) fieldMapping {
+	field := textField(analyzer)
+	field.Fields = map[string]fieldMapping{
+		"keyword": {Type: "keyword", IgnoreAbove: 256},
+	}
+	return field
+}
+
+// suggestShingleSize is the max_shingle_size of the suggest fields. It is the
+// default of Elasticsearch, spelled out because _mapping returns it.
+const suggestShingleSize = 3
+
+// textWithSuggestField copies the field into suggest, a top-level
+// search_as_you_type field used for type-ahead suggestions;
+// search_as_you_type cannot be a multi-field.
+func textWithSuggestField(analyzer string, suggest string) fieldMapping {
+	field := textWithKeywordField(analyzer)
+	field.CopyTo = []string{suggest}
+	return field
+}
+
+func suggestField(analyzer string) fieldMapping {
+	return fieldMapping{Type: "search_as_you_type", Analyzer: analyzer, MaxShingleSize: suggestShingleSize}
+}
+
+func analysis(config AnalysisConfig) analysisSettings {
+	settings := analysisSettings{
+		Filter: map[string]interface{}{
+			"turkish_lowercase":      map[string]interface{}{"type": "lowercase", "language": "turkish"},
+			"ascii_folding_preserve": map[string]interface{}{"type": "asciifolding", "preserve_original": true},
+		},
+		Analyzer: map[string]interface{}{
+			AnalyzerTurkishFolding: map[string]interface{}{
+				"type":      "custom",
+				"tokenizer": "standard",
+				"filter":    []string{"apostrophe", "turkish_lowercase", "ascii_folding_preserve"},
+			},
+		},
+	}
+	if config.usesICU() {
+		settings.Analyzer[AnalyzerTurkishICU] = map[string]interface{}{
+			"type":      "custom",
+			"tokenizer": "icu_tokenizer",
+			"filter":    []string{"apostrophe", "turkish_lowercase", "icu_folding"},
+		}
+	}
+	return settings
+}
+
+func userIndexDefinition(config AnalysisConfig) indexDefinition {
+	return indexDefinition{
+		Settings: indexSettings{Analysis: analysis(config)},
+		Mappings: indexMapping{
+			Dynamic: "strict",
+			Meta:    mappingMeta{Version: UserMappingVersion},
+			Properties: map[string]fieldMapping{
+				"id":           keywordField(),
+				"name":         textWithSuggestField(config.analyzer("name"), "name_suggest"),
+				"name_suggest": suggestField(config.analyzer("name")),
+				"job":          textWithSuggestField(config.analyzer("job"), "job_suggest"),
+				"job_suggest":  suggestField(config.analyzer("job")),
+				"childNames":   textWithKeywordField(config.analyzer("childNames")),
+				"comment":      textField(config.analyzer("comment")),
+				"created_at":   dateField(),
+				"updated_at":   dateField(),
+				"created_by":   keywordField(),
+				"updated_by":   keywordField(),
+				"revision":     {Type: "long"},
+				"deleted_at":   dateField(),
+			},
+		},
+	}
+}
+
+// diffMapping compares the expected mapping with the one returned by
+// _mapping and describes every difference as "path: expected x, got y".
+func diffMapping(expected indexMapping, actual map[string]interface{}) ([]string, error) {
+	bdy, err := json.Marshal(expected)
+	if err != nil {
+		return nil, err
+	}
+
+	var want map[string]interface{}
+	if err := json.Unmarshal(bdy, &want); err != nil {
+		return nil, err
+	}
+
+	var drift []string
+	diffValue("", want, actual, &drift)
+	return drift, nil
+}
+
+func diffValue(path string, want, got interface{}, drift *[]string) {
+	wantMap, wantIsMap := want.(map[string]interface{})
+	gotMap, gotIsMap := got.(map[string]interface{})
+	if !wantIsMap || !gotIsMap {
+		if !reflect.DeepEqual(want, got) {
+			*drift = append(*drift, fmt.Sprintf("%s: expected %v, got %v", path, want, got))
+		}
+		return
+	}
+
+	keys := make([]string, 0, len(wantMap)+len(gotMap))
+	for key := range wantMap {
+		keys = append(keys, key)
+	}
+	for key := range gotMap {
+		if _, ok := wantMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		wantValue, inWant := wantMap[key]
+		gotValue, inGot := gotMap[key]
+		switch {
+		case !inGot:
+			*drift = append(*drift, fmt.Sprintf("%s: missing in live mapping", keyPath))
+		case !inWant:
+			*drift = append(*drift, fmt.Sprintf("%s: not in expected mapping", keyPath))
+		default:
+			diffValue(keyPath, wantValue, gotValue, drift)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// liveUserMapping is the user mapping as _mapping returns it for an index
+// created from userIndexDefinition with the default analysis.
+const liveUserMapping = `{
+	"dynamic": "strict",
+	"_meta": {"version": 7},
+	"properties": {
+		"id": {"type": "keyword"},
+		"name": {"type": "text", "analyzer": "turkish_folding", "copy_to": ["name_suggest"],
+			"fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+		"name_suggest": {"type": "search_as_you_type", "analyzer": "turkish_folding", "max_shingle_size": 3},
+		"job": {"type": "text", "analyzer": "turkish_folding", "copy_to": ["job_suggest"],
+			"fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+		"job_suggest": {"type": "search_as_you_type", "analyzer": "turkish_folding", "max_shingle_size": 3},
+		"childNames": {"type": "text", "analyzer": "turkish_folding",
+			"fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+		"comment": {"type": "text", "analyzer": "turkish_folding"},
+		"created_at": {"type": "date", "format": "strict_date_optional_time||epoch_millis"},
+		"updated_at": {"type": "date", "format": "strict_date_optional_time||epoch_millis"},
+		"created_by": {"type": "keyword"},
+		"updated_by": {"type": "keyword"},
+		"revision": {"type": "long"},
+		"deleted_at": {"type": "date", "format": "strict_date_optional_time||epoch_millis"}
+	}
+}`
+
+func TestDiffMapping(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(properties map[string]interface{})
+		wantDrift []string
+	}{
+		{
+			name:   "live mapping matches",
+			change: func(properties map[string]interface{}) {},
+		},
+		{
+			name: "shingle size differs",
+			change: func(properties map[string]interface{}) {
+				properties["name_suggest"].(map[string]interface{})["max_shingle_size"] = 4
+			},
+			wantDrift: []string{"properties.name_suggest.max_shingle_size: expected 3, got 4"},
+		},
+		{
+			name: "suggest field missing",
+			change: func(properties map[string]interface{}) {
+				delete(properties, "job_suggest")
+				delete(properties["job"].(map[string]interface{}), "copy_to")
+			},
+			wantDrift: []string{
+				"properties.job.copy_to: missing in live mapping",
+				"properties.job_suggest: missing in live mapping",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var live map[string]interface{}
+			if err := json.Unmarshal([]byte(liveUserMapping), &live); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(live["properties"].(map[string]interface{}))
+
+			drift, err := diffMapping(userIndexDefinition(AnalysisConfig{}).Mappings, live)
+			if err != nil {
+				t.Fatalf("diffMapping() error = %v", err)
+			}
+			if !reflect.DeepEqual(drift, tt.wantDrift) {
+				t.Errorf("diffMapping() = %q, want %q", drift, tt.wantDrift)
+			}
+		})
+	}
+}
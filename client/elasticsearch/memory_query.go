@@ -0,0 +1,981 @@
+package elasticsearch
+
+import (
+	"elastic-project/model"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// memoryMatcher reports whether a user matches a compiled query and with
+// which score.
+type memoryMatcher func(u UserInfo) (bool, float64)
+
+type fieldKind int
+
+const (
+	textKind fieldKind = iota
+	keywordKind
+	dateKind
+	numberKind
+)
+
+// memoryTerms collects, per user field, checks for the indexed terms a query
+// searches for, so matches can be highlighted.
+type memoryTerms struct {
+	checks map[string][]func(token string) bool
+}
+
+func newMemoryTerms() *memoryTerms {
+	return &memoryTerms{checks: map[string][]func(string) bool{}}
+}
+
+// add records the check for a text field; queries on other fields are not
+// highlighted.
+func (t *memoryTerms) add(field string, check func(token string) bool) {
+	if t == nil || kindOf(field) != textKind {
+		return
+	}
+	field = baseField(field)
+	t.checks[field] = append(t.checks[field], check)
+}
+
+func (t *memoryTerms) has(field string) bool {
+	return len(t.checks[field]) > 0
+}
+
+func (t *memoryTerms) matches(field string, token string) bool {
+	for _, check := range t.checks[field] {
+		if check(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileQuery translates a query clause into a matcher, following the
+// semantics of the user index mapping: name, job, comment and childNames are
+// analyzed text with a .keyword subfield, the audit fields are keywords and
+// dates. Unknown or malformed clauses fail with model.ErrInvalidQuery, like
+// the 400 Elasticsearch answers with.
+func compileQuery(query interface{}, terms *memoryTerms) (memoryMatcher, error) {
+	if query == nil {
+		return matchAll(1), nil
+	}
+
+	clause, ok := query.(map[string]interface{})
+	if !ok || len(clause) != 1 {
+		return nil, queryError("a query must be an object with exactly one clause")
+	}
+
+	for clauseType, body := range clause {
+		switch clauseType {
+		case "bool":
+			return compileBool(body, terms)
+		case "constant_score":
+			return compileConstantScore(body, terms)
+		case "dis_max":
+			return compileDisMax(body, terms)
+		case "boosting":
+			return compileBoosting(body, terms)
+		case "match_all":
+			return matchAll(boost(body)), nil
+		case "match_none":
+			return func(UserInfo) (bool, float64) { return false, 0 }, nil
+		case "ids":
+			return compileIDs(body)
+		case "exists":
+			return compileExists(body)
+		case "multi_match":
+			return compileMultiMatch(body, terms)
+		case "terms":
+			return compileTerms(body)
+		case "range":
+			return compileRange(body)
+		case "match", "match_phrase", "match_phrase_prefix", "match_bool_prefix",
+			"term", "prefix", "wildcard", "regexp", "fuzzy":
+			field, value, options, err := leafClause(clauseType, body)
+			if err != nil {
+				return nil, err
+			}
+			return compileLeaf(clauseType, field, value, options, terms)
+		default:
+			return nil, queryError("unknown query [%s]", clauseType)
+		}
+	}
+	return nil, nil
+}
+
+func queryError(format string, args ...interface{}) error {
+	return fmt.Errorf("search: %w: %s", model.ErrInvalidQuery, fmt.Sprintf(format, args...))
+}
+
+func matchAll(score float64) memoryMatcher {
+	return func(UserInfo) (bool, float64) { return true, score }
+}
+
+func boost(body interface{}) float64 {
+	if options, ok := body.(map[string]interface{}); ok {
+		if b, ok := options["boost"].(float64); ok {
+			return b
+		}
+	}
+	return 1
+}
+
+// clauseList accepts a single clause or an array of clauses.
+func clauseList(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+func compileClauses(value interface{}, terms *memoryTerms) ([]memoryMatcher, error) {
+	var matchers []memoryMatcher
+	for _, clause := range clauseList(value) {
+		matcher, err := compileQuery(clause, terms)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+func compileBool(body interface{}, terms *memoryTerms) (memoryMatcher, error) {
+	options, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, queryError("[bool] query malformed")
+	}
+
+	must, err := compileClauses(options["must"], terms)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := compileClauses(options["filter"], terms)
+	if err != nil {
+		return nil, err
+	}
+	mustNot, err := compileClauses(options["must_not"], nil)
+	if err != nil {
+		return nil, err
+	}
+	should, err := compileClauses(options["should"], terms)
+	if err != nil {
+		return nil, err
+	}
+
+	required := 0
+	if len(should) > 0 && len(must) == 0 && len(filter) == 0 {
+		required = 1
+	}
+	if msm, ok := options["minimum_should_match"]; ok {
+		if required, err = minimumShouldMatch(msm, len(should)); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(u UserInfo) (bool, float64) {
+		score := 0.0
+		for _, m := range must {
+			ok, s := m(u)
+			if !ok {
+				return false, 0
+			}
+			score += s
+		}
+		for _, m := range filter {
+			if ok, _ := m(u); !ok {
+				return false, 0
+			}
+		}
+		for _, m := range mustNot {
+			if ok, _ := m(u); ok {
+				return false, 0
+			}
+		}
+		matched := 0
+		for _, m := range should {
+			if ok, s := m(u); ok {
+				matched++
+				score += s
+			}
+		}
+		if matched < required {
+			return false, 0
+		}
+		return true, score
+	}, nil
+}
+
+// minimumShouldMatch resolves a count, a negative count or a percentage
+// against the number of should clauses.
+func minimumShouldMatch(value interface{}, should int) (int, error) {
+	text := strings.TrimSpace(fmt.Sprint(value))
+	if f, ok := value.(float64); ok {
+		text = strconv.Itoa(int(f))
+	}
+
+	percent := strings.HasSuffix(text, "%")
+	number, err := strconv.Atoi(strings.TrimSuffix(text, "%"))
+	if err != nil {
+		return 0, queryError("invalid minimum_should_match %q", text)
+	}
+
+	count := number
+	if percent {
+		count = int(math.Floor(float64(should) * math.Abs(float64(number)) / 100))
+		if number < 0 {
+			count = should - count
+		}
+	} else if number < 0 {
+		count = should + number
+	}
+	if count < 0 {
+		count = 0
+	}
+	return count, nil
+}
+
+func compileConstantScore(body interface{}, terms *memoryTerms) (memoryMatcher, error) {
+	options, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, queryError("[constant_score] query malformed")
+	}
+	filter, err := compileQuery(options["filter"], terms)
+	if err != nil {
+		return nil, err
+	}
+	score := boost(body)
+	return func(u UserInfo) (bool, float64) {
+		ok, _ := filter(u)
+		return ok, score
+	}, nil
+}
+
+func compileDisMax(body interface{}, terms *memoryTerms) (memoryMatcher, error) {
+	options, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, queryError("[dis_max] query malformed")
+	}
+	queries, err := compileClauses(options["queries"], terms)
+	if err != nil {
+		return nil, err
+	}
+	return func(u UserInfo) (bool, float64) {
+		matched, best := false, 0.0
+		for _, m := range queries {
+			if ok, s := m(u); ok {
+				matched = true
+				best = math.Max(best, s)
+			}
+		}
+		return matched, best
+	}, nil
+}
+
+func compileBoosting(body interface{}, terms *memoryTerms) (memoryMatcher, error) {
+	options, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, queryError("[boosting] query malformed")
+	}
+	positive, err := compileQuery(options["positive"], terms)
+	if err != nil {
+		return nil, err
+	}
+	negative, err := compileQuery(options["negative"], nil)
+	if err != nil {
+		return nil, err
+	}
+	negativeBoost, _ := options["negative_boost"].(float64)
+	return func(u UserInfo) (bool, float64) {
+		ok, score := positive(u)
+		if !ok {
+			return false, 0
+		}
+		if ok, _ := negative(u); ok {
+			score *= negativeBoost
+		}
+		return true, score
+	}, nil
+}
+
+func compileIDs(body interface{}) (memoryMatcher, error) {
+	options, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, queryError("[ids] query malformed")
+	}
+	ids := map[string]bool{}
+	for _, id := range clauseList(options["values"]) {
+		ids[scalarString(id)] = true
+	}
+	return func(u UserInfo) (bool, float64) { return ids[u.ID], 1 }, nil
+}
+
+func compileExists(body interface{}) (memoryMatcher, error) {
+	options, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, queryError("[exists] query malformed")
+	}
+	field, ok := options["field"].(string)
+	if !ok {
+		return nil, queryError("[exists] query needs a field")
+	}
+	return func(u UserInfo) (bool, float64) {
+		values, _ := fieldValues(u, field)
+		return len(values) > 0, 1
+	}, nil
+}
+
+func compileTerms(body interface{}) (memoryMatcher, error) {
+	options, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, queryError("[terms] query malformed")
+	}
+
+	var matchers []memoryMatcher
+	for field, values := range options {
+		if field == "boost" {
+			continue
+		}
+		list, ok := values.([]interface{})
+		if !ok {
+			return nil, queryError("[terms] query needs an array of values for [%s]", field)
+		}
+		for _, value := range list {
+			matcher, err := compileLeaf("term", field, scalarString(value), nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, matcher)
+		}
+	}
+	return func(u UserInfo) (bool, float64) {
+		for _, m := range matchers {
+			if ok, _ := m(u); ok {
+				return true, 1
+			}
+		}
+		return false, 0
+	}, nil
+}
+
+func compileMultiMatch(body interface{}, terms *memoryTerms) (memoryMatcher, error) {
+	options, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, queryError("[multi_match] query malformed")
+	}
+	query := scalarString(options["query"])
+
+	fields := []string{"name", "job", "comment", "childNames"}
+	if list, ok := options["fields"].([]interface{}); ok && len(list) > 0 {
+		fields = fields[:0]
+		for _, field := range list {
+			fields = append(fields, strings.SplitN(scalarString(field), "^", 2)[0])
+		}
+	}
+
+	clauseType := "match"
+	switch options["type"] {
+	case "phrase":
+		clauseType = "match_phrase"
+	case "phrase_prefix":
+		clauseType = "match_phrase_prefix"
+	case "bool_prefix":
+		clauseType = "match_bool_prefix"
+	}
+
+	var matchers []memoryMatcher
+	for _, field := range fields {
+		matcher, err := compileLeaf(clauseType, field, query, options, terms)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return func(u UserInfo) (bool, float64) {
+		matched, best := false, 0.0
+		for _, m := range matchers {
+			if ok, s := m(u); ok {
+				matched = true
+				best = math.Max(best, s)
+			}
+		}
+		return matched, best
+	}, nil
+}
+
+// leafClause reads {field: value} or {field: {value|query: value, ...}}.
+func leafClause(clauseType string, body interface{}) (string, string, map[string]interface{}, error) {
+	clause, ok := body.(map[string]interface{})
+	if !ok || len(clause) != 1 {
+		return "", "", nil, queryError("[%s] query needs exactly one field", clauseType)
+	}
+
+	for field, value := range clause {
+		options, ok := value.(map[string]interface{})
+		if !ok {
+			return field, scalarString(value), nil, nil
+		}
+		for _, key := range []string{"query", "value", "wildcard"} {
+			if v, ok := options[key]; ok {
+				return field, scalarString(v), options, nil
+			}
+		}
+		return "", "", nil, queryError("[%s] query on [%s] needs a value", clauseType, field)
+	}
+	return "", "", nil, nil
+}
+
+func compileLeaf(clauseType string, field string, value string, options map[string]interface{}, terms *memoryTerms) (memoryMatcher, error) {
+	kind := kindOf(field)
+	caseInsensitive, _ := options["case_insensitive"].(bool)
+	score := boost(options)
+
+	var match func(values []string) bool
+	switch clauseType {
+	case "match", "match_phrase", "match_phrase_prefix", "match_bool_prefix":
+		if kind != textKind {
+			return compileLeaf("term", field, value, options, nil)
+		}
+		tokens := analyze(value)
+		if len(tokens) == 0 {
+			return func(UserInfo) (bool, float64) { return false, 0 }, nil
+		}
+		return compileTextMatch(clauseType, field, tokens, options, terms, score)
+	case "term":
+		check := func(v string) bool { return equalValue(kind, v, value, caseInsensitive) }
+		terms.add(field, check)
+		match = anyValue(kind, check)
+	case "prefix":
+		check := func(v string) bool {
+			if caseInsensitive {
+				return strings.HasPrefix(strings.ToLower(v), strings.ToLower(value))
+			}
+			return strings.HasPrefix(v, value)
+		}
+		terms.add(field, check)
+		match = anyValue(kind, check)
+	case "wildcard", "regexp":
+		pattern := value
+		if clauseType == "wildcard" {
+			pattern = wildcardPattern(value)
+		}
+		if caseInsensitive {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, queryError("[%s] invalid pattern %q", clauseType, value)
+		}
+		terms.add(field, re.MatchString)
+		match = anyValue(kind, re.MatchString)
+	case "fuzzy":
+		fuzziness, err := parseFuzziness(options["fuzziness"], value)
+		if err != nil {
+			return nil, err
+		}
+		check := func(v string) bool { return editDistance(v, value) <= fuzziness }
+		terms.add(field, check)
+		match = anyValue(kind, check)
+	}
+
+	return func(u UserInfo) (bool, float64) {
+		values, _ := fieldValues(u, field)
+		if match(values) {
+			return true, score
+		}
+		return false, 0
+	}, nil
+}
+
+// compileTextMatch handles the full text queries on an analyzed field. The
+// score counts the matched query terms.
+func compileTextMatch(clauseType string, field string, tokens []string, options map[string]interface{}, terms *memoryTerms, score float64) (memoryMatcher, error) {
+	operatorAnd := strings.EqualFold(scalarString(options["operator"]), "and")
+	fuzziness := 0
+	if f, ok := options["fuzziness"]; ok && clauseType == "match" {
+		var err error
+		if fuzziness, err = parseFuzziness(f, tokens[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := ""
+	if clauseType == "match_phrase_prefix" || clauseType == "match_bool_prefix" {
+		prefix = tokens[len(tokens)-1]
+	}
+	tokenMatches := func(fieldToken string, queryToken string, isPrefix bool) bool {
+		if isPrefix {
+			return strings.HasPrefix(fieldToken, queryToken)
+		}
+		if fuzziness > 0 {
+			return editDistance(fieldToken, queryToken) <= fuzziness
+		}
+		return fieldToken == queryToken
+	}
+
+	terms.add(field, func(token string) bool {
+		for i, queryToken := range tokens {
+			if tokenMatches(token, queryToken, prefix != "" && i == len(tokens)-1) {
+				return true
+			}
+		}
+		return false
+	})
+
+	return func(u UserInfo) (bool, float64) {
+		values, _ := fieldValues(u, field)
+		best := 0
+		for _, value := range values {
+			fieldTokens := analyze(value)
+			switch clauseType {
+			case "match_phrase", "match_phrase_prefix":
+				if containsPhrase(fieldTokens, tokens, prefix != "") {
+					return true, score * float64(len(tokens))
+				}
+			default:
+				matched := 0
+				for i, queryToken := range tokens {
+					isPrefix := prefix != "" && i == len(tokens)-1
+					for _, fieldToken := range fieldTokens {
+						if tokenMatches(fieldToken, queryToken, isPrefix) {
+							matched++
+							break
+						}
+					}
+				}
+				if matched > best {
+					best = matched
+				}
+			}
+		}
+		if best == 0 || (operatorAnd && best < len(tokens)) {
+			return false, 0
+		}
+		return true, score * float64(best)
+	}, nil
+}
+
+func containsPhrase(fieldTokens []string, phrase []string, lastIsPrefix bool) bool {
+	for start := 0; start+len(phrase) <= len(fieldTokens); start++ {
+		matched := true
+		for i, token := range phrase {
+			fieldToken := fieldTokens[start+i]
+			if lastIsPrefix && i == len(phrase)-1 {
+				matched = strings.HasPrefix(fieldToken, token)
+			} else {
+				matched = fieldToken == token
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// anyValue applies a term level check to the indexed terms of a field: the
+// analyzed tokens of text fields, the whole values of every other field.
+func anyValue(kind fieldKind, check func(string) bool) func([]string) bool {
+	return func(values []string) bool {
+		for _, value := range values {
+			candidates := []string{value}
+			if kind == textKind {
+				candidates = analyze(value)
+			}
+			for _, candidate := range candidates {
+				if check(candidate) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func equalValue(kind fieldKind, fieldValue string, value string, caseInsensitive bool) bool {
+	switch kind {
+	case dateKind:
+		a, errA := parseDate(fieldValue, time.Now())
+		b, errB := parseDate(value, time.Now())
+		return errA == nil && errB == nil && a.Equal(b)
+	case numberKind:
+		a, errA := strconv.ParseFloat(fieldValue, 64)
+		b, errB := strconv.ParseFloat(value, 64)
+		return errA == nil && errB == nil && a == b
+	}
+	if caseInsensitive {
+		return strings.EqualFold(fieldValue, value)
+	}
+	return fieldValue == value
+}
+
+func compileRange(body interface{}) (memoryMatcher, error) {
+	clause, ok := body.(map[string]interface{})
+	if !ok || len(clause) != 1 {
+		return nil, queryError("[range] query needs exactly one field")
+	}
+
+	for field, value := range clause {
+		bounds, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, queryError("[range] query on [%s] malformed", field)
+		}
+		kind := kindOf(field)
+		now := time.Now()
+
+		type bound struct {
+			operator string
+			value    string
+		}
+		var checks []bound
+		for _, operator := range []string{"gt", "gte", "lt", "lte"} {
+			if v, ok := bounds[operator]; ok && v != nil {
+				checks = append(checks, bound{operator, scalarString(v)})
+				if kind == dateKind {
+					if _, err := parseDate(scalarString(v), now); err != nil {
+						return nil, queryError("failed to parse date field [%s]", scalarString(v))
+					}
+				}
+			}
+		}
+
+		compare := func(a string, b string) (int, bool) {
+			switch kind {
+			case dateKind:
+				x, errX := parseDate(a, now)
+				y, errY := parseDate(b, now)
+				if errX != nil || errY != nil {
+					return 0, false
+				}
+				return compareTimes(x, y), true
+			case numberKind:
+				x, errX := strconv.ParseFloat(a, 64)
+				y, errY := strconv.ParseFloat(b, 64)
+				if errX != nil || errY != nil {
+					return 0, false
+				}
+				return compareFloats(x, y), true
+			}
+			return strings.Compare(a, b), true
+		}
+
+		return func(u UserInfo) (bool, float64) {
+			values, _ := fieldValues(u, field)
+			for _, v := range values {
+				candidates := []string{v}
+				if kind == textKind {
+					candidates = analyze(v)
+				}
+				for _, candidate := range candidates {
+					inRange := true
+					for _, check := range checks {
+						c, ok := compare(candidate, check.value)
+						if !ok {
+							inRange = false
+							break
+						}
+						switch check.operator {
+						case "gt":
+							inRange = c > 0
+						case "gte":
+							inRange = c >= 0
+						case "lt":
+							inRange = c < 0
+						case "lte":
+							inRange = c <= 0
+						}
+						if !inRange {
+							break
+						}
+					}
+					if inRange {
+						return true, 1
+					}
+				}
+			}
+			return false, 0
+		}, nil
+	}
+	return nil, nil
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// parseDate accepts the date formats of the mapping and now with simple date
+// math, like now-7d.
+func parseDate(value string, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(value, "now") {
+		return dateMath(now, strings.TrimPrefix(value, "now"))
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis).UTC(), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+func dateMath(t time.Time, expression string) (time.Time, error) {
+	for expression != "" {
+		sign := expression[0]
+		if sign != '+' && sign != '-' {
+			return time.Time{}, fmt.Errorf("invalid date math %q", expression)
+		}
+		i := 1
+		for i < len(expression) && unicode.IsDigit(rune(expression[i])) {
+			i++
+		}
+		if i == 1 || i == len(expression) {
+			return time.Time{}, fmt.Errorf("invalid date math %q", expression)
+		}
+		amount, _ := strconv.Atoi(expression[1:i])
+		if sign == '-' {
+			amount = -amount
+		}
+		switch expression[i] {
+		case 'y':
+			t = t.AddDate(amount, 0, 0)
+		case 'M':
+			t = t.AddDate(0, amount, 0)
+		case 'w':
+			t = t.AddDate(0, 0, 7*amount)
+		case 'd':
+			t = t.AddDate(0, 0, amount)
+		case 'h', 'H':
+			t = t.Add(time.Duration(amount) * time.Hour)
+		case 'm':
+			t = t.Add(time.Duration(amount) * time.Minute)
+		case 's':
+			t = t.Add(time.Duration(amount) * time.Second)
+		default:
+			return time.Time{}, fmt.Errorf("invalid date math %q", expression)
+		}
+		expression = expression[i+1:]
+	}
+	return t, nil
+}
+
+// parseFuzziness resolves a fuzziness of 0, 1, 2 or AUTO for the given term.
+func parseFuzziness(value interface{}, term string) (int, error) {
+	text := strings.ToUpper(scalarString(value))
+	if value == nil || strings.HasPrefix(text, "AUTO") {
+		low, high := 3, 6
+		if parts := strings.Split(strings.TrimPrefix(text, "AUTO:"), ","); len(parts) == 2 && text != "AUTO" {
+			low, _ = strconv.Atoi(parts[0])
+			high, _ = strconv.Atoi(parts[1])
+		}
+		length := len([]rune(term))
+		switch {
+		case length < low:
+			return 0, nil
+		case length < high:
+			return 1, nil
+		}
+		return 2, nil
+	}
+
+	fuzziness, err := strconv.Atoi(text)
+	if err != nil || fuzziness < 0 || fuzziness > 2 {
+		return 0, queryError("invalid fuzziness %q", text)
+	}
+	return fuzziness, nil
+}
+
+// editDistance is the optimal string alignment distance, which counts a
+// transposition as one edit like Elasticsearch does by default.
+func editDistance(a string, b string) int {
+	x, y := []rune(a), []rune(b)
+	d := make([][]int, len(x)+1)
+	for i := range d {
+		d[i] = make([]int, len(y)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(x)][len(y)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func wildcardPattern(value string) string {
+	var pattern strings.Builder
+	for _, r := range value {
+		switch r {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return pattern.String()
+}
+
+// analyze mimics the standard analyzer: lower cased runs of letters and
+// digits.
+func analyze(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// copiedFields maps the fields filled through copy_to to their source.
+var copiedFields = map[string]string{"name_suggest": "name", "job_suggest": "job"}
+
+// baseField returns the field a subfield or copy_to field indexes.
+func baseField(field string) string {
+	base := strings.SplitN(field, ".", 2)[0]
+	if source, ok := copiedFields[base]; ok {
+		return source
+	}
+	return base
+}
+
+func kindOf(field string) fieldKind {
+	switch baseField(field) {
+	case "name", "job", "comment", "childNames":
+		if strings.HasSuffix(field, ".keyword") {
+			return keywordKind
+		}
+		return textKind
+	case "created_at", "updated_at", "deleted_at":
+		return dateKind
+	case "revision":
+		return numberKind
+	}
+	return keywordKind
+}
+
+// isMappedField reports whether the user index mapping has the field or
+// subfield.
+func isMappedField(field string) bool {
+	switch field {
+	case "id", "name", "job", "comment", "childNames",
+		"created_at", "updated_at", "deleted_at", "created_by", "updated_by", "revision",
+		"name.keyword", "job.keyword", "childNames.keyword",
+		"name_suggest", "name_suggest._2gram", "name_suggest._3gram", "name_suggest._index_prefix",
+		"job_suggest", "job_suggest._2gram", "job_suggest._3gram", "job_suggest._index_prefix":
+		return true
+	}
+	return false
+}
+
+// fieldValues returns the values a user holds for a field, in the form they
+// are indexed. Unmapped fields and fields a user does not have return no
+// values.
+func fieldValues(u UserInfo, field string) ([]string, fieldKind) {
+	kind := kindOf(field)
+	if !isMappedField(field) {
+		return nil, kind
+	}
+	formatTime := func(t *time.Time) []string {
+		if t == nil {
+			return nil
+		}
+		return []string{t.UTC().Format(time.RFC3339Nano)}
+	}
+	nonEmpty := func(value string) []string {
+		if value == "" {
+			return nil
+		}
+		return []string{value}
+	}
+
+	switch baseField(field) {
+	case "id":
+		return []string{u.ID}, kind
+	case "name":
+		return []string{u.Name}, kind
+	case "job":
+		return []string{u.Job}, kind
+	case "comment":
+		return []string{u.Comment}, kind
+	case "childNames":
+		return u.ChildNames, kind
+	case "created_at":
+		return formatTime(u.CreatedAt), kind
+	case "updated_at":
+		return formatTime(u.UpdatedAt), kind
+	case "deleted_at":
+		return formatTime(u.DeletedAt), kind
+	case "created_by":
+		return nonEmpty(u.CreatedBy), kind
+	case "updated_by":
+		return nonEmpty(u.UpdatedBy), kind
+	case "revision":
+		if u.Revision == 0 {
+			return nil, kind
+		}
+		return []string{strconv.FormatInt(u.Revision, 10)}, kind
+	}
+	return nil, kind
+}
+
+func scalarString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
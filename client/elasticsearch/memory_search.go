@@ -0,0 +1,581 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// memoryMaxResultWindow mirrors index.max_result_window.
+	memoryMaxResultWindow = 10000
+	// memoryFragments mirrors the default number_of_fragments of highlight.
+	memoryFragments = 5
+)
+
+type memorySort struct {
+	field string
+	desc  bool
+}
+
+type memoryHit struct {
+	user  UserInfo
+	score float64
+	sort  []interface{}
+}
+
+func (m *MemoryStorage) FindByConditions(conditions Conditions, page SearchPage, highlight *Highlight) (SearchResult, error) {
+	return m.search(map[string]interface{}{"query": conditionsQuery(conditions)}, page, highlight)
+}
+
+func (m *MemoryStorage) FindByQuery(body map[string]interface{}, page SearchPage, highlight *Highlight) (SearchResult, error) {
+	return m.search(body, page, highlight)
+}
+
+func (m *MemoryStorage) FindByQueryClause(query map[string]interface{}, page SearchPage, highlight *Highlight) (SearchResult, error) {
+	return m.search(map[string]interface{}{"query": query}, page, highlight)
+}
+
+// search runs the body like UserInfoStorage.search: sorted by score and id
+// unless the body sorts otherwise, paged with from or search_after.
+func (m *MemoryStorage) search(body map[string]interface{}, page SearchPage, highlight *Highlight) (SearchResult, error) {
+	query := body["query"]
+	if !page.IncludeDeleted {
+		query = excludeDeleted(query)
+	}
+
+	terms := newMemoryTerms()
+	matcher, err := compileQuery(query, terms)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	sorts, err := parseSort(body["sort"])
+	if err != nil {
+		return SearchResult{}, err
+	}
+	if len(page.SearchAfter) > 0 && len(page.SearchAfter) != len(sorts) {
+		return SearchResult{}, queryError("search_after has %d value(s) but sort has %d", len(page.SearchAfter), len(sorts))
+	}
+	if len(page.SearchAfter) == 0 && page.From+page.Size > memoryMaxResultWindow {
+		return SearchResult{}, queryError("result window is too large, from + size must be less than or equal to [%d]", memoryMaxResultWindow)
+	}
+
+	minScore, hasMinScore := body["min_score"].(float64)
+	hits := m.matching(matcher, sorts)
+	if hasMinScore {
+		kept := hits[:0]
+		for _, hit := range hits {
+			if hit.score >= minScore {
+				kept = append(kept, hit)
+			}
+		}
+		hits = kept
+	}
+
+	result := SearchResult{
+		Total:  int64(len(hits)),
+		Shards: ShardsInfo{Total: 1, Successful: 1},
+		Hits:   []SearchHit{},
+	}
+
+	start := page.From
+	if len(page.SearchAfter) > 0 {
+		start = sort.Search(len(hits), func(i int) bool {
+			return compareSortKeys(hits[i].sort, page.SearchAfter, sorts) > 0
+		})
+	}
+	if start > len(hits) {
+		start = len(hits)
+	}
+	end := start + page.Size
+	if end > len(hits) {
+		end = len(hits)
+	}
+
+	trackScores, _ := body["track_scores"].(bool)
+	scored := trackScores
+	for _, s := range sorts {
+		scored = scored || s.field == "_score"
+	}
+
+	for _, hit := range hits[start:end] {
+		searchHit := SearchHit{ID: hit.user.ID, Sort: hit.sort}
+		if scored {
+			score := hit.score
+			searchHit.Score = &score
+		}
+		if highlight != nil {
+			searchHit.Highlight = highlightUser(hit.user, terms, *highlight)
+		}
+		if searchHit.User, err = sourceFilter(hit.user, body["_source"]); err != nil {
+			return SearchResult{}, err
+		}
+		result.Hits = append(result.Hits, searchHit)
+	}
+
+	return result, nil
+}
+
+// matching returns copies of the users matching the query, sorted.
+func (m *MemoryStorage) matching(matcher memoryMatcher, sorts []memorySort) []memoryHit {
+	m.mu.RLock()
+	var hits []memoryHit
+	for _, stored := range m.users {
+		if ok, score := matcher(stored.user); ok {
+			hits = append(hits, memoryHit{user: cloneUser(stored.user), score: score})
+		}
+	}
+	m.mu.RUnlock()
+
+	for i := range hits {
+		hits[i].sort = sortKeys(hits[i], sorts)
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return compareSortKeys(hits[i].sort, hits[j].sort, sorts) < 0
+	})
+	return hits
+}
+
+// parseSort reads the sort of a search body: a field name, a {field: order}
+// or {field: {"order": order}} object, or an array of them. Text fields
+// cannot be sorted on, as they have no doc values.
+func parseSort(spec interface{}) ([]memorySort, error) {
+	if spec == nil {
+		return []memorySort{{field: "_score", desc: true}, {field: "id"}}, nil
+	}
+
+	var sorts []memorySort
+	for _, entry := range clauseList(spec) {
+		switch e := entry.(type) {
+		case string:
+			sorts = append(sorts, memorySort{field: e, desc: e == "_score"})
+		case map[string]interface{}:
+			for field, order := range e {
+				if options, ok := order.(map[string]interface{}); ok {
+					order = options["order"]
+				}
+				s := memorySort{field: field, desc: field == "_score"}
+				switch strings.ToLower(scalarString(order)) {
+				case "":
+				case "asc":
+					s.desc = false
+				case "desc":
+					s.desc = true
+				default:
+					return nil, queryError("unknown sort order %q", scalarString(order))
+				}
+				sorts = append(sorts, s)
+			}
+		default:
+			return nil, queryError("malformed sort")
+		}
+	}
+
+	for _, s := range sorts {
+		switch {
+		case s.field == "_score" || s.field == "_doc" || s.field == "_shard_doc":
+		case !isMappedField(s.field):
+			return nil, queryError("no mapping found for [%s] in order to sort on", s.field)
+		case kindOf(s.field) == textKind:
+			return nil, queryError("text fields are not optimised for operations that require per-document field data, use [%s.keyword] instead", s.field)
+		}
+	}
+	return sorts, nil
+}
+
+// sortKeys returns the sort values of a hit the way Elasticsearch does:
+// dates as epoch millis, missing values as nil.
+func sortKeys(hit memoryHit, sorts []memorySort) []interface{} {
+	keys := make([]interface{}, 0, len(sorts))
+	for _, s := range sorts {
+		switch s.field {
+		case "_score":
+			keys = append(keys, hit.score)
+			continue
+		case "_doc", "_shard_doc":
+			keys = append(keys, hit.user.ID)
+			continue
+		}
+
+		values, kind := fieldValues(hit.user, s.field)
+		if len(values) == 0 {
+			keys = append(keys, nil)
+			continue
+		}
+		// Multi valued fields sort on their lowest value ascending and on
+		// their highest value descending.
+		sorted := append([]string{}, values...)
+		sort.Strings(sorted)
+		value := sorted[0]
+		if s.desc {
+			value = sorted[len(sorted)-1]
+		}
+
+		switch kind {
+		case dateKind:
+			t, _ := parseDate(value, time.Now())
+			keys = append(keys, float64(t.UnixMilli()))
+		case numberKind:
+			f, _ := strconv.ParseFloat(value, 64)
+			keys = append(keys, f)
+		default:
+			keys = append(keys, value)
+		}
+	}
+	return keys
+}
+
+// compareSortKeys orders two hits by their sort values. Missing values sort
+// last in either order.
+func compareSortKeys(a []interface{}, b []interface{}, sorts []memorySort) int {
+	for i, s := range sorts {
+		if i >= len(a) || i >= len(b) {
+			break
+		}
+		x, y := a[i], b[i]
+		switch {
+		case x == nil && y == nil:
+			continue
+		case x == nil:
+			return 1
+		case y == nil:
+			return -1
+		}
+
+		c := compareSortValue(x, y)
+		if s.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareSortValue(x interface{}, y interface{}) int {
+	fx, okX := toFloat(x)
+	fy, okY := toFloat(y)
+	if okX && okY {
+		return compareFloats(fx, fy)
+	}
+	return strings.Compare(scalarString(x), scalarString(y))
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// sourceFilter applies the _source option of a search body: false, a
+// pattern, a list of patterns or an includes/excludes object.
+func sourceFilter(userInfo UserInfo, option interface{}) (UserInfo, error) {
+	var includes, excludes []interface{}
+	switch o := option.(type) {
+	case nil:
+		return userInfo, nil
+	case bool:
+		if o {
+			return userInfo, nil
+		}
+		return UserInfo{ID: userInfo.ID}, nil
+	case map[string]interface{}:
+		includes = clauseList(o["includes"])
+		excludes = clauseList(o["excludes"])
+	default:
+		includes = clauseList(o)
+	}
+
+	matches := func(patterns []interface{}, field string) bool {
+		for _, pattern := range patterns {
+			if re, err := regexp.Compile("^" + wildcardPattern(scalarString(pattern)) + "$"); err == nil && re.MatchString(field) {
+				return true
+			}
+		}
+		return false
+	}
+
+	source := map[string]interface{}{}
+	bdy, err := json.Marshal(userInfo)
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("search: marshall: %w", err)
+	}
+	if err := json.Unmarshal(bdy, &source); err != nil {
+		return UserInfo{}, fmt.Errorf("search: unmarshall: %w", err)
+	}
+	for field := range source {
+		if (len(includes) > 0 && !matches(includes, field)) || matches(excludes, field) {
+			delete(source, field)
+		}
+	}
+
+	bdy, err = json.Marshal(source)
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("search: marshall: %w", err)
+	}
+	filtered := decodeUserInfo(bdy)
+	if filtered.ID == "" {
+		filtered.ID = userInfo.ID
+	}
+	return filtered, nil
+}
+
+// highlightUser wraps the terms the query searched for in the text fields,
+// split into fragments of about the fragment size.
+func highlightUser(userInfo UserInfo, terms *memoryTerms, highlight Highlight) map[string][]string {
+	highlights := map[string][]string{}
+	for _, field := range []string{"name", "job", "comment", "childNames"} {
+		if !terms.has(field) {
+			continue
+		}
+		values, _ := fieldValues(userInfo, field)
+		for _, value := range values {
+			highlights[field] = append(highlights[field], highlightValue(value, field, terms, highlight)...)
+		}
+		if len(highlights[field]) > memoryFragments {
+			highlights[field] = highlights[field][:memoryFragments]
+		}
+		if len(highlights[field]) == 0 {
+			delete(highlights, field)
+		}
+	}
+	if len(highlights) == 0 {
+		return nil
+	}
+	return highlights
+}
+
+func highlightValue(value string, field string, terms *memoryTerms, highlight Highlight) []string {
+	var (
+		fragments   []string
+		fragment    strings.Builder
+		length      int
+		highlighted bool
+	)
+	flush := func() {
+		if highlighted {
+			fragments = append(fragments, strings.TrimSpace(fragment.String()))
+		}
+		fragment.Reset()
+		length, highlighted = 0, false
+	}
+
+	runes := []rune(value)
+	for start := 0; start < len(runes); {
+		end := start + 1
+		isWord := unicode.IsLetter(runes[start]) || unicode.IsDigit(runes[start])
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) == isWord {
+			end++
+		}
+		piece := string(runes[start:end])
+
+		if isWord && highlight.FragmentSize > 0 && length > 0 && length+len(runes[start:end]) > highlight.FragmentSize {
+			flush()
+		}
+		if isWord && terms.matches(field, strings.ToLower(piece)) {
+			fragment.WriteString(highlight.PreTag + piece + highlight.PostTag)
+			highlighted = true
+		} else {
+			fragment.WriteString(piece)
+		}
+		length += end - start
+		start = end
+	}
+	flush()
+
+	return fragments
+}
+
+// Export calls fn for the matching users in id order. The users are copied
+// before fn is called, so fn may write to the storage.
+func (m *MemoryStorage) Export(ctx context.Context, query map[string]interface{}, includeDeleted bool, fn func(UserInfo) error) error {
+	var clause interface{}
+	if query != nil {
+		clause = query
+	}
+	if !includeDeleted {
+		clause = excludeDeleted(clause)
+	}
+	matcher, err := compileQuery(clause, nil)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	for _, hit := range m.matching(matcher, []memorySort{{field: "id"}}) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(hit.user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Facets(ctx context.Context, query map[string]interface{}, options FacetOptions) (FacetResult, error) {
+	var clause interface{}
+	if query != nil {
+		clause = query
+	}
+	if !options.IncludeDeleted {
+		clause = excludeDeleted(clause)
+	}
+	matcher, err := compileQuery(clause, nil)
+	if err != nil {
+		return FacetResult{}, fmt.Errorf("facets: %w", err)
+	}
+
+	jobs := map[string]int64{}
+	createdAt := map[time.Time]int64{}
+	childCount := map[int]int64{}
+	hits := m.matching(matcher, []memorySort{{field: "id"}})
+	for _, hit := range hits {
+		if len(hit.user.Job) <= 256 {
+			jobs[hit.user.Job]++
+		}
+		if hit.user.CreatedAt != nil {
+			bucket, err := calendarBucket(*hit.user.CreatedAt, options.CalendarInterval)
+			if err != nil {
+				return FacetResult{}, fmt.Errorf("facets: %w", err)
+			}
+			createdAt[bucket]++
+		}
+		childCount[len(hit.user.ChildNames)]++
+	}
+
+	result := FacetResult{Total: int64(len(hits))}
+	for job, count := range jobs {
+		result.Jobs = append(result.Jobs, FacetBucket{Key: job, Count: count})
+	}
+	sort.Slice(result.Jobs, func(i, j int) bool {
+		if result.Jobs[i].Count != result.Jobs[j].Count {
+			return result.Jobs[i].Count > result.Jobs[j].Count
+		}
+		return result.Jobs[i].Key < result.Jobs[j].Key
+	})
+	if options.JobSize > 0 && len(result.Jobs) > options.JobSize {
+		result.Jobs = result.Jobs[:options.JobSize]
+	}
+
+	for key, count := range createdAt {
+		result.CreatedAt = append(result.CreatedAt, DateFacetBucket{Key: key, Count: count})
+	}
+	sort.Slice(result.CreatedAt, func(i, j int) bool {
+		return result.CreatedAt[i].Key.Before(result.CreatedAt[j].Key)
+	})
+
+	// The histogram fills the gaps between the lowest and highest count.
+	if len(childCount) > 0 {
+		low, high := math.MaxInt32, 0
+		for count := range childCount {
+			low, high = minInt(low, count), maxInt(high, count)
+		}
+		for count := low; count <= high; count++ {
+			result.ChildCount = append(result.ChildCount, HistogramFacetBucket{Key: float64(count), Count: childCount[count]})
+		}
+	}
+
+	return result, nil
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// calendarBucket truncates t to the start of its calendar interval in UTC.
+func calendarBucket(t time.Time, interval string) (time.Time, error) {
+	t = t.UTC()
+	year, month, day := t.Date()
+	switch interval {
+	case "minute", "1m":
+		return t.Truncate(time.Minute), nil
+	case "hour", "1h":
+		return t.Truncate(time.Hour), nil
+	case "day", "1d":
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC), nil
+	case "week", "1w":
+		weekday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-weekday, 0, 0, 0, 0, time.UTC), nil
+	case "month", "1M":
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC), nil
+	case "quarter", "1q":
+		return time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, time.UTC), nil
+	case "year", "1y":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Time{}, fmt.Errorf("the supplied interval [%s] could not be parsed as a calendar interval", interval)
+}
+
+// Suggest runs the same bool_prefix query as UserInfoStorage.Suggest and
+// keeps the best hit per value.
+func (m *MemoryStorage) Suggest(ctx context.Context, field string, prefix string, job string, size int) ([]Suggestion, error) {
+	query := map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must": map[string]interface{}{
+				"multi_match": map[string]interface{}{
+					"query":  prefix,
+					"type":   "bool_prefix",
+					"fields": []interface{}{field + "_suggest"},
+				},
+			},
+		},
+	}
+	if job != "" {
+		query["bool"].(map[string]interface{})["filter"] = []interface{}{
+			map[string]interface{}{"term": map[string]interface{}{"job.keyword": job}},
+		}
+	}
+	matcher, err := compileQuery(excludeDeleted(query), nil)
+	if err != nil {
+		return nil, fmt.Errorf("suggest: %w", err)
+	}
+
+	seen := map[string]bool{}
+	suggestions := []Suggestion{}
+	for _, hit := range m.matching(matcher, []memorySort{{field: "_score", desc: true}, {field: "id"}}) {
+		suggestion := Suggestion{Text: hit.user.Name, Score: hit.score}
+		if field == "job" {
+			suggestion.Text = hit.user.Job
+		}
+		if seen[suggestion.Text] {
+			continue
+		}
+		seen[suggestion.Text] = true
+		suggestions = append(suggestions, suggestion)
+		if len(suggestions) == size {
+			break
+		}
+	}
+
+	return suggestions, nil
+}
+
+// ValidateQuery compiles the query; any error it reports makes the query
+// invalid.
+func (m *MemoryStorage) ValidateQuery(query map[string]interface{}) (QueryValidation, error) {
+	if _, err := compileQuery(query, nil); err != nil {
+		return QueryValidation{Explanation: err.Error()}, nil
+	}
+	return QueryValidation{Valid: true}, nil
+}
@@ -0,0 +1,195 @@
+package elasticsearch
+
+import (
+	"context"
+	"elastic-project/model"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newSearchTestStorage holds three users with overlapping names and jobs.
+func newSearchTestStorage(t *testing.T) *MemoryStorage {
+	t.Helper()
+
+	storage := NewMemoryStorage()
+	for _, user := range []UserInfo{
+		{ID: "1", Name: "Mehmet Yılmaz", Job: "Software Engineer", Comment: "team lead", ChildNames: []string{"Ali", "Ayşe"}, Revision: 1},
+		{ID: "2", Name: "Ahmet Kaya", Job: "Doctor", Comment: "night shift", Revision: 1},
+		{ID: "3", Name: "Ayşe Demir", Job: "Software Architect", Revision: 1},
+	} {
+		if err := storage.Insert(context.Background(), user); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	return storage
+}
+
+func TestMemoryStorageFindByQueryClause(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   map[string]interface{}
+		wantIDs []string
+		wantErr error
+	}{
+		{
+			name:    "match",
+			query:   map[string]interface{}{"match": map[string]interface{}{"name": "MEHMET"}},
+			wantIDs: []string{"1"},
+		},
+		{
+			name:    "match any term",
+			query:   map[string]interface{}{"match": map[string]interface{}{"job": "software engineer"}},
+			wantIDs: []string{"1", "3"},
+		},
+		{
+			name:    "match all terms",
+			query:   map[string]interface{}{"match": map[string]interface{}{"job": map[string]interface{}{"query": "software engineer", "operator": "and"}}},
+			wantIDs: []string{"1"},
+		},
+		{
+			name:    "match with fuzziness",
+			query:   map[string]interface{}{"match": map[string]interface{}{"name": map[string]interface{}{"query": "mehmat", "fuzziness": "AUTO"}}},
+			wantIDs: []string{"1"},
+		},
+		{
+			name:    "match on a keyword subfield is exact",
+			query:   map[string]interface{}{"match": map[string]interface{}{"name.keyword": "ahmet kaya"}},
+			wantIDs: []string{},
+		},
+		{
+			name:    "match_phrase",
+			query:   map[string]interface{}{"match_phrase": map[string]interface{}{"job": "software architect"}},
+			wantIDs: []string{"3"},
+		},
+		{
+			name:    "match_phrase_prefix",
+			query:   map[string]interface{}{"match_phrase_prefix": map[string]interface{}{"job": "software eng"}},
+			wantIDs: []string{"1"},
+		},
+		{
+			name:    "match_phrase_prefix keeps the order",
+			query:   map[string]interface{}{"match_phrase_prefix": map[string]interface{}{"job": "engineer soft"}},
+			wantIDs: []string{},
+		},
+		{
+			name:    "match_phrase_prefix on the suggest field",
+			query:   map[string]interface{}{"match_phrase_prefix": map[string]interface{}{"name_suggest": "ah"}},
+			wantIDs: []string{"2"},
+		},
+		{
+			name:    "wildcard on analyzed terms",
+			query:   map[string]interface{}{"wildcard": map[string]interface{}{"job": map[string]interface{}{"value": "s?ftw*"}}},
+			wantIDs: []string{"1", "3"},
+		},
+		{
+			name:    "wildcard on a keyword is case sensitive",
+			query:   map[string]interface{}{"wildcard": map[string]interface{}{"name.keyword": map[string]interface{}{"value": "ahmet*"}}},
+			wantIDs: []string{},
+		},
+		{
+			name:    "wildcard case insensitive",
+			query:   map[string]interface{}{"wildcard": map[string]interface{}{"name.keyword": map[string]interface{}{"value": "ahmet*", "case_insensitive": true}}},
+			wantIDs: []string{"2"},
+		},
+		{
+			name:    "regexp",
+			query:   map[string]interface{}{"regexp": map[string]interface{}{"job": "doc(tor)?"}},
+			wantIDs: []string{"2"},
+		},
+		{
+			name:    "regexp matches whole terms",
+			query:   map[string]interface{}{"regexp": map[string]interface{}{"job": "soft"}},
+			wantIDs: []string{},
+		},
+		{
+			name:    "regexp on a keyword",
+			query:   map[string]interface{}{"regexp": map[string]interface{}{"job.keyword": map[string]interface{}{"value": "Software (Engineer|Architect)"}}},
+			wantIDs: []string{"1", "3"},
+		},
+		{
+			name:    "fuzzy",
+			query:   map[string]interface{}{"fuzzy": map[string]interface{}{"name": map[string]interface{}{"value": "kya"}}},
+			wantIDs: []string{"2"},
+		},
+		{
+			name:    "fuzzy with two edits",
+			query:   map[string]interface{}{"fuzzy": map[string]interface{}{"name": map[string]interface{}{"value": "dmeri", "fuzziness": 2}}},
+			wantIDs: []string{"3"},
+		},
+		{
+			name:    "fuzzy without edits",
+			query:   map[string]interface{}{"fuzzy": map[string]interface{}{"name": map[string]interface{}{"value": "kya", "fuzziness": 0}}},
+			wantIDs: []string{},
+		},
+		{
+			name: "bool",
+			query: map[string]interface{}{"bool": map[string]interface{}{
+				"must":     []interface{}{map[string]interface{}{"match": map[string]interface{}{"job": "software"}}},
+				"must_not": []interface{}{map[string]interface{}{"term": map[string]interface{}{"childNames.keyword": "Ali"}}},
+			}},
+			wantIDs: []string{"3"},
+		},
+		{
+			name:    "invalid regexp",
+			query:   map[string]interface{}{"regexp": map[string]interface{}{"job": "doc("}},
+			wantErr: model.ErrInvalidQuery,
+		},
+		{
+			name:    "invalid fuzziness",
+			query:   map[string]interface{}{"fuzzy": map[string]interface{}{"name": map[string]interface{}{"value": "kya", "fuzziness": 3}}},
+			wantErr: model.ErrInvalidQuery,
+		},
+		{
+			name:    "unknown query",
+			query:   map[string]interface{}{"script": map[string]interface{}{}},
+			wantErr: model.ErrInvalidQuery,
+		},
+	}
+
+	storage := newSearchTestStorage(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := storage.FindByQueryClause(tt.query, SearchPage{Size: 10}, nil)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("FindByQueryClause() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindByQueryClause() error = %v", err)
+			}
+
+			ids := []string{}
+			for _, hit := range result.Hits {
+				ids = append(ids, hit.ID)
+			}
+			sort.Strings(ids)
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("FindByQueryClause() ids = %v, want %v", ids, tt.wantIDs)
+			}
+			if result.Total != int64(len(tt.wantIDs)) {
+				t.Errorf("FindByQueryClause() total = %d, want %d", result.Total, len(tt.wantIDs))
+			}
+		})
+	}
+}
+
+func TestMemoryStorageSuggest(t *testing.T) {
+	storage := newSearchTestStorage(t)
+
+	suggestions, err := storage.Suggest(context.Background(), "job", "softw", "", 10)
+	if err != nil {
+		t.Fatalf("Suggest() error = %v", err)
+	}
+	var texts []string
+	for _, suggestion := range suggestions {
+		texts = append(texts, suggestion.Text)
+	}
+	sort.Strings(texts)
+	if want := []string{"Software Architect", "Software Engineer"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("Suggest() = %v, want %v", texts, want)
+	}
+}
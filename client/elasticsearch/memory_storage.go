@@ -0,0 +1,569 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps users and their history in memory. It implements
+// UserInfoStorer and HistoryStorer with the semantics of the Elasticsearch
+// storage, including version conflicts and soft deletes, so the API can run
+// without a cluster. Tasks are not supported, so the Start variants of the by
+// query operations fail with model.ErrNotSupported.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	users   map[string]memoryUser
+	history map[string]map[int64]HistoryEntry
+	seqNo   int
+}
+
+type memoryUser struct {
+	user  UserInfo
+	seqNo int
+}
+
+var (
+	_ UserInfoStorer = (*MemoryStorage)(nil)
+	_ HistoryStorer  = (*MemoryStorage)(nil)
+)
+
+// memoryPrimaryTerm is the primary term of every version handed out; there is
+// no failover that would change it.
+const memoryPrimaryTerm = 1
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:   map[string]memoryUser{},
+		history: map[string]map[int64]HistoryEntry{},
+	}
+}
+
+func (m *MemoryStorage) Insert(ctx context.Context, userInfo UserInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userInfo.ID]; ok {
+		return model.ErrConflict
+	}
+	m.put(userInfo)
+	return nil
+}
+
+// Update merges the user into the stored one like a partial update: fields
+// marshalled without omitempty always overwrite, the others only when set.
+func (m *MemoryStorage) Update(ctx context.Context, userInfo UserInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, err := m.findPrevious(userInfo.ID, userInfo.Version, false)
+	if err != nil {
+		return err
+	}
+	userInfo.Revision = previous.CurrentRevision() + 1
+
+	updated, err := mergeUser(previous, userInfo)
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	if err := m.saveHistory(ctx, previous, HistoryUpdate); err != nil {
+		return err
+	}
+	m.put(updated)
+	return nil
+}
+
+func (m *MemoryStorage) Delete(ctx context.Context, id string, version *Version) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, err := m.findPrevious(id, version, false)
+	if err != nil {
+		return err
+	}
+	if err := m.saveHistory(ctx, previous, HistoryDelete); err != nil {
+		return err
+	}
+	delete(m.users, id)
+	return nil
+}
+
+func (m *MemoryStorage) Replace(ctx context.Context, userInfo UserInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, err := m.findPrevious(userInfo.ID, nil, true)
+	exists := err == nil
+	switch {
+	case err == nil:
+		userInfo.Revision = previous.CurrentRevision() + 1
+	case model.ErrNotFound == err:
+		userInfo.Revision = 1
+		if entries := m.findHistory(userInfo.ID, 1); len(entries) > 0 {
+			userInfo.Revision = entries[0].Revision + 1
+		}
+	default:
+		return err
+	}
+
+	if exists {
+		if err := m.saveHistory(ctx, previous, HistoryRestore); err != nil {
+			return err
+		}
+	}
+	m.put(userInfo)
+	return nil
+}
+
+func (m *MemoryStorage) AddChild(ctx context.Context, id string, name string) (bool, error) {
+	return m.updateChildren(ctx, id, name, true)
+}
+
+func (m *MemoryStorage) RemoveChild(ctx context.Context, id string, name string) (bool, error) {
+	return m.updateChildren(ctx, id, name, false)
+}
+
+func (m *MemoryStorage) updateChildren(ctx context.Context, id string, name string, add bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, err := m.find(id, false)
+	if err != nil {
+		return false, err
+	}
+	if containsChild(current.ChildNames, name) == add {
+		return false, nil
+	}
+
+	updated := cloneUser(current)
+	if add {
+		updated.ChildNames = append(updated.ChildNames, name)
+	} else {
+		children := make([]string, 0, len(updated.ChildNames))
+		for _, child := range updated.ChildNames {
+			if child != name {
+				children = append(children, child)
+			}
+		}
+		updated.ChildNames = children
+	}
+	now := time.Now().UTC()
+	updated.UpdatedAt = &now
+	updated.UpdatedBy = model.ActorFromContext(ctx)
+	updated.Revision = current.CurrentRevision() + 1
+
+	if err := m.saveHistory(ctx, current, HistoryUpdate); err != nil {
+		return false, err
+	}
+	m.put(updated)
+	return true, nil
+}
+
+func containsChild(childNames []string, name string) bool {
+	for _, child := range childNames {
+		if child == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStorage) SoftDelete(ctx context.Context, id string, version *Version) error {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.setDeleted(ctx, id, version, &now, HistoryDelete)
+}
+
+func (m *MemoryStorage) Undelete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, err := m.find(id, true)
+	if err != nil {
+		return err
+	}
+	if current.DeletedAt == nil {
+		return model.ErrConflict
+	}
+
+	return m.setDeleted(ctx, id, current.Version, nil, HistoryRestore)
+}
+
+func (m *MemoryStorage) setDeleted(ctx context.Context, id string, version *Version, deletedAt *time.Time, action string) error {
+	previous, err := m.findPrevious(id, version, deletedAt == nil)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	updated := cloneUser(previous)
+	updated.DeletedAt = deletedAt
+	updated.UpdatedAt = &now
+	updated.UpdatedBy = model.ActorFromContext(ctx)
+	updated.Revision = previous.CurrentRevision() + 1
+
+	if err := m.saveHistory(ctx, previous, action); err != nil {
+		return err
+	}
+	m.put(updated)
+	return nil
+}
+
+func (m *MemoryStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for id, stored := range m.users {
+		if stored.user.DeletedAt != nil && stored.user.DeletedAt.Before(before) {
+			delete(m.users, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// UpdateByQuery sets the fields on every matching user that is not soft
+// deleted. Like the Elasticsearch variant it does not record the history.
+func (m *MemoryStorage) UpdateByQuery(ctx context.Context, query map[string]interface{}, set map[string]interface{}, requestsPerSecond int) (ByQueryResult, error) {
+	matcher, err := compileQuery(excludeDeleted(query), nil)
+	if err != nil {
+		return ByQueryResult{}, fmt.Errorf("update by query: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	fields := map[string]interface{}{}
+	for field, value := range set {
+		fields[field] = value
+	}
+	fields["updated_at"] = now
+	fields["updated_by"] = model.ActorFromContext(ctx)
+
+	var result ByQueryResult
+	for _, id := range m.sortedIDs() {
+		current := cloneUser(m.users[id].user)
+		if ok, _ := matcher(current); !ok {
+			continue
+		}
+		result.Total++
+
+		fields["revision"] = current.CurrentRevision() + 1
+		updated, err := setFields(current, fields)
+		if err != nil {
+			result.Failures = append(result.Failures, fmt.Sprintf("%s: %s", id, err))
+			continue
+		}
+		m.put(updated)
+		result.Updated++
+	}
+	return result, nil
+}
+
+func (m *MemoryStorage) DeleteByQuery(ctx context.Context, query map[string]interface{}, requestsPerSecond int) (ByQueryResult, error) {
+	matcher, err := compileQuery(excludeDeleted(query), nil)
+	if err != nil {
+		return ByQueryResult{}, fmt.Errorf("delete by query: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result ByQueryResult
+	for _, id := range m.sortedIDs() {
+		if ok, _ := matcher(m.users[id].user); !ok {
+			continue
+		}
+		result.Total++
+		delete(m.users, id)
+		result.Deleted++
+	}
+	return result, nil
+}
+
+func (m *MemoryStorage) StartUpdateByQuery(ctx context.Context, query map[string]interface{}, set map[string]interface{}, requestsPerSecond int) (string, error) {
+	return "", fmt.Errorf("update by query: %w", model.ErrNotSupported)
+}
+
+func (m *MemoryStorage) StartDeleteByQuery(ctx context.Context, query map[string]interface{}, requestsPerSecond int) (string, error) {
+	return "", fmt.Errorf("delete by query: %w", model.ErrNotSupported)
+}
+
+func (m *MemoryStorage) FindOne(ctx context.Context, id string, includeDeleted bool) (UserInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.find(id, includeDeleted)
+}
+
+// Bulk applies the operations one by one and answers with the statuses the
+// bulk API would: 201 for created users, 200 for changed ones, 404 for
+// missing ones and 409 when create finds an existing user.
+func (m *MemoryStorage) Bulk(ctx context.Context, next func() (BulkOperation, error)) ([]BulkItemResult, error) {
+	var results []BulkItemResult
+	for position := 0; ; position++ {
+		operation, err := next()
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return results, err
+		}
+
+		result := BulkItemResult{Position: position, Action: operation.Action, ID: operation.User.ID}
+		if operation.Rejected != nil {
+			result.Status, result.Error = 400, operation.Rejected.Error()
+			results = append(results, result)
+			continue
+		}
+		m.mu.Lock()
+		m.bulkItem(operation, &result)
+		m.mu.Unlock()
+		results = append(results, result)
+	}
+}
+
+func (m *MemoryStorage) bulkItem(operation BulkOperation, result *BulkItemResult) {
+	stored, exists := m.users[operation.User.ID]
+
+	switch operation.Action {
+	case BulkCreate:
+		if exists {
+			result.Status = 409
+			result.Error = fmt.Sprintf("version_conflict_engine_exception: [%s]: version conflict, document already exists", operation.User.ID)
+			return
+		}
+		m.put(operation.User)
+		result.Status, result.Result = 201, "created"
+	case BulkIndex:
+		user := operation.User
+		result.Status, result.Result = 201, "created"
+		if exists {
+			user.CreatedAt, user.CreatedBy = stored.user.CreatedAt, stored.user.CreatedBy
+			user.Revision = stored.user.CurrentRevision() + 1
+			result.Status, result.Result = 200, "updated"
+		}
+		m.put(user)
+	case BulkUpdate:
+		if !exists {
+			result.Status = 404
+			result.Error = fmt.Sprintf("document_missing_exception: [%s]: document missing", operation.User.ID)
+			return
+		}
+		if stored.user.DeletedAt != nil {
+			result.Status, result.Result = 200, "noop"
+			return
+		}
+		doc := map[string]interface{}{"revision": stored.user.CurrentRevision() + 1}
+		for field, value := range operation.Doc {
+			doc[field] = value
+		}
+		updated, err := setFields(stored.user, doc)
+		if err != nil {
+			result.Status = 400
+			result.Error = err.Error()
+			return
+		}
+		m.put(updated)
+		result.Status, result.Result = 200, "updated"
+	case BulkDelete:
+		if !exists {
+			result.Status, result.Result = 404, "not_found"
+			result.Error = "not_found"
+			return
+		}
+		if operation.Soft {
+			result.Status, result.Result = 200, "noop"
+			if stored.user.DeletedAt == nil {
+				deleted := cloneUser(stored.user)
+				deleted.DeletedAt, deleted.UpdatedAt = operation.User.DeletedAt, operation.User.DeletedAt
+				deleted.UpdatedBy = operation.User.UpdatedBy
+				deleted.Revision = stored.user.CurrentRevision() + 1
+				m.put(deleted)
+				result.Result = "deleted"
+			}
+			return
+		}
+		delete(m.users, operation.User.ID)
+		result.Status, result.Result = 200, "deleted"
+	default:
+		result.Error = fmt.Sprintf("unknown action %q", operation.Action)
+	}
+}
+
+func (m *MemoryStorage) FindHistory(ctx context.Context, userID string) ([]HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.findHistory(userID, maxHistoryEntries), nil
+}
+
+func (m *MemoryStorage) FindRevision(ctx context.Context, userID string, revision int64) (HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.history[userID][revision]
+	if !ok {
+		return HistoryEntry{}, model.ErrNotFound
+	}
+	entry.User = cloneUser(entry.User)
+	return entry, nil
+}
+
+// find returns a copy of the stored user with its version. The caller holds
+// the lock.
+func (m *MemoryStorage) find(id string, includeDeleted bool) (UserInfo, error) {
+	stored, ok := m.users[id]
+	if !ok || (stored.user.DeletedAt != nil && !includeDeleted) {
+		return UserInfo{}, model.ErrNotFound
+	}
+
+	userInfo := cloneUser(stored.user)
+	userInfo.Version = &Version{SeqNo: stored.seqNo, PrimaryTerm: memoryPrimaryTerm}
+	return userInfo, nil
+}
+
+// findPrevious is the in-memory counterpart of UserInfoStorage.findPrevious.
+// The caller holds the write lock.
+func (m *MemoryStorage) findPrevious(id string, version *Version, includeDeleted bool) (UserInfo, error) {
+	previous, err := m.find(id, includeDeleted)
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	if version != nil && *version != *previous.Version {
+		return UserInfo{}, model.ErrConflict
+	}
+
+	return previous, nil
+}
+
+// saveHistory mirrors ElasticSearch.saveHistory: an entry that exists already
+// is kept.
+func (m *MemoryStorage) saveHistory(ctx context.Context, previous UserInfo, action string) error {
+	now := time.Now().UTC()
+	previous.Revision = previous.CurrentRevision()
+	previous.Version = nil
+
+	if m.history[previous.ID] == nil {
+		m.history[previous.ID] = map[int64]HistoryEntry{}
+	}
+	if _, ok := m.history[previous.ID][previous.Revision]; ok {
+		return nil
+	}
+	m.history[previous.ID][previous.Revision] = HistoryEntry{
+		UserID:    previous.ID,
+		Revision:  previous.Revision,
+		Action:    action,
+		Actor:     model.ActorFromContext(ctx),
+		Timestamp: &now,
+		User:      cloneUser(previous),
+	}
+	return nil
+}
+
+// findHistory returns up to size entries, newest revision first.
+func (m *MemoryStorage) findHistory(userID string, size int) []HistoryEntry {
+	var entries []HistoryEntry
+	for _, entry := range m.history[userID] {
+		entry.User = cloneUser(entry.User)
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Revision > entries[j].Revision
+	})
+	if len(entries) > size {
+		entries = entries[:size]
+	}
+	return entries
+}
+
+// put stores a copy of the user under a new sequence number.
+func (m *MemoryStorage) put(userInfo UserInfo) {
+	m.seqNo++
+	userInfo = cloneUser(userInfo)
+	userInfo.Version = nil
+	m.users[userInfo.ID] = memoryUser{user: userInfo, seqNo: m.seqNo}
+}
+
+func (m *MemoryStorage) sortedIDs() []string {
+	ids := make([]string, 0, len(m.users))
+	for id := range m.users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// cloneUser copies the slice and pointers of a user, so stored users are
+// never shared with callers.
+func cloneUser(userInfo UserInfo) UserInfo {
+	if userInfo.ChildNames != nil {
+		userInfo.ChildNames = append([]string{}, userInfo.ChildNames...)
+	}
+	for _, t := range []**time.Time{&userInfo.CreatedAt, &userInfo.UpdatedAt, &userInfo.DeletedAt} {
+		if *t != nil {
+			copied := **t
+			*t = &copied
+		}
+	}
+	if userInfo.Version != nil {
+		version := *userInfo.Version
+		userInfo.Version = &version
+	}
+	return userInfo
+}
+
+// mergeUser applies update to current the way a partial document update
+// does, field by field on the JSON source.
+func mergeUser(current UserInfo, update UserInfo) (UserInfo, error) {
+	fields := map[string]interface{}{}
+	bdy, err := json.Marshal(update)
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("marshall: %w", err)
+	}
+	if err := json.Unmarshal(bdy, &fields); err != nil {
+		return UserInfo{}, fmt.Errorf("unmarshall: %w", err)
+	}
+	return setFields(current, fields)
+}
+
+// setFields overwrites fields of the JSON source of the user. Unknown fields
+// are rejected, like the strict mapping of the user index does.
+func setFields(current UserInfo, fields map[string]interface{}) (UserInfo, error) {
+	source := map[string]interface{}{}
+	bdy, err := json.Marshal(current)
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("marshall: %w", err)
+	}
+	if err := json.Unmarshal(bdy, &source); err != nil {
+		return UserInfo{}, fmt.Errorf("unmarshall: %w", err)
+	}
+	for field, value := range fields {
+		source[field] = value
+	}
+
+	bdy, err = json.Marshal(source)
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("marshall: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bdy))
+	decoder.DisallowUnknownFields()
+
+	var updated UserInfo
+	if err := decoder.Decode(&updated); err != nil {
+		return UserInfo{}, fmt.Errorf("strict_dynamic_mapping_exception: %w", err)
+	}
+	return updated, nil
+}
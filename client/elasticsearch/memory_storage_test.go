@@ -0,0 +1,261 @@
+package elasticsearch
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch/estest"
+	"elastic-project/model"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// storedUser is the user of the get_found fixture, optionally soft deleted.
+func storedUser(deleted bool) UserInfo {
+	created := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	updated := time.Date(2023, 1, 5, 12, 30, 0, 0, time.UTC)
+	user := UserInfo{
+		ID:         "1",
+		Name:       "Mehmet Yılmaz",
+		Job:        "Software Engineer",
+		ChildNames: []string{"Ali", "Ayşe"},
+		CreatedAt:  &created,
+		UpdatedAt:  &updated,
+		CreatedBy:  "admin",
+		UpdatedBy:  "admin",
+		Revision:   2,
+	}
+	if deleted {
+		user.DeletedAt = &updated
+	}
+	return user
+}
+
+// TestUserInfoStorers runs the same writes against MemoryStorage and against
+// UserInfoStorage on a fake cluster, which both hold storedUser.
+func TestUserInfoStorers(t *testing.T) {
+	update := func(es *estest.Server) {
+		es.On(http.MethodPost, userPath+"/_update", estest.Updated("user_v1", "1"))
+	}
+	stale := &Version{SeqNo: 99, PrimaryTerm: 1}
+
+	tests := []struct {
+		name    string
+		deleted bool
+		script  func(es *estest.Server)
+		run     func(ctx context.Context, storage UserInfoStorer) error
+		wantErr error
+	}{
+		{
+			name:   "update",
+			script: update,
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Update(ctx, UserInfo{ID: "1", Name: "Mehmet Yılmaz", Job: "Architect"})
+			},
+		},
+		{
+			name: "update with a stale version",
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Update(ctx, UserInfo{ID: "1", Name: "Mehmet Yılmaz", Job: "Architect", Version: stale})
+			},
+			wantErr: model.ErrConflict,
+		},
+		{
+			name: "update a missing user",
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Update(ctx, UserInfo{ID: "2", Name: "Ahmet Kaya", Job: "Doctor"})
+			},
+			wantErr: model.ErrNotFound,
+		},
+		{
+			name: "insert an existing user",
+			script: func(es *estest.Server) {
+				es.On(http.MethodPut, userPath+"/_create", estest.Conflict("user_v1", "1"))
+			},
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Insert(ctx, UserInfo{ID: "1", Name: "Ahmet Kaya", Job: "Doctor"})
+			},
+			wantErr: model.ErrConflict,
+		},
+		{
+			name: "delete",
+			script: func(es *estest.Server) {
+				es.On(http.MethodDelete, userPath, estest.Deleted("user_v1", "1"))
+			},
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Delete(ctx, "1", nil)
+			},
+		},
+		{
+			name: "delete with a stale version",
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Delete(ctx, "1", stale)
+			},
+			wantErr: model.ErrConflict,
+		},
+		{
+			name: "delete a missing user",
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Delete(ctx, "2", nil)
+			},
+			wantErr: model.ErrNotFound,
+		},
+		{
+			name:   "soft delete",
+			script: update,
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.SoftDelete(ctx, "1", nil)
+			},
+		},
+		{
+			name:    "soft delete a soft deleted user",
+			deleted: true,
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.SoftDelete(ctx, "1", nil)
+			},
+			wantErr: model.ErrNotFound,
+		},
+		{
+			name:    "find a soft deleted user",
+			deleted: true,
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				_, err := storage.FindOne(ctx, "1", false)
+				return err
+			},
+			wantErr: model.ErrNotFound,
+		},
+		{
+			name:    "find a soft deleted user including deleted ones",
+			deleted: true,
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				_, err := storage.FindOne(ctx, "1", true)
+				return err
+			},
+		},
+		{
+			name:    "update a soft deleted user",
+			deleted: true,
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Update(ctx, UserInfo{ID: "1", Name: "Mehmet Yılmaz", Job: "Architect"})
+			},
+			wantErr: model.ErrNotFound,
+		},
+		{
+			name:    "add a child to a soft deleted user",
+			deleted: true,
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				_, err := storage.AddChild(ctx, "1", "Zeynep")
+				return err
+			},
+			wantErr: model.ErrNotFound,
+		},
+		{
+			name:    "undelete",
+			deleted: true,
+			script:  update,
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Undelete(ctx, "1")
+			},
+		},
+		{
+			name: "undelete a user that is not deleted",
+			run: func(ctx context.Context, storage UserInfoStorer) error {
+				return storage.Undelete(ctx, "1")
+			},
+			wantErr: model.ErrConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Run("memory", func(t *testing.T) {
+				storage := NewMemoryStorage()
+				if err := storage.Insert(context.Background(), storedUser(tt.deleted)); err != nil {
+					t.Fatalf("Insert() error = %v", err)
+				}
+
+				assertError(t, tt.run(context.Background(), storage), tt.wantErr, "")
+			})
+
+			t.Run("elasticsearch", func(t *testing.T) {
+				es, storage := newTestStorage(t)
+				es.On(http.MethodGet, "/user_alias/_doc/*", estest.NotFound("user_v1", "2"))
+				es.On(http.MethodGet, userPath, estest.Found("user_v1", "1", 3, 1, storedUser(tt.deleted)))
+				es.On(http.MethodPut, "/user_history/_doc/*", estest.Created("user_history", "1_2"))
+				if tt.script != nil {
+					tt.script(es)
+				}
+
+				assertError(t, tt.run(context.Background(), storage), tt.wantErr, "")
+				for _, req := range es.Unmatched() {
+					t.Errorf("unscripted request %s %s", req.Method, req.Path)
+				}
+			})
+		})
+	}
+}
+
+func TestMemoryStorageSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	if err := storage.Insert(ctx, storedUser(false)); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	everyone := map[string]interface{}{"match_all": map[string]interface{}{}}
+	found := func(includeDeleted bool) int64 {
+		t.Helper()
+		result, err := storage.FindByQueryClause(everyone, SearchPage{Size: 10, IncludeDeleted: includeDeleted}, nil)
+		if err != nil {
+			t.Fatalf("FindByQueryClause() error = %v", err)
+		}
+		return result.Total
+	}
+
+	if err := storage.SoftDelete(ctx, "1", nil); err != nil {
+		t.Fatalf("SoftDelete() error = %v", err)
+	}
+	deleted, err := storage.FindOne(ctx, "1", true)
+	if err != nil {
+		t.Fatalf("FindOne() error = %v", err)
+	}
+	if deleted.DeletedAt == nil || deleted.Revision != 3 {
+		t.Errorf("deleted user = %+v, want deleted_at set and revision 3", deleted)
+	}
+	if got := found(false); got != 0 {
+		t.Errorf("search found %d users, want the deleted one left out", got)
+	}
+	if got := found(true); got != 1 {
+		t.Errorf("search including deleted users found %d users, want 1", got)
+	}
+	entry, err := storage.FindRevision(ctx, "1", 2)
+	if err != nil {
+		t.Fatalf("FindRevision() error = %v", err)
+	}
+	if entry.Action != HistoryDelete || entry.User.DeletedAt != nil {
+		t.Errorf("history entry = %+v, want the user before the delete", entry)
+	}
+
+	if err := storage.Undelete(ctx, "1"); err != nil {
+		t.Fatalf("Undelete() error = %v", err)
+	}
+	restored, err := storage.FindOne(ctx, "1", false)
+	if err != nil {
+		t.Fatalf("FindOne() error = %v", err)
+	}
+	if restored.DeletedAt != nil || restored.Revision != 4 {
+		t.Errorf("restored user = %+v, want no deleted_at and revision 4", restored)
+	}
+
+	if err := storage.SoftDelete(ctx, "1", nil); err != nil {
+		t.Fatalf("SoftDelete() error = %v", err)
+	}
+	purged, err := storage.PurgeDeleted(ctx, time.Now().Add(time.Minute))
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeleted() = %d, %v, want 1 user", purged, err)
+	}
+	if _, err := storage.FindOne(ctx, "1", true); err != model.ErrNotFound {
+		t.Errorf("FindOne() after purge error = %v, want %v", err, model.ErrNotFound)
+	}
+	if history, _ := storage.FindHistory(ctx, "1"); len(history) != 3 {
+		t.Errorf("history has %d entries after purge, want 3", len(history))
+	}
+}
@@ -0,0 +1,106 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+)
+
+// auditBackfillScript fills the audit fields of documents written before
+// they existed: created_by becomes the system actor, updated_by the creator
+// and updated_at the creation time.
+const auditBackfillScript = `
+if (ctx._source.created_by == null) { ctx._source.created_by = params.actor }
+if (ctx._source.updated_by == null) { ctx._source.updated_by = ctx._source.created_by }
+if (ctx._source.updated_at == null && ctx._source.created_at != null) { ctx._source.updated_at = ctx._source.created_at }
+`
+
+// MigrateAuditFields adds updated_at, created_by and updated_by to the
+// mapping of the index behind the alias. Documents written before they
+// existed are filled in by StartAuditBackfill.
+func (e *ElasticSearch) MigrateAuditFields(ctx context.Context) error {
+	if err := e.putFields(ctx, "updated_at", "created_by", "updated_by"); err != nil {
+		return fmt.Errorf("migrate audit fields: %w", err)
+	}
+	return nil
+}
+
+// StartAuditBackfill starts an update by query that fills the audit fields
+// of documents that miss them and returns its task ID. Documents that already
+// have them are left untouched, so it is safe to run again.
+func (e *ElasticSearch) StartAuditBackfill(ctx context.Context) (string, error) {
+	bdy, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					map[string]interface{}{"bool": map[string]interface{}{"must_not": map[string]interface{}{"exists": map[string]interface{}{"field": "created_by"}}}},
+					map[string]interface{}{"bool": map[string]interface{}{"must_not": map[string]interface{}{"exists": map[string]interface{}{"field": "updated_by"}}}},
+				},
+				"minimum_should_match": 1,
+			},
+		},
+		"script": map[string]interface{}{
+			"source": auditBackfillScript,
+			"lang":   "painless",
+			"params": map[string]interface{}{"actor": model.SystemActor},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("audit backfill: marshall: %w", err)
+	}
+
+	res, err := e.client.UpdateByQuery([]string{e.alias},
+		e.client.UpdateByQuery.WithContext(ctx),
+		e.client.UpdateByQuery.WithBody(bytes.NewReader(bdy)),
+		e.client.UpdateByQuery.WithConflicts("proceed"),
+		e.client.UpdateByQuery.WithWaitForCompletion(false),
+		e.client.UpdateByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return "", fmt.Errorf("audit backfill: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	return decodeTaskID("audit backfill", res)
+}
+
+// MigrateAddedFields adds revision and deleted_at to the mapping of the
+// index behind the alias. Neither needs a backfill: a document without
+// revision is at revision 1 and one without deleted_at is not deleted.
+func (e *ElasticSearch) MigrateAddedFields(ctx context.Context) error {
+	if err := e.putFields(ctx, "revision", "deleted_at"); err != nil {
+		return fmt.Errorf("migrate added fields: %w", err)
+	}
+	return nil
+}
+
+// putFields adds the named fields of userIndexDefinition to the mapping of
+// the index behind the alias. The mapping version in _meta is left alone: it
+// only changes with a reindex, so CheckMapping still reports the drift.
+func (e *ElasticSearch) putFields(ctx context.Context, names ...string) error {
+	definition := userIndexDefinition(e.analysis).Mappings.Properties
+	properties := make(map[string]fieldMapping, len(names))
+	for _, name := range names {
+		properties[name] = definition[name]
+	}
+
+	mapping, err := json.Marshal(map[string]interface{}{
+		"properties": properties,
+	})
+	if err != nil {
+		return fmt.Errorf("put mapping: marshall: %w", err)
+	}
+
+	res, err := e.client.Indices.PutMapping(bytes.NewReader(mapping), e.client.Indices.PutMapping.WithIndex(e.alias), e.client.Indices.PutMapping.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("put mapping: request: %w", err)
+	}
+	res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("put mapping: response: %s", res.String())
+	}
+
+	return nil
+}
@@ -18,10 +18,67 @@ type UserInfoStorage struct {
 type UserInfoStorer interface {
 	Insert(ctx context.Context, userInfo UserInfo) error
 	Update(ctx context.Context, userInfo UserInfo) error
-	Delete(ctx context.Context, id string) error
-	FindOne(ctx context.Context, id string) (UserInfo, error)
-	FindByKeyAndValue(queryType string, key string, value string) ([]UserInfo, error)
-	FindByQuery(jsonString string) ([]UserInfo, error)
+	Delete(ctx context.Context, id string, version *Version) error
+	Replace(ctx context.Context, userInfo UserInfo) error
+	AddChild(ctx context.Context, id string, name string) (bool, error)
+	RemoveChild(ctx context.Context, id string, name string) (bool, error)
+	SoftDelete(ctx context.Context, id string, version *Version) error
+	Undelete(ctx context.Context, id string) error
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	UpdateByQuery(ctx context.Context, query map[string]interface{}, set map[string]interface{}, requestsPerSecond int) (ByQueryResult, error)
+	DeleteByQuery(ctx context.Context, query map[string]interface{}, requestsPerSecond int) (ByQueryResult, error)
+	StartUpdateByQuery(ctx context.Context, query map[string]interface{}, set map[string]interface{}, requestsPerSecond int) (string, error)
+	StartDeleteByQuery(ctx context.Context, query map[string]interface{}, requestsPerSecond int) (string, error)
+	FindOne(ctx context.Context, id string, includeDeleted bool) (UserInfo, error)
+	FindByConditions(conditions Conditions, page SearchPage, highlight *Highlight) (SearchResult, error)
+	FindByQuery(body map[string]interface{}, page SearchPage, highlight *Highlight) (SearchResult, error)
+	FindByQueryClause(query map[string]interface{}, page SearchPage, highlight *Highlight) (SearchResult, error)
+	Bulk(ctx context.Context, next func() (BulkOperation, error)) ([]BulkItemResult, error)
+	Export(ctx context.Context, query map[string]interface{}, includeDeleted bool, fn func(UserInfo) error) error
+	Facets(ctx context.Context, query map[string]interface{}, options FacetOptions) (FacetResult, error)
+	Suggest(ctx context.Context, field string, prefix string, job string, size int) ([]Suggestion, error)
+	ValidateQuery(query map[string]interface{}) (QueryValidation, error)
+}
+
+// SearchPage selects the page of a search. Soft deleted users are left out
+// unless IncludeDeleted is set.
+type SearchPage struct {
+	From           int
+	Size           int
+	SearchAfter    []interface{}
+	IncludeDeleted bool
+}
+
+// Highlight asks for highlighted fragments of the user text fields.
+type Highlight struct {
+	PreTag       string
+	PostTag      string
+	FragmentSize int
+}
+
+// Condition is a single queryType/key/value leaf query, e.g. fuzzy on name.
+type Condition struct {
+	QueryType string
+	Key       string
+	Value     string
+}
+
+// Conditions are combined into a bool query. MinimumShouldMatch is passed
+// through as is and may be a count or a percentage.
+type Conditions struct {
+	Must               []Condition
+	Should             []Condition
+	MustNot            []Condition
+	Filter             []Condition
+	MinimumShouldMatch string
+}
+
+type SearchResult struct {
+	PitID    string
+	Total    int64
+	TimedOut bool
+	Shards   ShardsInfo
+	Hits     []SearchHit
 }
 
 type UserInfo struct {
@@ -31,6 +88,29 @@ type UserInfo struct {
 	ChildNames []string   `json:"childNames"`
 	Comment    string     `json:"comment"`
 	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	UpdatedBy  string     `json:"updated_by,omitempty"`
+	Revision   int64      `json:"revision,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	Version    *Version   `json:"-"`
+}
+
+// CurrentRevision returns the revision of the user. Users written before
+// revisions were counted are at revision 1.
+func (u UserInfo) CurrentRevision() int64 {
+	if u.Revision < 1 {
+		return 1
+	}
+	return u.Revision
+}
+
+// Version is the sequence number and primary term of the last change to a
+// document. Passing it back to Update or Delete makes them fail with
+// model.ErrConflict if the document has changed since it was read.
+type Version struct {
+	SeqNo       int
+	PrimaryTerm int
 }
 
 func NewUserInfoStorage(elastic ElasticSearch) UserInfoStorer {
@@ -61,6 +141,10 @@ func (p UserInfoStorage) Insert(ctx context.Context, userInfo UserInfo) error {
 	}
 	defer res.Body.Close()
 
+	if writeBlocked(res) {
+		return model.ErrWriteBlocked
+	}
+
 	if res.StatusCode == 409 {
 		return model.ErrConflict
 	}
@@ -72,7 +156,18 @@ func (p UserInfoStorage) Insert(ctx context.Context, userInfo UserInfo) error {
 	return nil
 }
 
+// Update stores the revision it replaces in the history and then applies the
+// partial update. The update is conditional on the revision read before, so a
+// concurrent change fails with model.ErrConflict instead of being missing
+// from the history; a failed update leaves at most an extra entry.
 func (p UserInfoStorage) Update(ctx context.Context, userInfo UserInfo) error {
+	previous, err := p.findPrevious(ctx, userInfo.ID, userInfo.Version, false)
+	if err != nil {
+		return err
+	}
+	userInfo.Revision = previous.CurrentRevision() + 1
+	userInfo.Version = previous.Version
+
 	bdy, err := json.Marshal(userInfo)
 	if err != nil {
 		return fmt.Errorf("update: marshall: %w", err)
@@ -83,20 +178,36 @@ func (p UserInfoStorage) Update(ctx context.Context, userInfo UserInfo) error {
 		DocumentID: userInfo.ID,
 		Body:       bytes.NewReader([]byte(fmt.Sprintf(`{"doc":%s}`, bdy))),
 	}
+	if userInfo.Version != nil {
+		req.IfSeqNo = &userInfo.Version.SeqNo
+		req.IfPrimaryTerm = &userInfo.Version.PrimaryTerm
+	}
 
 	ctx, cancel := context.WithTimeout(ctx, p.timeout)
 	defer cancel()
 
+	if err := p.elastic.saveHistory(ctx, previous, HistoryUpdate); err != nil {
+		return err
+	}
+
 	res, err := req.Do(ctx, p.elastic.client)
 	if err != nil {
 		return fmt.Errorf("update: request: %w", err)
 	}
 	defer res.Body.Close()
 
+	if writeBlocked(res) {
+		return model.ErrWriteBlocked
+	}
+
 	if res.StatusCode == 404 {
 		return model.ErrNotFound
 	}
 
+	if res.StatusCode == 409 {
+		return model.ErrConflict
+	}
+
 	if res.IsError() {
 		return fmt.Errorf("update: response: %s", res.String())
 	}
@@ -104,25 +215,46 @@ func (p UserInfoStorage) Update(ctx context.Context, userInfo UserInfo) error {
 	return nil
 }
 
-func (p UserInfoStorage) Delete(ctx context.Context, id string) error {
+// Delete stores the current revision of the user in the history and then
+// deletes the user, conditional on that revision like Update.
+func (p UserInfoStorage) Delete(ctx context.Context, id string, version *Version) error {
+	previous, err := p.findPrevious(ctx, id, version, false)
+	if err != nil {
+		return err
+	}
+
 	req := esapi.DeleteRequest{
-		Index:      p.elastic.alias,
-		DocumentID: id,
+		Index:         p.elastic.alias,
+		DocumentID:    id,
+		IfSeqNo:       &previous.Version.SeqNo,
+		IfPrimaryTerm: &previous.Version.PrimaryTerm,
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, p.timeout)
 	defer cancel()
 
+	if err := p.elastic.saveHistory(ctx, previous, HistoryDelete); err != nil {
+		return err
+	}
+
 	res, err := req.Do(ctx, p.elastic.client)
 	if err != nil {
 		return fmt.Errorf("delete: request: %w", err)
 	}
 	defer res.Body.Close()
 
+	if writeBlocked(res) {
+		return model.ErrWriteBlocked
+	}
+
 	if res.StatusCode == 404 {
 		return model.ErrNotFound
 	}
 
+	if res.StatusCode == 409 {
+		return model.ErrConflict
+	}
+
 	if res.IsError() {
 		return fmt.Errorf("delete: response: %s", res.String())
 	}
@@ -130,7 +262,92 @@ func (p UserInfoStorage) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-func (p UserInfoStorage) FindOne(ctx context.Context, id string) (UserInfo, error) {
+// Replace writes the whole user, e.g. a revision restored from the history.
+// The revision it replaces, if the user exists, is stored in the history
+// first; a deleted user is recreated with the revision following its last
+// one.
+func (p UserInfoStorage) Replace(ctx context.Context, userInfo UserInfo) error {
+	req := esapi.IndexRequest{
+		Index:      p.elastic.alias,
+		DocumentID: userInfo.ID,
+	}
+
+	previous, err := p.findPrevious(ctx, userInfo.ID, nil, true)
+	exists := err == nil
+	switch {
+	case err == nil:
+		userInfo.Revision = previous.CurrentRevision() + 1
+		req.IfSeqNo = &previous.Version.SeqNo
+		req.IfPrimaryTerm = &previous.Version.PrimaryTerm
+	case model.ErrNotFound == err:
+		entries, err := p.elastic.findHistory(ctx, userInfo.ID, 1)
+		if err != nil {
+			return fmt.Errorf("replace: %w", err)
+		}
+		userInfo.Revision = 1
+		if len(entries) > 0 {
+			userInfo.Revision = entries[0].Revision + 1
+		}
+		req.OpType = "create"
+	default:
+		return err
+	}
+
+	bdy, err := json.Marshal(userInfo)
+	if err != nil {
+		return fmt.Errorf("replace: marshall: %w", err)
+	}
+	req.Body = bytes.NewReader(bdy)
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	if exists {
+		if err := p.elastic.saveHistory(ctx, previous, HistoryRestore); err != nil {
+			return err
+		}
+	}
+
+	res, err := req.Do(ctx, p.elastic.client)
+	if err != nil {
+		return fmt.Errorf("replace: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if writeBlocked(res) {
+		return model.ErrWriteBlocked
+	}
+
+	if res.StatusCode == 409 {
+		return model.ErrConflict
+	}
+
+	if res.IsError() {
+		return fmt.Errorf("replace: response: %s", res.String())
+	}
+
+	return nil
+}
+
+// findPrevious reads the user about to be changed and checks the expected
+// version, if any. The caller stores it in the history before the change,
+// conditional on its version.
+func (p UserInfoStorage) findPrevious(ctx context.Context, id string, version *Version, includeDeleted bool) (UserInfo, error) {
+	previous, err := p.FindOne(ctx, id, includeDeleted)
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	if version != nil && *version != *previous.Version {
+		return UserInfo{}, model.ErrConflict
+	}
+
+	return previous, nil
+}
+
+// FindOne returns model.ErrNotFound for a soft deleted user unless
+// includeDeleted is set.
+func (p UserInfoStorage) FindOne(ctx context.Context, id string, includeDeleted bool) (UserInfo, error) {
 	req := esapi.GetRequest{
 		Index:      p.elastic.alias,
 		DocumentID: id,
@@ -162,91 +379,135 @@ func (p UserInfoStorage) FindOne(ctx context.Context, id string) (UserInfo, erro
 	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
 		return UserInfo{}, fmt.Errorf("find one: decode: %w", err)
 	}
+	userInfo.Version = &Version{SeqNo: body.SeqNo, PrimaryTerm: body.PrimaryTerm}
+
+	if userInfo.DeletedAt != nil && !includeDeleted {
+		return UserInfo{}, model.ErrNotFound
+	}
 
 	return userInfo, nil
 }
 
-func (p UserInfoStorage) FindByKeyAndValue(queryType string, key string, value string) ([]UserInfo, error) {
-	return p.Search(queryType, key, value)
+func (p UserInfoStorage) FindByConditions(conditions Conditions, page SearchPage, highlight *Highlight) (SearchResult, error) {
+	return p.Search(conditions, page, highlight)
 }
 
-func (p UserInfoStorage) Search(queryType string, key string, value string) ([]UserInfo, error) {
-	var userInfoList []UserInfo
-	var buffer bytes.Buffer
+func (p UserInfoStorage) Search(conditions Conditions, page SearchPage, highlight *Highlight) (SearchResult, error) {
 	query := map[string]interface{}{
-		"query": map[string]interface{}{
-			queryType: map[string]interface{}{
-				key: value,
-			},
+		"query": conditionsQuery(conditions),
+	}
+	return p.search(query, page, highlight)
+}
+
+// Query returns the query clause the conditions are searched with.
+func (c Conditions) Query() map[string]interface{} {
+	return conditionsQuery(c)
+}
+
+// conditionsQuery builds the bool query of the conditions. A lone must
+// condition is sent as the leaf query itself.
+func conditionsQuery(conditions Conditions) map[string]interface{} {
+	if len(conditions.Must) == 1 && len(conditions.Should) == 0 && len(conditions.MustNot) == 0 && len(conditions.Filter) == 0 {
+		must := conditions.Must[0]
+		return keyValueQuery(must.QueryType, must.Key, must.Value)
+	}
+
+	boolQuery := map[string]interface{}{}
+	for occur, list := range map[string][]Condition{
+		"must":     conditions.Must,
+		"should":   conditions.Should,
+		"must_not": conditions.MustNot,
+		"filter":   conditions.Filter,
+	} {
+		if len(list) == 0 {
+			continue
+		}
+		clauses := make([]interface{}, 0, len(list))
+		for _, condition := range list {
+			clauses = append(clauses, keyValueQuery(condition.QueryType, condition.Key, condition.Value))
+		}
+		boolQuery[occur] = clauses
+	}
+	if conditions.MinimumShouldMatch != "" {
+		boolQuery["minimum_should_match"] = conditions.MinimumShouldMatch
+	}
+
+	return map[string]interface{}{"bool": boolQuery}
+}
+
+func keyValueQuery(queryType string, key string, value string) map[string]interface{} {
+	return map[string]interface{}{
+		queryType: map[string]interface{}{
+			key: value,
 		},
 	}
-	err := json.NewEncoder(&buffer).Encode(query)
-	if err != nil {
-		return []UserInfo{}, err
+}
+
+func (p UserInfoStorage) FindByQuery(body map[string]interface{}, page SearchPage, highlight *Highlight) (SearchResult, error) {
+	return p.search(body, page, highlight)
+}
+
+func (p UserInfoStorage) FindByQueryClause(query map[string]interface{}, page SearchPage, highlight *Highlight) (SearchResult, error) {
+	return p.search(map[string]interface{}{"query": query}, page, highlight)
+}
+
+// search applies the page and highlight to the query body and runs it against
+// the alias. Hits are sorted by score and then by id so search_after cursors
+// are stable.
+func (p UserInfoStorage) search(query map[string]interface{}, page SearchPage, highlight *Highlight) (SearchResult, error) {
+	query["size"] = page.Size
+	query["track_total_hits"] = true
+	if !page.IncludeDeleted {
+		query["query"] = excludeDeleted(query["query"])
 	}
-	es := p.elastic.client
-	response, err := es.Search(es.Search.WithIndex("user_alias"), es.Search.WithBody(&buffer))
-	if err != nil {
-		return []UserInfo{}, err
+	if _, ok := query["sort"]; !ok {
+		query["sort"] = []interface{}{
+			map[string]interface{}{"_score": "desc"},
+			map[string]interface{}{"id": "asc"},
+		}
 	}
-	var result map[string]interface{}
-	err = json.NewDecoder(response.Body).Decode(&result)
-	if err != nil {
-		return []UserInfo{}, err
-	}
-	for _, hit := range result["hits"].(map[string]interface{})["hits"].([]interface{}) {
-		craft := hit.(map[string]interface{})["_source"].(map[string]interface{})
-		createdAt, _ := time.Parse(time.RFC3339Nano, craft["created_at"].(string))
-		userInfo := UserInfo{ID: craft["id"].(string),
-			Name:       craft["name"].(string),
-			Job:        craft["job"].(string),
-			ChildNames: convertToStringArray(craft["childNames"].([]interface{})),
-			Comment:    craft["comment"].(string),
-			CreatedAt:  &createdAt,
+	if len(page.SearchAfter) > 0 {
+		query["search_after"] = page.SearchAfter
+	} else if page.From > 0 {
+		query["from"] = page.From
+	}
+	if highlight != nil {
+		query["highlight"] = map[string]interface{}{
+			"pre_tags":      []string{highlight.PreTag},
+			"post_tags":     []string{highlight.PostTag},
+			"fragment_size": highlight.FragmentSize,
+			"fields": map[string]interface{}{
+				"name":       map[string]interface{}{},
+				"job":        map[string]interface{}{},
+				"comment":    map[string]interface{}{},
+				"childNames": map[string]interface{}{},
+			},
 		}
-		userInfoList = append(userInfoList, userInfo)
 	}
-	return userInfoList, nil
-}
 
-func (p UserInfoStorage) FindByQuery(jsonString string) ([]UserInfo, error) {
-	var userInfoList []UserInfo
 	var buffer bytes.Buffer
-	err := json.Indent(&buffer, []byte(jsonString), "", "  ")
+	err := json.NewEncoder(&buffer).Encode(query)
 	if err != nil {
-		return []UserInfo{}, err
+		return SearchResult{}, err
 	}
 	es := p.elastic.client
-	response, err := es.Search(es.Search.WithIndex("user_alias"), es.Search.WithBody(&buffer))
+	response, err := es.Search(es.Search.WithIndex(p.elastic.alias), es.Search.WithBody(&buffer))
 	if err != nil {
-		return []UserInfo{}, err
+		return SearchResult{}, err
 	}
-	var result map[string]interface{}
-	err = json.NewDecoder(response.Body).Decode(&result)
-	if err != nil {
-		return []UserInfo{}, err
-	}
-	for _, hit := range result["hits"].(map[string]interface{})["hits"].([]interface{}) {
-		craft := hit.(map[string]interface{})["_source"].(map[string]interface{})
-		createdAt, _ := time.Parse(time.RFC3339Nano, craft["created_at"].(string))
-		userInfo := UserInfo{ID: craft["id"].(string),
-			Name:       craft["name"].(string),
-			Job:        craft["job"].(string),
-			ChildNames: convertToStringArray(craft["childNames"].([]interface{})),
-			Comment:    craft["comment"].(string),
-			CreatedAt:  &createdAt,
-		}
-		userInfoList = append(userInfoList, userInfo)
+	defer response.Body.Close()
+
+	if response.StatusCode == 400 {
+		return SearchResult{}, fmt.Errorf("search: %w: %s", model.ErrInvalidQuery, response.String())
 	}
-	return userInfoList, nil
-}
 
-func convertToStringArray(data []interface{}) []string {
-	result := make([]string, len(data))
-	for i, v := range data {
-		if s, ok := v.(string); ok {
-			result[i] = s
-		}
+	if response.IsError() {
+		return SearchResult{}, fmt.Errorf("search: response: %s", response.String())
+	}
+
+	searchResult, err := decodeSearchResponse(response.Body)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("search: decode: %w", err)
 	}
-	return result
+	return searchResult, nil
 }
@@ -0,0 +1,531 @@
+package elasticsearch
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch/estest"
+	"elastic-project/model"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	userPath    = "/user_alias/_doc/1"
+	historyPath = "/user_history/_doc/1_2"
+)
+
+// newTestStorage points a storage at a fake cluster whose user alias exists
+// already. The calls CreateIndex made are forgotten.
+func newTestStorage(t *testing.T) (*estest.Server, UserInfoStorer) {
+	t.Helper()
+
+	es := estest.NewServer()
+	t.Cleanup(es.Close)
+
+	elastic, err := New(es.Addresses(), AnalysisConfig{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	es.On(http.MethodHead, "/_alias/user_alias", estest.Exists())
+	if err := elastic.CreateIndex("user"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+	es.Reset()
+
+	return es, NewUserInfoStorage(*elastic)
+}
+
+func TestUserInfoStorageInsert(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  estest.Response
+		wantErr error
+		wantMsg string
+	}{
+		{name: "created", script: estest.Created("user_v1", "1")},
+		{name: "exists", script: estest.Conflict("user_v1", "1"), wantErr: model.ErrConflict},
+		{name: "rejected", script: estest.TooManyRequests(), wantMsg: "insert: response: [429 Too Many Requests]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es, storage := newTestStorage(t)
+			es.On(http.MethodPut, userPath+"/_create", tt.script)
+
+			err := storage.Insert(context.Background(), UserInfo{
+				ID:         "1",
+				Name:       "Ahmet Kaya",
+				Job:        "Doctor",
+				ChildNames: []string{"Ali"},
+			})
+			assertError(t, err, tt.wantErr, tt.wantMsg)
+
+			requests := assertRequests(t, es, "PUT "+userPath+"/_create")
+			assertBody(t, requests[0], `{"id":"1","name":"Ahmet Kaya","job":"Doctor","childNames":["Ali"],"comment":""}`)
+		})
+	}
+}
+
+func TestUserInfoStorageUpdate(t *testing.T) {
+	tests := []struct {
+		name         string
+		version      *Version
+		get          estest.Response
+		history      estest.Response
+		update       estest.Response
+		wantErr      error
+		wantMsg      string
+		wantRequests []string
+	}{
+		{
+			name:         "updated",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			update:       estest.Updated("user_v1", "1"),
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, "POST " + userPath + "/_update"},
+		},
+		{
+			name:         "missing",
+			get:          estest.Fixture(http.StatusNotFound, "get_not_found"),
+			wantErr:      model.ErrNotFound,
+			wantRequests: []string{"GET " + userPath},
+		},
+		{
+			name:         "stale version",
+			version:      &Version{SeqNo: 2, PrimaryTerm: 1},
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			wantErr:      model.ErrConflict,
+			wantRequests: []string{"GET " + userPath},
+		},
+		{
+			name:         "revision recorded by a failed update",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			history:      estest.Conflict("user_history", "1_2"),
+			update:       estest.Updated("user_v1", "1"),
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, "POST " + userPath + "/_update"},
+		},
+		{
+			name:         "history not recorded",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			history:      estest.TooManyRequests(),
+			wantMsg:      "save history: response: [429 Too Many Requests]",
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath},
+		},
+		{
+			name:         "changed concurrently",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			update:       estest.Fixture(http.StatusConflict, "version_conflict"),
+			wantErr:      model.ErrConflict,
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, "POST " + userPath + "/_update"},
+		},
+		{
+			name:         "deleted concurrently",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			update:       estest.NotFound("user_v1", "1"),
+			wantErr:      model.ErrNotFound,
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, "POST " + userPath + "/_update"},
+		},
+		{
+			name:         "rejected",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			update:       estest.TooManyRequests(),
+			wantMsg:      "update: response: [429 Too Many Requests]",
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, "POST " + userPath + "/_update"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es, storage := newTestStorage(t)
+			es.On(http.MethodGet, userPath, tt.get)
+			if tt.update.Body != nil {
+				es.On(http.MethodPost, userPath+"/_update", tt.update)
+			}
+			history := estest.Created("user_history", "1_2")
+			if tt.history.Body != nil {
+				history = tt.history
+			}
+			es.On(http.MethodPut, historyPath, history)
+
+			err := storage.Update(context.Background(), UserInfo{
+				ID:      "1",
+				Name:    "Mehmet Yılmaz",
+				Job:     "Architect",
+				Version: tt.version,
+			})
+			assertError(t, err, tt.wantErr, tt.wantMsg)
+
+			requests := assertRequests(t, es, tt.wantRequests...)
+			if len(requests) < 2 {
+				return
+			}
+			assertQuery(t, requests[1], "op_type", "create")
+			assertHistory(t, requests[1], HistoryUpdate)
+			if len(requests) < 3 {
+				return
+			}
+			assertQuery(t, requests[2], "if_seq_no", "3")
+			assertQuery(t, requests[2], "if_primary_term", "1")
+			assertBody(t, requests[2], `{"doc":{"id":"1","name":"Mehmet Yılmaz","job":"Architect","childNames":null,"comment":"","revision":3}}`)
+		})
+	}
+}
+
+func TestUserInfoStorageDelete(t *testing.T) {
+	tests := []struct {
+		name         string
+		get          estest.Response
+		remove       estest.Response
+		wantErr      error
+		wantMsg      string
+		wantRequests []string
+	}{
+		{
+			name:         "deleted",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			remove:       estest.Deleted("user_v1", "1"),
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, "DELETE " + userPath},
+		},
+		{
+			name:         "missing",
+			get:          estest.Fixture(http.StatusNotFound, "get_not_found"),
+			wantErr:      model.ErrNotFound,
+			wantRequests: []string{"GET " + userPath},
+		},
+		{
+			name:         "changed concurrently",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			remove:       estest.Conflict("user_v1", "1"),
+			wantErr:      model.ErrConflict,
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, "DELETE " + userPath},
+		},
+		{
+			name:         "rejected",
+			get:          estest.Fixture(http.StatusOK, "get_found"),
+			remove:       estest.TooManyRequests(),
+			wantMsg:      "delete: response: [429 Too Many Requests]",
+			wantRequests: []string{"GET " + userPath, "PUT " + historyPath, "DELETE " + userPath},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es, storage := newTestStorage(t)
+			es.On(http.MethodGet, userPath, tt.get)
+			if tt.remove.Body != nil {
+				es.On(http.MethodDelete, userPath, tt.remove)
+			}
+			es.On(http.MethodPut, historyPath, estest.Created("user_history", "1_2"))
+
+			err := storage.Delete(context.Background(), "1", nil)
+			assertError(t, err, tt.wantErr, tt.wantMsg)
+
+			requests := assertRequests(t, es, tt.wantRequests...)
+			if len(requests) < 2 {
+				return
+			}
+			assertHistory(t, requests[1], HistoryDelete)
+			assertQuery(t, requests[2], "if_seq_no", "3")
+			assertQuery(t, requests[2], "if_primary_term", "1")
+			if len(requests[2].Body) != 0 {
+				t.Errorf("delete body = %s, want none", requests[2].Body)
+			}
+		})
+	}
+}
+
+func TestUserInfoStorageSearch(t *testing.T) {
+	const defaultSort = `"sort":[{"_score":"desc"},{"id":"asc"}]`
+
+	tests := []struct {
+		name     string
+		search   func(storage UserInfoStorer) (SearchResult, error)
+		response estest.Response
+		wantBody string
+		wantIDs  []string
+		wantErr  error
+		wantMsg  string
+	}{
+		{
+			name: "conditions",
+			search: func(storage UserInfoStorer) (SearchResult, error) {
+				conditions := Conditions{Must: []Condition{{QueryType: "match", Key: "job", Value: "software"}}}
+				return storage.FindByConditions(conditions, SearchPage{Size: 10}, nil)
+			},
+			response: estest.Fixture(http.StatusOK, "search_hits"),
+			wantBody: `{"query":{"bool":{"must":[{"match":{"job":"software"}}],"must_not":[{"exists":{"field":"deleted_at"}}]}},` +
+				`"size":10,"track_total_hits":true,` + defaultSort + `}`,
+			wantIDs: []string{"1", "2"},
+		},
+		{
+			name: "conditions with deleted users",
+			search: func(storage UserInfoStorer) (SearchResult, error) {
+				conditions := Conditions{
+					Should:             []Condition{{QueryType: "match", Key: "job", Value: "software"}, {QueryType: "term", Key: "id", Value: "2"}},
+					MinimumShouldMatch: "1",
+				}
+				return storage.FindByConditions(conditions, SearchPage{From: 20, Size: 10, IncludeDeleted: true}, nil)
+			},
+			response: estest.Fixture(http.StatusOK, "search_hits"),
+			wantBody: `{"query":{"bool":{"should":[{"match":{"job":"software"}},{"term":{"id":"2"}}],"minimum_should_match":"1"}},` +
+				`"from":20,"size":10,"track_total_hits":true,` + defaultSort + `}`,
+			wantIDs: []string{"1", "2"},
+		},
+		{
+			name: "query",
+			search: func(storage UserInfoStorer) (SearchResult, error) {
+				body := map[string]interface{}{
+					"query": map[string]interface{}{"match_all": map[string]interface{}{}},
+					"sort":  []interface{}{map[string]interface{}{"created_at": "desc"}},
+				}
+				page := SearchPage{Size: 2, SearchAfter: []interface{}{"2023-01-02T10:00:00Z", "1"}}
+				highlight := &Highlight{PreTag: "<b>", PostTag: "</b>", FragmentSize: 50}
+				return storage.FindByQuery(body, page, highlight)
+			},
+			response: estest.Fixture(http.StatusOK, "search_hits"),
+			wantBody: `{"query":{"bool":{"must":[{"match_all":{}}],"must_not":[{"exists":{"field":"deleted_at"}}]}},` +
+				`"sort":[{"created_at":"desc"}],"size":2,"track_total_hits":true,"search_after":["2023-01-02T10:00:00Z","1"],` +
+				`"highlight":{"pre_tags":["<b>"],"post_tags":["</b>"],"fragment_size":50,` +
+				`"fields":{"name":{},"job":{},"comment":{},"childNames":{}}}}`,
+			wantIDs: []string{"1", "2"},
+		},
+		{
+			name: "invalid query",
+			search: func(storage UserInfoStorer) (SearchResult, error) {
+				return storage.FindByQuery(map[string]interface{}{"query": map[string]interface{}{"bogus": nil}}, SearchPage{Size: 10}, nil)
+			},
+			response: estest.Error(http.StatusBadRequest, "parsing_exception", "unknown query [bogus]"),
+			wantErr:  model.ErrInvalidQuery,
+		},
+		{
+			name: "missing index",
+			search: func(storage UserInfoStorer) (SearchResult, error) {
+				return storage.FindByConditions(Conditions{}, SearchPage{Size: 10}, nil)
+			},
+			response: estest.Fixture(http.StatusNotFound, "index_not_found"),
+			wantMsg:  "search: response: [404 Not Found]",
+		},
+		{
+			name: "rejected",
+			search: func(storage UserInfoStorer) (SearchResult, error) {
+				return storage.FindByConditions(Conditions{}, SearchPage{Size: 10}, nil)
+			},
+			response: estest.TooManyRequests(),
+			wantMsg:  "search: response: [429 Too Many Requests]",
+		},
+		{
+			name: "malformed response",
+			search: func(storage UserInfoStorer) (SearchResult, error) {
+				return storage.FindByConditions(Conditions{}, SearchPage{Size: 10}, nil)
+			},
+			response: estest.Raw(http.StatusOK, `{"hits":`),
+			wantMsg:  "search: decode:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es, storage := newTestStorage(t)
+			es.On(http.MethodPost, "/user_alias/_search", tt.response)
+
+			result, err := tt.search(storage)
+			assertError(t, err, tt.wantErr, tt.wantMsg)
+
+			requests := assertRequests(t, es, "POST /user_alias/_search")
+			if tt.wantBody != "" {
+				assertBody(t, requests[0], tt.wantBody)
+			}
+
+			var ids []string
+			for _, hit := range result.Hits {
+				ids = append(ids, hit.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("hit ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestUserInfoStorageSearchMalformedHits(t *testing.T) {
+	es, storage := newTestStorage(t)
+	es.On(http.MethodPost, "/user_alias/_search", estest.Fixture(http.StatusOK, "search_malformed_hits"))
+
+	result, err := storage.FindByConditions(Conditions{}, SearchPage{Size: 10, IncludeDeleted: true}, nil)
+	if err != nil {
+		t.Fatalf("FindByConditions() error = %v", err)
+	}
+	if result.Total != 4 {
+		t.Errorf("total = %d, want 4", result.Total)
+	}
+
+	deletedAt := time.Date(2023, 3, 1, 9, 0, 0, 0, time.UTC)
+	want := []UserInfo{
+		{ID: "missing-source"},
+		{ID: "mistyped-fields", Job: "Doctor", Revision: 3, DeletedAt: &deletedAt},
+		{ID: "source-not-object"},
+		{ID: "no-id-in-source", Name: "Can Demir", Job: "Teacher"},
+	}
+	if len(result.Hits) != len(want) {
+		t.Fatalf("got %d hits, want %d", len(result.Hits), len(want))
+	}
+	for i, hit := range result.Hits {
+		if !reflect.DeepEqual(hit.User, want[i]) {
+			t.Errorf("hit %d user = %+v, want %+v", i, hit.User, want[i])
+		}
+		if len(hit.Sort) != 2 {
+			t.Errorf("hit %d sort = %v, want score and id", i, hit.Sort)
+		}
+	}
+}
+
+func TestElasticSearchCreateIndex(t *testing.T) {
+	tests := []struct {
+		name         string
+		script       func(es *estest.Server)
+		wantMsg      string
+		wantRequests []string
+	}{
+		{
+			name: "alias exists",
+			script: func(es *estest.Server) {
+				es.On(http.MethodHead, "/_alias/user_alias", estest.Exists())
+			},
+			wantRequests: []string{"HEAD /_alias/user_alias"},
+		},
+		{
+			name: "alias missing",
+			script: func(es *estest.Server) {
+				es.On(http.MethodHead, "/_alias/user_alias", estest.Missing())
+				es.On(http.MethodPut, "/user_v1", estest.Acknowledged())
+				es.On(http.MethodPut, "/user_v1/_aliases/user_alias", estest.Acknowledged())
+			},
+			wantRequests: []string{"HEAD /_alias/user_alias", "PUT /user_v1", "PUT /user_v1/_aliases/user_alias"},
+		},
+		{
+			name: "index creation fails",
+			script: func(es *estest.Server) {
+				es.On(http.MethodHead, "/_alias/user_alias", estest.Missing())
+				es.On(http.MethodPut, "/user_v1", estest.Error(http.StatusBadRequest, "resource_already_exists_exception", "index [user_v1] already exists"))
+			},
+			wantMsg:      "cannot create index: create index: response: [400 Bad Request]",
+			wantRequests: []string{"HEAD /_alias/user_alias", "PUT /user_v1"},
+		},
+		{
+			name: "alias check fails",
+			script: func(es *estest.Server) {
+				es.On(http.MethodHead, "/_alias/user_alias", estest.Response{Status: http.StatusInternalServerError})
+			},
+			wantMsg:      "error in index alias existence response: [500 Internal Server Error]",
+			wantRequests: []string{"HEAD /_alias/user_alias"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es := estest.NewServer()
+			defer es.Close()
+			tt.script(es)
+
+			elastic, err := New(es.Addresses(), AnalysisConfig{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			err = elastic.CreateIndex("user")
+			assertError(t, err, nil, tt.wantMsg)
+
+			requests := assertRequests(t, es, tt.wantRequests...)
+			if len(requests) < 2 {
+				return
+			}
+			var definition map[string]interface{}
+			if err := requests[1].Decode(&definition); err != nil {
+				t.Fatalf("index definition: %v", err)
+			}
+			if _, ok := definition["mappings"]; !ok {
+				t.Errorf("index definition %s has no mappings", requests[1].Body)
+			}
+		})
+	}
+}
+
+// assertError checks err against the sentinel wantErr, or its message against
+// the prefix wantMsg. Neither set means no error is expected.
+func assertError(t *testing.T, err error, wantErr error, wantMsg string) {
+	t.Helper()
+
+	switch {
+	case wantErr != nil:
+		if !errors.Is(err, wantErr) {
+			t.Fatalf("error = %v, want %v", err, wantErr)
+		}
+	case wantMsg != "":
+		if err == nil || !strings.HasPrefix(err.Error(), wantMsg) {
+			t.Fatalf("error = %v, want it to start with %q", err, wantMsg)
+		}
+	case err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// assertRequests checks the method and path of every request the server got,
+// in order, and that each of them was scripted.
+func assertRequests(t *testing.T, es *estest.Server, want ...string) []estest.Request {
+	t.Helper()
+
+	requests := es.Requests()
+	got := make([]string, 0, len(requests))
+	for _, req := range requests {
+		got = append(got, req.Method+" "+req.Path)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("requests = %v, want %v", got, want)
+	}
+	if unmatched := es.Unmatched(); len(unmatched) > 0 {
+		t.Fatalf("%d requests were not scripted", len(unmatched))
+	}
+	return requests
+}
+
+func assertBody(t *testing.T, req estest.Request, want string) {
+	t.Helper()
+
+	var got, wantValue interface{}
+	if err := req.Decode(&got); err != nil {
+		t.Fatalf("%s %s body %s: %v", req.Method, req.Path, req.Body, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("expected body %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(got, wantValue) {
+		t.Errorf("%s %s body = %s, want %s", req.Method, req.Path, req.Body, want)
+	}
+}
+
+func assertQuery(t *testing.T, req estest.Request, key string, want string) {
+	t.Helper()
+
+	if got := req.Query.Get(key); got != want {
+		t.Errorf("%s %s %s = %q, want %q", req.Method, req.Path, key, got, want)
+	}
+}
+
+// assertHistory checks the history entry of revision 2 of the get_found
+// fixture.
+func assertHistory(t *testing.T, req estest.Request, action string) {
+	t.Helper()
+
+	var entry HistoryEntry
+	if err := req.Decode(&entry); err != nil {
+		t.Fatalf("history entry %s: %v", req.Body, err)
+	}
+	if entry.UserID != "1" || entry.Revision != 2 || entry.Action != action {
+		t.Errorf("history entry = %s %d %s, want 1 2 %s", entry.UserID, entry.Revision, entry.Action, action)
+	}
+	if entry.User.Name != "Mehmet Yılmaz" || entry.User.Job != "Software Engineer" {
+		t.Errorf("history user = %+v, want the stored one", entry.User)
+	}
+}
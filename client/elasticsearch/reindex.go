@@ -0,0 +1,724 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// catchUpMargin is subtracted from the start of the copy when catching up,
+	// since users carry the time of the API and the index that of the cluster.
+	catchUpMargin = time.Minute
+
+	idPageSize = 1000
+)
+
+type IndexManager interface {
+	Reindex(ctx context.Context) (ReindexResult, error)
+	StartReindex(ctx context.Context) (ReindexTask, error)
+	FinishReindex(ctx context.Context, source string, destination string) (ReindexResult, error)
+	AbortReindex(ctx context.Context, source string, destination string) error
+	Rollback(ctx context.Context, index string) error
+	StartAuditBackfill(ctx context.Context) (string, error)
+	RefreshInterval(ctx context.Context) (string, error)
+	SetRefreshInterval(ctx context.Context, interval string) error
+}
+
+// ReindexTask is a reindex whose copy runs as the task TaskID. It is finished
+// by FinishReindex once the task completed.
+type ReindexTask struct {
+	TaskID      string
+	Source      string
+	Destination string
+}
+
+type ReindexResult struct {
+	Source           string
+	Destination      string
+	SourceCount      int64
+	DestinationCount int64
+	MappingVersion   int
+}
+
+// physicalIndex returns the name of the generation-th physical index behind
+// the alias. The first generation may also be the unversioned legacy index.
+func (e *ElasticSearch) physicalIndex(generation int) string {
+	return fmt.Sprintf("%s_v%d", e.index, generation)
+}
+
+func (e *ElasticSearch) generation(index string) (int, error) {
+	if index == e.index {
+		return 1, nil
+	}
+
+	suffix := strings.TrimPrefix(index, e.index+"_v")
+	if suffix == index {
+		return 0, fmt.Errorf("index %s does not belong to %s", index, e.alias)
+	}
+
+	generation, err := strconv.Atoi(suffix)
+	if err != nil || generation < 1 {
+		return 0, fmt.Errorf("index %s does not belong to %s", index, e.alias)
+	}
+
+	return generation, nil
+}
+
+func (e *ElasticSearch) currentIndex(ctx context.Context) (string, error) {
+	res, err := e.client.Indices.GetAlias(
+		e.client.Indices.GetAlias.WithContext(ctx),
+		e.client.Indices.GetAlias.WithName(e.alias),
+	)
+	if err != nil {
+		return "", fmt.Errorf("current index: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", fmt.Errorf("current index: response: %s", res.String())
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("current index: decode: %w", err)
+	}
+	if len(body) != 1 {
+		return "", fmt.Errorf("current index: alias %s points to %d indices", e.alias, len(body))
+	}
+
+	for index := range body {
+		return index, nil
+	}
+	return "", nil
+}
+
+func (e *ElasticSearch) indexExists(ctx context.Context, index string) (bool, error) {
+	res, err := e.client.Indices.Exists([]string{index}, e.client.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("index exists: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, fmt.Errorf("index exists: response: %s", res.String())
+	}
+}
+
+func (e *ElasticSearch) createPhysicalIndex(ctx context.Context, index string) error {
+	bdy, err := json.Marshal(userIndexDefinition(e.analysis))
+	if err != nil {
+		return fmt.Errorf("create index: marshall: %w", err)
+	}
+
+	res, err := e.client.Indices.Create(index,
+		e.client.Indices.Create.WithContext(ctx),
+		e.client.Indices.Create.WithBody(bytes.NewReader(bdy)),
+	)
+	if err != nil {
+		return fmt.Errorf("create index: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("create index: response: %s", res.String())
+	}
+
+	return nil
+}
+
+func (e *ElasticSearch) deleteIndex(ctx context.Context, index string) error {
+	res, err := e.client.Indices.Delete([]string{index}, e.client.Indices.Delete.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("delete index: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("delete index: response: %s", res.String())
+	}
+
+	return nil
+}
+
+func (e *ElasticSearch) count(ctx context.Context, index string) (int64, error) {
+	res, err := e.client.Count(
+		e.client.Count.WithContext(ctx),
+		e.client.Count.WithIndex(index),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("count: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, fmt.Errorf("count: response: %s", res.String())
+	}
+
+	var body struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("count: decode: %w", err)
+	}
+
+	return body.Count, nil
+}
+
+func (e *ElasticSearch) refresh(ctx context.Context, index string) error {
+	res, err := e.client.Indices.Refresh(
+		e.client.Indices.Refresh.WithContext(ctx),
+		e.client.Indices.Refresh.WithIndex(index),
+	)
+	if err != nil {
+		return fmt.Errorf("refresh: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("refresh: response: %s", res.String())
+	}
+
+	return nil
+}
+
+// swapAlias moves the alias from one physical index to another in a single
+// _aliases call, so readers and writers never see the alias missing.
+func (e *ElasticSearch) swapAlias(ctx context.Context, from string, to string) error {
+	actions := map[string]interface{}{
+		"actions": []interface{}{
+			map[string]interface{}{"remove": map[string]interface{}{"index": from, "alias": e.alias}},
+			map[string]interface{}{"add": map[string]interface{}{"index": to, "alias": e.alias}},
+		},
+	}
+
+	bdy, err := json.Marshal(actions)
+	if err != nil {
+		return fmt.Errorf("swap alias: marshall: %w", err)
+	}
+
+	res, err := e.client.Indices.UpdateAliases(bytes.NewReader(bdy), e.client.Indices.UpdateAliases.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("swap alias: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("swap alias: response: %s", res.String())
+	}
+
+	return nil
+}
+
+// setWriteBlock sets or clears index.blocks.write. While it is set, writes to
+// the index fail with a cluster_block_exception.
+func (e *ElasticSearch) setWriteBlock(ctx context.Context, index string, blocked bool) error {
+	var value interface{}
+	if blocked {
+		value = true
+	}
+
+	bdy, err := json.Marshal(map[string]interface{}{
+		"index": map[string]interface{}{"blocks.write": value},
+	})
+	if err != nil {
+		return fmt.Errorf("write block: marshall: %w", err)
+	}
+
+	res, err := e.client.Indices.PutSettings(bytes.NewReader(bdy),
+		e.client.Indices.PutSettings.WithContext(ctx),
+		e.client.Indices.PutSettings.WithIndex(index),
+	)
+	if err != nil {
+		return fmt.Errorf("write block: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("write block: response: %s", res.String())
+	}
+
+	return nil
+}
+
+// writeBlocked reports whether a write failed on the write block FinishReindex
+// sets. The body stays readable for the caller.
+func writeBlocked(res *esapi.Response) bool {
+	if res.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return false
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	return bytes.Contains(body, []byte("cluster_block_exception"))
+}
+
+// Reindex copies the index behind the alias into the next generation created
+// with the current mapping, and moves the alias once both indices hold the
+// same number of documents. Writes to the source stay open during the copy;
+// FinishReindex catches up on them. The previous index is kept for Rollback.
+func (e *ElasticSearch) Reindex(ctx context.Context) (ReindexResult, error) {
+	source, destination, err := e.prepareReindex(ctx)
+	if err != nil {
+		return ReindexResult{}, fmt.Errorf("reindex: %w", err)
+	}
+
+	if err := e.copyDocuments(ctx, source, destination, nil); err != nil {
+		e.cancelReindex(ctx, source, destination)
+		return ReindexResult{}, fmt.Errorf("reindex: %w", err)
+	}
+
+	return e.FinishReindex(ctx, source, destination)
+}
+
+// StartReindex creates the next generation and starts copying into it as a
+// task, without waiting for the copy. Writes to the source stay open; call
+// FinishReindex or AbortReindex once the task is done.
+func (e *ElasticSearch) StartReindex(ctx context.Context) (ReindexTask, error) {
+	source, destination, err := e.prepareReindex(ctx)
+	if err != nil {
+		return ReindexTask{}, fmt.Errorf("start reindex: %w", err)
+	}
+
+	res, err := e.reindexRequest(ctx, source, destination, nil, false)
+	if err != nil {
+		e.cancelReindex(ctx, source, destination)
+		return ReindexTask{}, fmt.Errorf("start reindex: %w", err)
+	}
+	defer res.Body.Close()
+
+	taskID, err := decodeTaskID("start reindex", res)
+	if err != nil {
+		e.cancelReindex(ctx, source, destination)
+		return ReindexTask{}, err
+	}
+
+	return ReindexTask{TaskID: taskID, Source: source, Destination: destination}, nil
+}
+
+// FinishReindex blocks writes to the source, copies what changed since the
+// copy started, and moves the alias to the destination once it holds as many
+// documents as the source. Otherwise the destination is deleted. Either way
+// the source accepts writes again; writes during the short block fail with
+// model.ErrWriteBlocked.
+func (e *ElasticSearch) FinishReindex(ctx context.Context, source string, destination string) (ReindexResult, error) {
+	if err := e.setWriteBlock(ctx, source, true); err != nil {
+		e.cancelReindex(ctx, source, destination)
+		return ReindexResult{}, fmt.Errorf("reindex: %w", err)
+	}
+
+	if err := e.catchUp(ctx, source, destination); err != nil {
+		e.cancelReindex(ctx, source, destination)
+		return ReindexResult{}, fmt.Errorf("reindex: %w", err)
+	}
+
+	result, err := e.verifyCounts(ctx, source, destination)
+	if err != nil {
+		e.cancelReindex(ctx, source, destination)
+		return ReindexResult{}, fmt.Errorf("reindex: %w", err)
+	}
+
+	if err := e.swapAlias(ctx, source, destination); err != nil {
+		_ = e.setWriteBlock(ctx, source, false)
+		return ReindexResult{}, fmt.Errorf("reindex: %w", err)
+	}
+
+	if err := e.setWriteBlock(ctx, source, false); err != nil {
+		return ReindexResult{}, fmt.Errorf("reindex: %w", err)
+	}
+
+	return result, nil
+}
+
+// AbortReindex deletes the destination of a failed or cancelled reindex and
+// lifts the write block of the source, if any.
+func (e *ElasticSearch) AbortReindex(ctx context.Context, source string, destination string) error {
+	if err := e.deleteIndex(ctx, destination); err != nil {
+		return fmt.Errorf("abort reindex: %w", err)
+	}
+	if err := e.setWriteBlock(ctx, source, false); err != nil {
+		return fmt.Errorf("abort reindex: %w", err)
+	}
+	return nil
+}
+
+// cancelReindex is AbortReindex for paths that already fail with another
+// error.
+func (e *ElasticSearch) cancelReindex(ctx context.Context, source string, destination string) {
+	_ = e.deleteIndex(ctx, destination)
+	_ = e.setWriteBlock(ctx, source, false)
+}
+
+// prepareReindex creates the first free generation after the index behind
+// the alias and returns both.
+func (e *ElasticSearch) prepareReindex(ctx context.Context) (string, string, error) {
+	source, err := e.currentIndex(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	generation, err := e.generation(source)
+	if err != nil {
+		return "", "", err
+	}
+
+	destination := e.physicalIndex(generation + 1)
+	for {
+		exists, err := e.indexExists(ctx, destination)
+		if err != nil {
+			return "", "", err
+		}
+		if !exists {
+			break
+		}
+		generation++
+		destination = e.physicalIndex(generation + 1)
+	}
+
+	if err := e.createPhysicalIndex(ctx, destination); err != nil {
+		return "", "", err
+	}
+
+	return source, destination, nil
+}
+
+// copyDocuments copies the documents of the source matching query, or all of
+// them, into the destination. Documents keep their version, so a later copy
+// only overwrites those that changed in between.
+func (e *ElasticSearch) copyDocuments(ctx context.Context, source string, destination string, query map[string]interface{}) error {
+	res, err := e.reindexRequest(ctx, source, destination, query, true)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("copy documents: response: %s", res.String())
+	}
+
+	var body struct {
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return fmt.Errorf("copy documents: decode: %w", err)
+	}
+	if len(body.Failures) > 0 {
+		return fmt.Errorf("copy documents: %d failures, first: %s", len(body.Failures), body.Failures[0])
+	}
+
+	return nil
+}
+
+func (e *ElasticSearch) reindexRequest(ctx context.Context, source string, destination string, query map[string]interface{}, wait bool) (*esapi.Response, error) {
+	sourceIndex := map[string]interface{}{"index": source}
+	if query != nil {
+		sourceIndex["query"] = query
+	}
+	bdy, err := json.Marshal(map[string]interface{}{
+		"conflicts": "proceed",
+		"source":    sourceIndex,
+		"dest":      map[string]interface{}{"index": destination, "version_type": "external"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("copy documents: marshall: %w", err)
+	}
+
+	res, err := e.client.Reindex(bytes.NewReader(bdy),
+		e.client.Reindex.WithContext(ctx),
+		e.client.Reindex.WithWaitForCompletion(wait),
+		e.client.Reindex.WithRefresh(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("copy documents: request: %w", err)
+	}
+
+	return res, nil
+}
+
+// catchUp copies the users written since the destination was created, with
+// catchUpMargin for clock skew between the API and the cluster, and removes
+// those deleted meanwhile. Writes to the source must be blocked.
+func (e *ElasticSearch) catchUp(ctx context.Context, source string, destination string) error {
+	created, err := e.creationDate(ctx, destination)
+	if err != nil {
+		return err
+	}
+
+	since := created.Add(-catchUpMargin).Format(time.RFC3339Nano)
+	query := map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"range": map[string]interface{}{"updated_at": map[string]interface{}{"gte": since}}},
+				map[string]interface{}{"range": map[string]interface{}{"created_at": map[string]interface{}{"gte": since}}},
+			},
+			"minimum_should_match": 1,
+		},
+	}
+	if err := e.copyDocuments(ctx, source, destination, query); err != nil {
+		return fmt.Errorf("catch up: %w", err)
+	}
+
+	if err := e.refresh(ctx, source+","+destination); err != nil {
+		return fmt.Errorf("catch up: %w", err)
+	}
+	sourceCount, err := e.count(ctx, source)
+	if err != nil {
+		return fmt.Errorf("catch up: %w", err)
+	}
+	destinationCount, err := e.count(ctx, destination)
+	if err != nil {
+		return fmt.Errorf("catch up: %w", err)
+	}
+	if destinationCount <= sourceCount {
+		return nil
+	}
+
+	return e.removeDeleted(ctx, source, destination)
+}
+
+// removeDeleted deletes the documents of the destination that are missing
+// from the source, page by page of ids.
+func (e *ElasticSearch) removeDeleted(ctx context.Context, source string, destination string) error {
+	var after []interface{}
+	for {
+		ids, last, err := e.idPage(ctx, destination, after)
+		if err != nil {
+			return fmt.Errorf("remove deleted: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		after = last
+
+		existing, err := e.existingIDs(ctx, source, ids)
+		if err != nil {
+			return fmt.Errorf("remove deleted: %w", err)
+		}
+		var missing []string
+		for _, id := range ids {
+			if !existing[id] {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		if err := e.deleteIDs(ctx, destination, missing); err != nil {
+			return fmt.Errorf("remove deleted: %w", err)
+		}
+	}
+}
+
+// idPage returns the next idPageSize document ids of the index after the sort
+// values of the previous page.
+func (e *ElasticSearch) idPage(ctx context.Context, index string, after []interface{}) ([]string, []interface{}, error) {
+	query := map[string]interface{}{
+		"size":    idPageSize,
+		"_source": false,
+		"sort":    []interface{}{map[string]interface{}{"id": "asc"}},
+	}
+	if after != nil {
+		query["search_after"] = after
+	}
+
+	hits, err := e.searchIDs(ctx, index, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]string, 0, len(hits))
+	var last []interface{}
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+		last = hit.Sort
+	}
+	return ids, last, nil
+}
+
+// existingIDs reports which of ids are documents of the index.
+func (e *ElasticSearch) existingIDs(ctx context.Context, index string, ids []string) (map[string]bool, error) {
+	hits, err := e.searchIDs(ctx, index, map[string]interface{}{
+		"size":    len(ids),
+		"_source": false,
+		"query":   map[string]interface{}{"ids": map[string]interface{}{"values": ids}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(hits))
+	for _, hit := range hits {
+		existing[hit.ID] = true
+	}
+	return existing, nil
+}
+
+func (e *ElasticSearch) searchIDs(ctx context.Context, index string, query map[string]interface{}) ([]searchHit, error) {
+	bdy, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("search ids: marshall: %w", err)
+	}
+
+	res, err := e.client.Search(
+		e.client.Search.WithContext(ctx),
+		e.client.Search.WithIndex(index),
+		e.client.Search.WithBody(bytes.NewReader(bdy)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("search ids: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("search ids: response: %s", res.String())
+	}
+
+	var body searchResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("search ids: decode: %w", err)
+	}
+	return body.Hits.Hits, nil
+}
+
+func (e *ElasticSearch) deleteIDs(ctx context.Context, index string, ids []string) error {
+	bdy, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"ids": map[string]interface{}{"values": ids}},
+	})
+	if err != nil {
+		return fmt.Errorf("delete ids: marshall: %w", err)
+	}
+
+	res, err := e.client.DeleteByQuery([]string{index}, bytes.NewReader(bdy),
+		e.client.DeleteByQuery.WithContext(ctx),
+		e.client.DeleteByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return fmt.Errorf("delete ids: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("delete ids: response: %s", res.String())
+	}
+
+	return nil
+}
+
+// creationDate reads index.creation_date, the time the copy started.
+func (e *ElasticSearch) creationDate(ctx context.Context, index string) (time.Time, error) {
+	res, err := e.client.Indices.GetSettings(
+		e.client.Indices.GetSettings.WithContext(ctx),
+		e.client.Indices.GetSettings.WithIndex(index),
+		e.client.Indices.GetSettings.WithName("index.creation_date"),
+	)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("creation date: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return time.Time{}, fmt.Errorf("creation date: response: %s", res.String())
+	}
+
+	var indices map[string]struct {
+		Settings struct {
+			Index struct {
+				CreationDate string `json:"creation_date"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return time.Time{}, fmt.Errorf("creation date: decode: %w", err)
+	}
+
+	settings, ok := indices[index]
+	if !ok {
+		return time.Time{}, fmt.Errorf("creation date: index %s is missing", index)
+	}
+	millis, err := strconv.ParseInt(settings.Settings.Index.CreationDate, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("creation date: %w", err)
+	}
+	return time.UnixMilli(millis).UTC(), nil
+}
+
+func (e *ElasticSearch) verifyCounts(ctx context.Context, source string, destination string) (ReindexResult, error) {
+	if err := e.refresh(ctx, source+","+destination); err != nil {
+		return ReindexResult{}, err
+	}
+
+	sourceCount, err := e.count(ctx, source)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+
+	destinationCount, err := e.count(ctx, destination)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+
+	if sourceCount != destinationCount {
+		return ReindexResult{}, fmt.Errorf("document count mismatch: %s has %d, %s has %d",
+			source, sourceCount, destination, destinationCount)
+	}
+
+	return ReindexResult{
+		Source:           source,
+		Destination:      destination,
+		SourceCount:      sourceCount,
+		DestinationCount: destinationCount,
+		MappingVersion:   UserMappingVersion,
+	}, nil
+}
+
+// Rollback points the alias back at an index kept by a previous Reindex.
+func (e *ElasticSearch) Rollback(ctx context.Context, index string) error {
+	if _, err := e.generation(index); err != nil {
+		return model.ErrNotFound
+	}
+
+	exists, err := e.indexExists(ctx, index)
+	if err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+	if !exists {
+		return model.ErrNotFound
+	}
+
+	// The index may still be blocked by a reindex that never finished.
+	if err := e.setWriteBlock(ctx, index, false); err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+
+	current, err := e.currentIndex(ctx)
+	if err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+	if current == index {
+		return nil
+	}
+
+	if err := e.swapAlias(ctx, current, index); err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package elasticsearch
+
+import (
+	"context"
+	"elastic-project/client/elasticsearch/estest"
+	"elastic-project/model"
+	"net/http"
+	"testing"
+)
+
+func TestElasticSearchFinishReindex(t *testing.T) {
+	settings := estest.JSON(http.StatusOK, map[string]interface{}{
+		"user_v2": map[string]interface{}{
+			"settings": map[string]interface{}{"index": map[string]interface{}{"creation_date": "1672653600000"}},
+		},
+	})
+	count := func(n int) estest.Response {
+		return estest.JSON(http.StatusOK, map[string]interface{}{"count": n})
+	}
+	copied := estest.JSON(http.StatusOK, map[string]interface{}{"updated": 1, "failures": []interface{}{}})
+
+	tests := []struct {
+		name         string
+		script       func(es *estest.Server)
+		wantMsg      string
+		wantRequests []string
+	}{
+		{
+			name: "nothing deleted meanwhile",
+			script: func(es *estest.Server) {
+				es.On("", "/user_v2/_count", count(2))
+			},
+			wantRequests: []string{
+				"PUT /user_v1/_settings",
+				"GET /user_v2/_settings/index.creation_date",
+				"POST /_reindex",
+				"POST /user_v1,user_v2/_refresh",
+				"POST /user_v1/_count",
+				"POST /user_v2/_count",
+				"POST /user_v1,user_v2/_refresh",
+				"POST /user_v1/_count",
+				"POST /user_v2/_count",
+				"POST /_aliases",
+				"PUT /user_v1/_settings",
+			},
+		},
+		{
+			name: "users deleted meanwhile",
+			script: func(es *estest.Server) {
+				es.On("", "/user_v2/_count", count(3), count(2))
+				es.On(http.MethodPost, "/user_v2/_search",
+					estest.Hits(3,
+						estest.Hit{Index: "user_v2", ID: "1", Sort: []interface{}{"1"}},
+						estest.Hit{Index: "user_v2", ID: "2", Sort: []interface{}{"2"}},
+						estest.Hit{Index: "user_v2", ID: "3", Sort: []interface{}{"3"}}),
+					estest.Hits(3))
+				es.On(http.MethodPost, "/user_v1/_search",
+					estest.Hits(2, estest.Hit{Index: "user_v1", ID: "1"}, estest.Hit{Index: "user_v1", ID: "3"}))
+				es.On(http.MethodPost, "/user_v2/_delete_by_query", estest.JSON(http.StatusOK, map[string]interface{}{"deleted": 1}))
+			},
+			wantRequests: []string{
+				"PUT /user_v1/_settings",
+				"GET /user_v2/_settings/index.creation_date",
+				"POST /_reindex",
+				"POST /user_v1,user_v2/_refresh",
+				"POST /user_v1/_count",
+				"POST /user_v2/_count",
+				"POST /user_v2/_search",
+				"POST /user_v1/_search",
+				"POST /user_v2/_delete_by_query",
+				"POST /user_v2/_search",
+				"POST /user_v1,user_v2/_refresh",
+				"POST /user_v1/_count",
+				"POST /user_v2/_count",
+				"POST /_aliases",
+				"PUT /user_v1/_settings",
+			},
+		},
+		{
+			name: "counts still differ",
+			script: func(es *estest.Server) {
+				es.On("", "/user_v2/_count", count(1))
+				es.On(http.MethodDelete, "/user_v2", estest.Acknowledged())
+			},
+			wantMsg: "reindex: document count mismatch",
+			wantRequests: []string{
+				"PUT /user_v1/_settings",
+				"GET /user_v2/_settings/index.creation_date",
+				"POST /_reindex",
+				"POST /user_v1,user_v2/_refresh",
+				"POST /user_v1/_count",
+				"POST /user_v2/_count",
+				"POST /user_v1,user_v2/_refresh",
+				"POST /user_v1/_count",
+				"POST /user_v2/_count",
+				"DELETE /user_v2",
+				"PUT /user_v1/_settings",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			es := estest.NewServer()
+			defer es.Close()
+
+			elastic, err := New(es.Addresses(), AnalysisConfig{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			es.On(http.MethodHead, "/_alias/user_alias", estest.Exists())
+			if err := elastic.CreateIndex("user"); err != nil {
+				t.Fatalf("CreateIndex() error = %v", err)
+			}
+			es.Reset()
+
+			es.On(http.MethodPut, "/user_v1/_settings", estest.Acknowledged())
+			es.On(http.MethodGet, "/user_v2/_settings/index.creation_date", settings)
+			es.On(http.MethodPost, "/_reindex", copied)
+			es.On(http.MethodPost, "/user_v1,user_v2/_refresh", estest.JSON(http.StatusOK, map[string]interface{}{}))
+			es.On("", "/user_v1/_count", count(2))
+			es.On(http.MethodPost, "/_aliases", estest.Acknowledged())
+			tt.script(es)
+
+			_, err = elastic.FinishReindex(context.Background(), "user_v1", "user_v2")
+			assertError(t, err, nil, tt.wantMsg)
+
+			requests := assertRequests(t, es, tt.wantRequests...)
+			assertBody(t, requests[0], `{"index":{"blocks.write":true}}`)
+			assertBody(t, requests[len(requests)-1], `{"index":{"blocks.write":null}}`)
+			assertBody(t, requests[2], `{"conflicts":"proceed",`+
+				`"source":{"index":"user_v1","query":{"bool":{"minimum_should_match":1,"should":[`+
+				`{"range":{"updated_at":{"gte":"2023-01-02T09:59:00Z"}}},`+
+				`{"range":{"created_at":{"gte":"2023-01-02T09:59:00Z"}}}]}}},`+
+				`"dest":{"index":"user_v2","version_type":"external"}}`)
+			for _, req := range requests {
+				if req.Path == "/user_v2/_delete_by_query" {
+					assertBody(t, req, `{"query":{"ids":{"values":["2"]}}}`)
+				}
+			}
+		})
+	}
+}
+
+func TestUserInfoStorageWriteBlocked(t *testing.T) {
+	es, storage := newTestStorage(t)
+	es.On(http.MethodPut, userPath+"/_create", estest.Error(http.StatusForbidden, "cluster_block_exception",
+		"index [user_v1] blocked by: [FORBIDDEN/8/index write (api)];"))
+
+	err := storage.Insert(context.Background(), UserInfo{ID: "1", Name: "Ahmet Kaya", Job: "Doctor"})
+	assertError(t, err, model.ErrWriteBlocked, "")
+}
@@ -0,0 +1,126 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+type SearchHit struct {
+	ID        string
+	Score     *float64
+	Sort      []interface{}
+	Highlight map[string][]string
+	User      UserInfo
+}
+
+type ShardsInfo struct {
+	Total      int            `json:"total"`
+	Successful int            `json:"successful"`
+	Skipped    int            `json:"skipped"`
+	Failed     int            `json:"failed"`
+	Failures   []ShardFailure `json:"failures"`
+}
+
+type ShardFailure struct {
+	Index  string `json:"index"`
+	Shard  int    `json:"shard"`
+	Node   string `json:"node"`
+	Reason struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"reason"`
+}
+
+type searchResponse struct {
+	PitID    string     `json:"pit_id"`
+	TimedOut bool       `json:"timed_out"`
+	Shards   ShardsInfo `json:"_shards"`
+	Hits     struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+		Hits []searchHit `json:"hits"`
+	} `json:"hits"`
+}
+
+type searchHit struct {
+	ID        string              `json:"_id"`
+	Score     *float64            `json:"_score"`
+	Sort      []interface{}       `json:"sort"`
+	Highlight map[string][]string `json:"highlight"`
+	Source    json.RawMessage     `json:"_source"`
+}
+
+// decodeSearchResponse is shared by every _search call on the user index.
+// Documents that miss fields or carry unexpected values decode to partial
+// users instead of failing the whole response.
+func decodeSearchResponse(body io.Reader) (SearchResult, error) {
+	var response searchResponse
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return SearchResult{}, err
+	}
+
+	result := SearchResult{
+		PitID:    response.PitID,
+		Total:    response.Hits.Total.Value,
+		TimedOut: response.TimedOut,
+		Shards:   response.Shards,
+		Hits:     make([]SearchHit, 0, len(response.Hits.Hits)),
+	}
+	for _, hit := range response.Hits.Hits {
+		userInfo := decodeUserInfo(hit.Source)
+		if userInfo.ID == "" {
+			userInfo.ID = hit.ID
+		}
+		result.Hits = append(result.Hits, SearchHit{
+			ID:        hit.ID,
+			Score:     hit.Score,
+			Sort:      hit.Sort,
+			Highlight: hit.Highlight,
+			User:      userInfo,
+		})
+	}
+
+	return result, nil
+}
+
+func decodeUserInfo(source json.RawMessage) UserInfo {
+	var userInfo UserInfo
+	if len(source) == 0 {
+		return userInfo
+	}
+	if err := json.Unmarshal(source, &userInfo); err == nil {
+		return userInfo
+	}
+
+	// Fall back to decoding field by field so that one bad value only loses
+	// that field.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(source, &fields); err != nil {
+		return UserInfo{}
+	}
+	_ = json.Unmarshal(fields["id"], &userInfo.ID)
+	_ = json.Unmarshal(fields["name"], &userInfo.Name)
+	_ = json.Unmarshal(fields["job"], &userInfo.Job)
+	_ = json.Unmarshal(fields["childNames"], &userInfo.ChildNames)
+	_ = json.Unmarshal(fields["comment"], &userInfo.Comment)
+	userInfo.CreatedAt = decodeTime(fields["created_at"])
+	userInfo.UpdatedAt = decodeTime(fields["updated_at"])
+	_ = json.Unmarshal(fields["created_by"], &userInfo.CreatedBy)
+	_ = json.Unmarshal(fields["updated_by"], &userInfo.UpdatedBy)
+	_ = json.Unmarshal(fields["revision"], &userInfo.Revision)
+	userInfo.DeletedAt = decodeTime(fields["deleted_at"])
+
+	return userInfo
+}
+
+// decodeTime returns nil for a missing or malformed timestamp rather than the
+// zero time json.Unmarshal leaves behind.
+func decodeTime(value json.RawMessage) *time.Time {
+	var t *time.Time
+	if err := json.Unmarshal(value, &t); err != nil {
+		return nil
+	}
+	return t
+}
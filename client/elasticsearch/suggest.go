@@ -0,0 +1,82 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+type Suggestion struct {
+	Text  string
+	Score float64
+}
+
+// Suggest returns the values of field ("name" or "job") that start with
+// prefix, best match first. Hits are collapsed on the keyword subfield so
+// each value is returned once; job optionally restricts them to one job.
+func (p UserInfoStorage) Suggest(ctx context.Context, field string, prefix string, job string, size int) ([]Suggestion, error) {
+	query := map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must": map[string]interface{}{
+				"multi_match": map[string]interface{}{
+					"query": prefix,
+					"type":  "bool_prefix",
+					"fields": []string{
+						field + "_suggest",
+						field + "_suggest._2gram",
+						field + "_suggest._3gram",
+					},
+				},
+			},
+		},
+	}
+	if job != "" {
+		query["bool"].(map[string]interface{})["filter"] = []interface{}{
+			map[string]interface{}{"term": map[string]interface{}{"job.keyword": job}},
+		}
+	}
+
+	bdy, err := json.Marshal(map[string]interface{}{
+		"size":     size,
+		"_source":  []string{field},
+		"query":    excludeDeleted(query),
+		"collapse": map[string]interface{}{"field": field + ".keyword"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("suggest: marshall: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	es := p.elastic.client
+	res, err := es.Search(es.Search.WithContext(ctx), es.Search.WithIndex(p.elastic.alias), es.Search.WithBody(bytes.NewReader(bdy)))
+	if err != nil {
+		return nil, fmt.Errorf("suggest: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("suggest: response: %s", res.String())
+	}
+
+	result, err := decodeSearchResponse(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("suggest: decode: %w", err)
+	}
+
+	suggestions := make([]Suggestion, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		suggestion := Suggestion{Text: hit.User.Name}
+		if field == "job" {
+			suggestion.Text = hit.User.Job
+		}
+		if hit.Score != nil {
+			suggestion.Score = *hit.Score
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return suggestions, nil
+}
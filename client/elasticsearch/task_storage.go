@@ -0,0 +1,293 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"time"
+)
+
+const (
+	taskIndex = "user_tasks"
+
+	TaskReindex       = "reindex"
+	TaskUpdateByQuery = "update_by_query"
+	TaskDeleteByQuery = "delete_by_query"
+
+	TaskStatusRunning   = "running"
+	TaskStatusCompleted = "completed"
+	TaskStatusFailed    = "failed"
+	TaskStatusCancelled = "cancelled"
+)
+
+type TaskStorage struct {
+	elastic ElasticSearch
+	timeout time.Duration
+}
+
+// TaskStorer keeps the long running operations started by the application
+// in its own index, and reads their live state from the Elasticsearch tasks
+// API.
+type TaskStorer interface {
+	SaveTask(ctx context.Context, task Task) error
+	FindTask(ctx context.Context, id string) (Task, error)
+	FindUnfinishedTasks(ctx context.Context) ([]Task, error)
+	FetchTaskState(ctx context.Context, id string) (TaskState, error)
+	CancelTask(ctx context.Context, id string) error
+}
+
+// Task is an operation running in Elasticsearch. ID is the Elasticsearch task
+// id; Source and Destination are the indices of a reindex.
+type Task struct {
+	ID          string       `json:"id"`
+	Kind        string       `json:"kind"`
+	Status      string       `json:"status"`
+	Source      string       `json:"source,omitempty"`
+	Destination string       `json:"destination,omitempty"`
+	Progress    TaskProgress `json:"progress"`
+	Failures    []string     `json:"failures,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	CreatedBy   string       `json:"created_by,omitempty"`
+	CreatedAt   *time.Time   `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time   `json:"updated_at,omitempty"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+}
+
+type TaskProgress struct {
+	Total            int64 `json:"total"`
+	Created          int64 `json:"created"`
+	Updated          int64 `json:"updated"`
+	Deleted          int64 `json:"deleted"`
+	VersionConflicts int64 `json:"version_conflicts"`
+}
+
+// TaskState is what the tasks API reports about a task. Error is set when the
+// task failed as a whole, Failures for documents it could not change.
+type TaskState struct {
+	Completed bool
+	Cancelled bool
+	Progress  TaskProgress
+	Failures  []string
+	Error     string
+}
+
+func NewTaskStorage(elastic ElasticSearch) TaskStorer {
+	return &TaskStorage{
+		elastic: elastic,
+		timeout: time.Second * 10,
+	}
+}
+
+// CreateTaskIndex creates the index holding the tasks started by the
+// application, unless it already exists.
+func (e *ElasticSearch) CreateTaskIndex() error {
+	return e.createIndices(map[string]string{
+		taskIndex: `{"mappings":{"properties":{
+			"id":{"type":"keyword"},"kind":{"type":"keyword"},"status":{"type":"keyword"},
+			"source":{"type":"keyword"},"destination":{"type":"keyword"},
+			"progress":{"type":"object","enabled":false},"failures":{"type":"text","index":false},
+			"error":{"type":"text"},"created_by":{"type":"keyword"},"created_at":{"type":"date"},
+			"updated_at":{"type":"date"},"finished_at":{"type":"date"}}}}`,
+	})
+}
+
+func (p TaskStorage) SaveTask(ctx context.Context, task Task) error {
+	bdy, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("save task: marshall: %w", err)
+	}
+
+	req := esapi.IndexRequest{
+		Index:      taskIndex,
+		DocumentID: task.ID,
+		Body:       bytes.NewReader(bdy),
+		Refresh:    "true",
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	res, err := req.Do(ctx, p.elastic.client)
+	if err != nil {
+		return fmt.Errorf("save task: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("save task: response: %s", res.String())
+	}
+
+	return nil
+}
+
+func (p TaskStorage) FindTask(ctx context.Context, id string) (Task, error) {
+	req := esapi.GetRequest{
+		Index:      taskIndex,
+		DocumentID: id,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	res, err := req.Do(ctx, p.elastic.client)
+	if err != nil {
+		return Task{}, fmt.Errorf("find task: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return Task{}, model.ErrNotFound
+	}
+
+	if res.IsError() {
+		return Task{}, fmt.Errorf("find task: response: %s", res.String())
+	}
+
+	var (
+		task Task
+		body document
+	)
+	body.Source = &task
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return Task{}, fmt.Errorf("find task: decode: %w", err)
+	}
+
+	return task, nil
+}
+
+func (p TaskStorage) FindUnfinishedTasks(ctx context.Context) ([]Task, error) {
+	query := map[string]interface{}{
+		"size":  100,
+		"query": map[string]interface{}{"term": map[string]interface{}{"status": TaskStatusRunning}},
+		"sort":  []interface{}{map[string]interface{}{"created_at": "asc"}},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var tasks []Task
+	err := p.elastic.searchIndex(ctx, taskIndex, query, func(source json.RawMessage) error {
+		var task Task
+		if err := json.Unmarshal(source, &task); err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find unfinished tasks: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// FetchTaskState reads the task from the tasks API. Elasticsearch keeps the
+// result of a finished task started with wait_for_completion=false in its
+// .tasks index, so it is available after completion too.
+func (p TaskStorage) FetchTaskState(ctx context.Context, id string) (TaskState, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	es := p.elastic.client
+	res, err := es.Tasks.Get(id, es.Tasks.Get.WithContext(ctx))
+	if err != nil {
+		return TaskState{}, fmt.Errorf("fetch task: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return TaskState{}, model.ErrNotFound
+	}
+
+	if res.IsError() {
+		return TaskState{}, fmt.Errorf("fetch task: response: %s", res.String())
+	}
+
+	var body struct {
+		Completed bool `json:"completed"`
+		Task      struct {
+			Cancelled bool         `json:"cancelled"`
+			Status    TaskProgress `json:"status"`
+		} `json:"task"`
+		Response *struct {
+			TaskProgress
+			Canceled string `json:"canceled"`
+			Failures []struct {
+				ID    string `json:"id"`
+				Cause struct {
+					Reason string `json:"reason"`
+				} `json:"cause"`
+			} `json:"failures"`
+		} `json:"response"`
+		Error *struct {
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return TaskState{}, fmt.Errorf("fetch task: decode: %w", err)
+	}
+
+	state := TaskState{
+		Completed: body.Completed,
+		Cancelled: body.Task.Cancelled,
+		Progress:  body.Task.Status,
+	}
+	if body.Response != nil {
+		state.Progress = body.Response.TaskProgress
+		state.Cancelled = state.Cancelled || body.Response.Canceled != ""
+		for _, failure := range body.Response.Failures {
+			state.Failures = append(state.Failures, fmt.Sprintf("%s: %s", failure.ID, failure.Cause.Reason))
+		}
+	}
+	if body.Error != nil {
+		state.Error = body.Error.Reason
+	}
+
+	return state, nil
+}
+
+func (p TaskStorage) CancelTask(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	es := p.elastic.client
+	res, err := es.Tasks.Cancel(es.Tasks.Cancel.WithContext(ctx), es.Tasks.Cancel.WithTaskID(id))
+	if err != nil {
+		return fmt.Errorf("cancel task: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return model.ErrNotFound
+	}
+
+	if res.IsError() {
+		return fmt.Errorf("cancel task: response: %s", res.String())
+	}
+
+	return nil
+}
+
+// decodeTaskID reads the id of a task started with wait_for_completion=false.
+func decodeTaskID(op string, res *esapi.Response) (string, error) {
+	if res.StatusCode == 400 {
+		return "", fmt.Errorf("%s: %w: %s", op, model.ErrInvalidQuery, res.String())
+	}
+
+	if res.IsError() {
+		return "", fmt.Errorf("%s: response: %s", op, res.String())
+	}
+
+	var body struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("%s: decode: %w", op, err)
+	}
+
+	return body.Task, nil
+}
@@ -0,0 +1,151 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"elastic-project/model"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"time"
+)
+
+// excludeDeleted narrows query to users that are not soft deleted. A nil
+// query stands for every user.
+func excludeDeleted(query interface{}) map[string]interface{} {
+	filter := map[string]interface{}{
+		"must_not": []interface{}{
+			map[string]interface{}{"exists": map[string]interface{}{"field": "deleted_at"}},
+		},
+	}
+	if query != nil {
+		filter["must"] = []interface{}{query}
+	}
+	return map[string]interface{}{"bool": filter}
+}
+
+// SoftDelete marks the user as deleted instead of removing it. Searches and
+// FindOne leave it out from then on, unless asked to include deleted users.
+func (p UserInfoStorage) SoftDelete(ctx context.Context, id string, version *Version) error {
+	now := time.Now().UTC()
+	return p.setDeleted(ctx, id, version, &now, HistoryDelete)
+}
+
+// Undelete clears deleted_at of a soft deleted user. It returns
+// model.ErrConflict if the user is not deleted.
+func (p UserInfoStorage) Undelete(ctx context.Context, id string) error {
+	current, err := p.FindOne(ctx, id, true)
+	if err != nil {
+		return err
+	}
+	if current.DeletedAt == nil {
+		return model.ErrConflict
+	}
+
+	return p.setDeleted(ctx, id, current.Version, nil, HistoryRestore)
+}
+
+// PurgeDeleted removes the users soft deleted before the given time and
+// returns how many were removed. Their history is kept.
+func (p UserInfoStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	bdy, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{
+				"deleted_at": map[string]interface{}{"lt": before.UTC().Format(time.RFC3339Nano)},
+			},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("purge deleted: marshall: %w", err)
+	}
+
+	es := p.elastic.client
+	res, err := es.DeleteByQuery([]string{p.elastic.alias}, bytes.NewReader(bdy),
+		es.DeleteByQuery.WithContext(ctx),
+		es.DeleteByQuery.WithConflicts("proceed"),
+		es.DeleteByQuery.WithWaitForCompletion(true),
+		es.DeleteByQuery.WithRefresh(true),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("purge deleted: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, fmt.Errorf("purge deleted: response: %s", res.String())
+	}
+
+	var body struct {
+		Deleted  int64             `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("purge deleted: decode: %w", err)
+	}
+	if len(body.Failures) > 0 {
+		return body.Deleted, fmt.Errorf("purge deleted: %d failures, first: %s", len(body.Failures), body.Failures[0])
+	}
+
+	return body.Deleted, nil
+}
+
+// setDeleted sets or, for a nil deletedAt, clears deleted_at. Like Update it
+// stores the revision it replaces in the history first.
+func (p UserInfoStorage) setDeleted(ctx context.Context, id string, version *Version, deletedAt *time.Time, action string) error {
+	previous, err := p.findPrevious(ctx, id, version, deletedAt == nil)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	bdy, err := json.Marshal(map[string]interface{}{
+		"doc": map[string]interface{}{
+			"deleted_at": deletedAt,
+			"updated_at": now,
+			"updated_by": model.ActorFromContext(ctx),
+			"revision":   previous.CurrentRevision() + 1,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("set deleted: marshall: %w", err)
+	}
+
+	req := esapi.UpdateRequest{
+		Index:         p.elastic.alias,
+		DocumentID:    id,
+		Body:          bytes.NewReader(bdy),
+		IfSeqNo:       &previous.Version.SeqNo,
+		IfPrimaryTerm: &previous.Version.PrimaryTerm,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	if err := p.elastic.saveHistory(ctx, previous, action); err != nil {
+		return err
+	}
+
+	res, err := req.Do(ctx, p.elastic.client)
+	if err != nil {
+		return fmt.Errorf("set deleted: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if writeBlocked(res) {
+		return model.ErrWriteBlocked
+	}
+
+	if res.StatusCode == 404 {
+		return model.ErrNotFound
+	}
+
+	if res.StatusCode == 409 {
+		return model.ErrConflict
+	}
+
+	if res.IsError() {
+		return fmt.Errorf("set deleted: response: %s", res.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// QueryValidation is the outcome of _validate/query. Explanation holds the
+// reason when the query is not valid.
+type QueryValidation struct {
+	Valid       bool
+	Explanation string
+}
+
+func (p UserInfoStorage) ValidateQuery(query map[string]interface{}) (QueryValidation, error) {
+	bdy, err := json.Marshal(map[string]interface{}{"query": query})
+	if err != nil {
+		return QueryValidation{}, fmt.Errorf("validate query: marshall: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	es := p.elastic.client
+	res, err := es.Indices.ValidateQuery(
+		es.Indices.ValidateQuery.WithContext(ctx),
+		es.Indices.ValidateQuery.WithIndex(p.elastic.alias),
+		es.Indices.ValidateQuery.WithBody(bytes.NewReader(bdy)),
+		es.Indices.ValidateQuery.WithExplain(true),
+	)
+	if err != nil {
+		return QueryValidation{}, fmt.Errorf("validate query: request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return QueryValidation{}, fmt.Errorf("validate query: response: %s", res.String())
+	}
+
+	var body struct {
+		Valid        bool `json:"valid"`
+		Explanations []struct {
+			Valid bool   `json:"valid"`
+			Error string `json:"error"`
+		} `json:"explanations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return QueryValidation{}, fmt.Errorf("validate query: decode: %w", err)
+	}
+
+	validation := QueryValidation{Valid: body.Valid}
+	for _, explanation := range body.Explanations {
+		if !explanation.Valid && explanation.Error != "" {
+			validation.Explanation = explanation.Error
+			break
+		}
+	}
+
+	return validation, nil
+}
@@ -2,11 +2,13 @@ package rest
 
 import (
 	"elastic-project/application/elastic_operation"
+	"elastic-project/application/kql"
 	"elastic-project/interface/rest/helper"
 	"elastic-project/model"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"log"
 	"net/http"
 )
 
@@ -17,10 +19,23 @@ type elasticsearchEndpoint struct {
 type ElasticsearchEndpoint interface {
 	Create() gin.HandlerFunc
 	Update() gin.HandlerFunc
+	Patch() gin.HandlerFunc
+	AddChild() gin.HandlerFunc
+	RemoveChild() gin.HandlerFunc
 	Find() gin.HandlerFunc
 	Delete() gin.HandlerFunc
+	Restore() gin.HandlerFunc
 	FindByKeyAndValue() gin.HandlerFunc
 	FindByJsonQuery() gin.HandlerFunc
+	Search() gin.HandlerFunc
+	SearchUsers() gin.HandlerFunc
+	Bulk() gin.HandlerFunc
+	UpdateByQuery() gin.HandlerFunc
+	DeleteByQuery() gin.HandlerFunc
+	Export() gin.HandlerFunc
+	Facets() gin.HandlerFunc
+	SearchFacets() gin.HandlerFunc
+	Suggest() gin.HandlerFunc
 }
 
 func NewElasticsearchEndpoint(elasticsearchService elastic_operation.Service) ElasticsearchEndpoint {
@@ -34,6 +49,8 @@ func NewElasticsearchEndpoint(elasticsearchService elastic_operation.Service) El
 // @Accept json
 // @Param body body model.CreateRequest true "CreateRequest"
 // @Success 201
+// @Failure 400 {object} model.ErrorDto "name or job is missing"
+// @Failure 503 {object} model.ErrorDto "a reindex blocks writes for a moment"
 // @Router /users [post]
 func (endpoint *elasticsearchEndpoint) Create() gin.HandlerFunc {
 	return func(context *gin.Context) {
@@ -50,6 +67,9 @@ func (endpoint *elasticsearchEndpoint) Create() gin.HandlerFunc {
 		createResponse, err := endpoint.elasticsearchService.Create(context, requestBody)
 
 		if err != nil {
+			if helper.HandleWriteBlocked(context, err) {
+				return
+			}
 			helper.HandleEndpointError(context, &model.ResponseError{
 				StatusCode: 500,
 				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
@@ -65,12 +85,15 @@ func (endpoint *elasticsearchEndpoint) Create() gin.HandlerFunc {
 
 // Update godoc
 // @Summary update user
-// @Description update user
+// @Description replaces name, job, childNames and comment of the user; fields missing from the body are cleared. Use PATCH to change single fields.
 // @Tags elastic
 // @Accept json
 // @Param id path string true "id"
+// @Param If-Match header string false "ETag returned by GET /users"
 // @Param body body model.UpdateRequest true "UpdateRequest"
 // @Success 204
+// @Failure 412 {object} model.ErrorDto
+// @Failure 503 {object} model.ErrorDto "a reindex blocks writes for a moment"
 // @Router /users/{id} [put]
 func (endpoint *elasticsearchEndpoint) Update() gin.HandlerFunc {
 	return func(context *gin.Context) {
@@ -93,12 +116,91 @@ func (endpoint *elasticsearchEndpoint) Update() gin.HandlerFunc {
 			return
 		}
 
+		requestBody.Version = helper.ParseIfMatch(context.GetHeader("If-Match"))
+
 		err := endpoint.elasticsearchService.Update(context, userId, requestBody)
 
 		if err != nil {
+			if helper.HandleWriteBlocked(context, err) {
+				return
+			}
 			statusCode := http.StatusInternalServerError
 			if model.ErrConflict == err {
 				statusCode = http.StatusConflict
+				if requestBody.Version != "" {
+					statusCode = http.StatusPreconditionFailed
+				}
+			}
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.Status(model.StatusNoContent)
+	}
+}
+
+// Patch godoc
+// @Summary patch user
+// @Description changes only the fields named in the patch, unlike PUT which replaces the user. Accepts a JSON Merge Patch (RFC 7396, application/merge-patch+json), e.g. {"comment":null}, or a JSON Patch (RFC 6902, application/json-patch+json), e.g. [{"op":"add","path":"/childNames/-","value":"Ali"}], over name, job, childNames and comment. The patch applies atomically: it is applied to the stored user and written only if the user did not change meanwhile.
+// @Tags elastic
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Param id path string true "id"
+// @Param If-Match header string false "ETag returned by GET /users"
+// @Param body body object true "merge patch or JSON patch"
+// @Success 204
+// @Failure 400 {object} model.ErrorDto
+// @Failure 404 {object} model.ErrorDto
+// @Failure 409 {object} model.ErrorDto
+// @Failure 412 {object} model.ErrorDto
+// @Failure 415 {object} model.ErrorDto
+// @Failure 422 {object} model.ErrorDto "a test failed, a path does not exist or the patched user is invalid"
+// @Failure 503 {object} model.ErrorDto "a reindex blocks writes for a moment"
+// @Router /users/{id} [patch]
+func (endpoint *elasticsearchEndpoint) Patch() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		patch, err := context.GetRawData()
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		request := model.PatchRequest{
+			ContentType: context.ContentType(),
+			Patch:       patch,
+			Version:     helper.ParseIfMatch(context.GetHeader("If-Match")),
+		}
+
+		err = endpoint.elasticsearchService.Patch(context, context.Param("id"), request)
+
+		if err != nil {
+			if helper.HandleWriteBlocked(context, err) {
+				return
+			}
+			statusCode := http.StatusInternalServerError
+			switch {
+			case model.ErrConflict == err:
+				statusCode = http.StatusConflict
+				if request.Version != "" {
+					statusCode = http.StatusPreconditionFailed
+				}
+			case model.ErrNotFound == err:
+				statusCode = http.StatusNotFound
+			case model.ErrUnsupportedPatch == err:
+				statusCode = http.StatusUnsupportedMediaType
+			case errors.Is(err, model.ErrInvalidPatch):
+				statusCode = http.StatusBadRequest
+			case errors.Is(err, model.ErrPatchFailed):
+				statusCode = http.StatusUnprocessableEntity
 			}
 			helper.HandleEndpointError(context, &model.ResponseError{
 				StatusCode: statusCode,
@@ -111,24 +213,157 @@ func (endpoint *elasticsearchEndpoint) Update() gin.HandlerFunc {
 	}
 }
 
+// AddChild godoc
+// @Summary add child name
+// @Description adds one name to the childNames of the user without rewriting the rest, so concurrent changes are kept. Adding a name that is already present changes nothing.
+// @Tags elastic
+// @Accept json
+// @Param id path string true "id"
+// @Param body body model.ChildRequest true "ChildRequest"
+// @Success 201 "added"
+// @Success 204 "already present"
+// @Failure 404 {object} model.ErrorDto
+// @Failure 503 {object} model.ErrorDto "a reindex blocks writes for a moment"
+// @Router /users/{id}/children [post]
+func (endpoint *elasticsearchEndpoint) AddChild() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var requestBody model.ChildRequest
+
+		if err := context.BindJSON(&requestBody); err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		added, err := endpoint.elasticsearchService.AddChild(context, context.Param("id"), requestBody)
+
+		if err != nil {
+			handleChildError(context, err)
+			return
+		}
+
+		if added {
+			context.Status(http.StatusCreated)
+			return
+		}
+		context.Status(model.StatusNoContent)
+	}
+}
+
+// RemoveChild godoc
+// @Summary remove child name
+// @Description removes one name from the childNames of the user without rewriting the rest. Removing a name that is not present changes nothing.
+// @Tags elastic
+// @Param id path string true "id"
+// @Param name path string true "child name"
+// @Success 204
+// @Failure 404 {object} model.ErrorDto
+// @Failure 503 {object} model.ErrorDto "a reindex blocks writes for a moment"
+// @Router /users/{id}/children/{name} [delete]
+func (endpoint *elasticsearchEndpoint) RemoveChild() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		_, err := endpoint.elasticsearchService.RemoveChild(context, context.Param("id"), context.Param("name"))
+
+		if err != nil {
+			handleChildError(context, err)
+			return
+		}
+
+		context.Status(model.StatusNoContent)
+	}
+}
+
+func handleChildError(context *gin.Context, err error) {
+	if helper.HandleWriteBlocked(context, err) {
+		return
+	}
+
+	statusCode := http.StatusInternalServerError
+	if model.ErrNotFound == err {
+		statusCode = http.StatusNotFound
+	}
+	if model.ErrConflict == err {
+		statusCode = http.StatusConflict
+	}
+	helper.HandleEndpointError(context, &model.ResponseError{
+		StatusCode: statusCode,
+		Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+	})
+}
+
 // Delete godoc
 // @Summary delete user
 // @Description delete user
 // @Tags elastic
 // @Accept json
 // @Param id path string true "id"
+// @Param If-Match header string false "ETag returned by GET /users"
 // @Success 204
+// @Failure 412 {object} model.ErrorDto
+// @Failure 503 {object} model.ErrorDto "a reindex blocks writes for a moment"
 // @Router /users/{id} [delete]
 func (endpoint *elasticsearchEndpoint) Delete() gin.HandlerFunc {
 	return func(context *gin.Context) {
 
 		idParam := context.Param("id")
+		version := helper.ParseIfMatch(context.GetHeader("If-Match"))
 
-		err := endpoint.elasticsearchService.Delete(context, model.DeleteRequest{ID: idParam})
+		err := endpoint.elasticsearchService.Delete(context, model.DeleteRequest{ID: idParam, Version: version})
 
 		if err != nil {
+			if helper.HandleWriteBlocked(context, err) {
+				return
+			}
+			statusCode := http.StatusInternalServerError
+			if model.ErrConflict == err {
+				statusCode = http.StatusConflict
+				if version != "" {
+					statusCode = http.StatusPreconditionFailed
+				}
+			}
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
 			helper.HandleEndpointError(context, &model.ResponseError{
-				StatusCode: http.StatusInternalServerError,
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.Status(model.StatusNoContent)
+	}
+}
+
+// Restore godoc
+// @Summary restore user
+// @Description brings back a soft deleted user
+// @Tags elastic
+// @Param id path string true "id"
+// @Success 204
+// @Failure 404 {object} model.ErrorDto
+// @Failure 409 {object} model.ErrorDto "user is not deleted"
+// @Failure 503 {object} model.ErrorDto "a reindex blocks writes for a moment"
+// @Router /users/{id}/restore [post]
+func (endpoint *elasticsearchEndpoint) Restore() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		err := endpoint.elasticsearchService.Restore(context, context.Param("id"))
+
+		if err != nil {
+			if helper.HandleWriteBlocked(context, err) {
+				return
+			}
+			statusCode := http.StatusInternalServerError
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
+			if model.ErrConflict == err {
+				statusCode = http.StatusConflict
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
 				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
 			})
 			return
@@ -144,35 +379,69 @@ func (endpoint *elasticsearchEndpoint) Delete() gin.HandlerFunc {
 // @Tags elastic
 // @Accept json
 // @Param id query string true "id"
+// @Param include_deleted query bool false "also return a soft deleted user"
 // @Success 200 {object} model.FindResponse
+// @Failure 404 {object} model.ErrorDto
+// @Header 200 {string} ETag "version token for If-Match"
 // @Router /users [get]
 func (endpoint *elasticsearchEndpoint) Find() gin.HandlerFunc {
 	return func(context *gin.Context) {
 		idParam := context.Query("id")
 
-		response, err := endpoint.elasticsearchService.Find(context, model.FindRequest{ID: idParam})
+		includeDeleted, err := helper.ParseIncludeDeleted(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		response, err := endpoint.elasticsearchService.Find(context, model.FindRequest{ID: idParam, IncludeDeleted: includeDeleted})
 
 		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
 			helper.HandleEndpointError(context, &model.ResponseError{
-				StatusCode: http.StatusInternalServerError,
+				StatusCode: statusCode,
 				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
 			})
 			return
 		}
 
+		if response.Version != "" {
+			context.Header("ETag", helper.FormatETag(response.Version))
+		}
 		context.JSON(http.StatusOK, response)
 	}
 }
 
 // FindByKeyAndValue godoc
 // @Summary gets user list
-// @Description gets user list
+// @Description gets user list, paged either by page/size or by the opaque cursor of the previous response. Conditions are given as queryType/key/value and as repeated must, should, must_not and filter parameters of the form queryType:key:value, e.g. must=match:job:engineer&must=fuzzy:name:mehmet; they are combined into a bool query.
 // @Tags elastic
 // @Accept json
-// @Param queryType query string true "queryType" Enums(match, wildcard, match_phrase_prefix, regexp, fuzzy) default(match)
-// @Param key query string true "key"
-// @Param value query string true "value"
-// @Success 200 {object} []model.FindResponse
+// @Param queryType query string false "queryType" Enums(match, match_phrase, match_phrase_prefix, wildcard, regexp, fuzzy, prefix, term) default(match)
+// @Param key query string false "key"
+// @Param value query string false "value"
+// @Param must query []string false "must conditions, queryType:key:value" collectionFormat(multi)
+// @Param should query []string false "should conditions, queryType:key:value" collectionFormat(multi)
+// @Param must_not query []string false "must_not conditions, queryType:key:value" collectionFormat(multi)
+// @Param filter query []string false "filter conditions, queryType:key:value" collectionFormat(multi)
+// @Param minimumShouldMatch query string false "minimum_should_match of the should conditions, e.g. 1 or 50%"
+// @Param page query int false "page" default(1)
+// @Param size query int false "size" default(10)
+// @Param cursor query string false "next_cursor of the previous response"
+// @Param highlight query bool false "return highlighted fragments of name, job, comment and childNames"
+// @Param highlightPreTag query string false "tag before a highlighted term" default(<em>)
+// @Param highlightPostTag query string false "tag after a highlighted term" default(</em>)
+// @Param fragmentSize query int false "highlighted fragment size in characters" default(100)
+// @Param include_deleted query bool false "also return soft deleted users"
+// @Success 200 {object} model.FindListResponse
+// @Failure 400 {object} model.ErrorDto
+// @Header 200 {string} Link "RFC 8288 pagination links"
 // @Router /users-by [get]
 func (endpoint *elasticsearchEndpoint) FindByKeyAndValue() gin.HandlerFunc {
 	return func(context *gin.Context) {
@@ -180,33 +449,272 @@ func (endpoint *elasticsearchEndpoint) FindByKeyAndValue() gin.HandlerFunc {
 		keyParam := context.Query("key")
 		valueParam := context.Query("value")
 
-		response, err := endpoint.elasticsearchService.FindByKeyAndValue(model.FindByRequest{QueryType: queryTypeParam, Key: keyParam, Value: valueParam})
+		conditions, err := helper.ParseConditions(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		pageRequest, err := helper.ParsePageRequest(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
 
+		highlightRequest, err := helper.ParseHighlightRequest(context)
 		if err != nil {
 			helper.HandleEndpointError(context, &model.ResponseError{
-				StatusCode: http.StatusInternalServerError,
+				StatusCode: http.StatusBadRequest,
 				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
 			})
 			return
 		}
 
+		includeDeleted, err := helper.ParseIncludeDeleted(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		response, err := endpoint.elasticsearchService.FindByKeyAndValue(model.FindByRequest{
+			QueryType:          queryTypeParam,
+			Key:                keyParam,
+			Value:              valueParam,
+			Conditions:         conditions,
+			MinimumShouldMatch: context.Query("minimumShouldMatch"),
+			IncludeDeleted:     includeDeleted,
+			PageRequest:        pageRequest,
+			HighlightRequest:   highlightRequest,
+		})
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: searchErrorStatusCode(err),
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.Header("Link", helper.PaginationLinks(context.Request.URL, response))
 		context.JSON(http.StatusOK, response)
 	}
 }
 
 // FindByJsonQuery godoc
 // @Summary gets user list with query
-// @Description gets user list with query
+// @Description gets user list with query, paged either by page/size or by the opaque cursor of the previous response. The body may only hold query, sort, _source, size, min_score and track_scores; script, function_score, query_string and similar clauses, leading wildcards, oversized queries and script sorts are rejected with 400.
 // @Tags elastic
 // @Accept json
 // @Param jsonQuery query string true "jsonQuery"
-// @Success 200 {object} []model.FindResponse
+// @Param page query int false "page" default(1)
+// @Param size query int false "size" default(10)
+// @Param cursor query string false "next_cursor of the previous response"
+// @Param highlight query bool false "return highlighted fragments of name, job, comment and childNames"
+// @Param highlightPreTag query string false "tag before a highlighted term" default(<em>)
+// @Param highlightPostTag query string false "tag after a highlighted term" default(</em>)
+// @Param fragmentSize query int false "highlighted fragment size in characters" default(100)
+// @Param include_deleted query bool false "also return soft deleted users"
+// @Success 200 {object} model.FindListResponse
+// @Failure 400 {object} model.ErrorDto
+// @Header 200 {string} Link "RFC 8288 pagination links"
+// @Deprecated
 // @Router /users-by-query [get]
 func (endpoint *elasticsearchEndpoint) FindByJsonQuery() gin.HandlerFunc {
 	return func(context *gin.Context) {
 		jsonQueryParam := context.Query("jsonQuery")
 
-		response, err := endpoint.elasticsearchService.FindByQuery(jsonQueryParam)
+		pageRequest, err := helper.ParsePageRequest(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		highlightRequest, err := helper.ParseHighlightRequest(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		includeDeleted, err := helper.ParseIncludeDeleted(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		response, err := endpoint.elasticsearchService.FindByQuery(model.FindByQueryRequest{Query: jsonQueryParam, IncludeDeleted: includeDeleted, PageRequest: pageRequest, HighlightRequest: highlightRequest})
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: searchErrorStatusCode(err),
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.Header("Link", helper.PaginationLinks(context.Request.URL, response))
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// Search godoc
+// @Summary searches users with a query string
+// @Description searches users with a KQL-like query, e.g. job:engineer and (name:meh* or comment:"team lead"). Fields: id, name, job, comment, childNames, created_at, updated_at, created_by, updated_by. Operators: and, or, not, parentheses, field:(a or b), field:* and <, <=, >, >= on created_at and updated_at, e.g. created_at >= 2022-01-31T10:00:00Z. Values must not start with * or ?. Parse errors return 400 with the 1-based position.
+// @Tags elastic
+// @Accept json
+// @Param q query string true "query"
+// @Param page query int false "page" default(1)
+// @Param size query int false "size" default(10)
+// @Param cursor query string false "next_cursor of the previous response"
+// @Param highlight query bool false "return highlighted fragments of name, job, comment and childNames"
+// @Param highlightPreTag query string false "tag before a highlighted term" default(<em>)
+// @Param highlightPostTag query string false "tag after a highlighted term" default(</em>)
+// @Param fragmentSize query int false "highlighted fragment size in characters" default(100)
+// @Param include_deleted query bool false "also return soft deleted users"
+// @Success 200 {object} model.FindListResponse
+// @Failure 400 {object} model.ErrorDto
+// @Header 200 {string} Link "RFC 8288 pagination links"
+// @Router /users/search [get]
+func (endpoint *elasticsearchEndpoint) Search() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		pageRequest, err := helper.ParsePageRequest(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		highlightRequest, err := helper.ParseHighlightRequest(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		includeDeleted, err := helper.ParseIncludeDeleted(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		request := model.SearchQueryRequest{Query: context.Query("q"), IncludeDeleted: includeDeleted, PageRequest: pageRequest, HighlightRequest: highlightRequest}
+		response, err := endpoint.elasticsearchService.Search(request)
+
+		var syntaxErr *kql.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			context.JSON(http.StatusBadRequest, model.ErrorDto{
+				Message:  fmt.Sprintf("invalid query: Error: %v", syntaxErr.Message),
+				Position: syntaxErr.Position,
+			})
+			return
+		}
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: searchErrorStatusCode(err),
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.Header("Link", helper.PaginationLinks(context.Request.URL, response))
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// SearchUsers godoc
+// @Summary searches users with a structured request
+// @Description searches users with a free text query over name, job, comment and childNames, narrowed by filters. A filter sets exactly one of term, terms, range, exists or prefix; term, terms, range and prefix work on id, name, job, childNames, created_at, updated_at, created_by and updated_by, prefix not on dates, and exists on every field. Sortable fields are _score, id, name, job, created_at, updated_at, created_by and updated_by. Paged either by page/size or by the next_cursor of the previous response, given in the body or in the query string; the links of the Link header are to be posted with the same body.
+// @Tags elastic
+// @Accept json
+// @Produce json
+// @Param body body model.SearchRequest true "SearchRequest"
+// @Param page query int false "page, overrides the body"
+// @Param size query int false "size, overrides the body"
+// @Param cursor query string false "next_cursor of the previous response, overrides the body"
+// @Success 200 {object} model.FindListResponse
+// @Failure 400 {object} model.ErrorDto
+// @Header 200 {string} Link "RFC 8288 pagination links"
+// @Router /users/_search [post]
+func (endpoint *elasticsearchEndpoint) SearchUsers() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var requestBody model.SearchRequest
+
+		if err := context.BindJSON(&requestBody); err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		// page, size and cursor in the query string override the body, so the
+		// Link header can be followed by posting the same body again.
+		pageRequest, err := helper.ParsePageRequest(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+		if pageRequest.Page != 0 || pageRequest.Cursor != "" {
+			requestBody.Page, requestBody.Cursor = pageRequest.Page, pageRequest.Cursor
+		}
+		if pageRequest.Size != 0 {
+			requestBody.Size = pageRequest.Size
+		}
+
+		response, err := endpoint.elasticsearchService.SearchUsers(requestBody)
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: searchErrorStatusCode(err),
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.Header("Link", helper.PaginationLinks(context.Request.URL, response))
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// Bulk godoc
+// @Summary bulk create, index, update and delete users
+// @Description streams Elasticsearch style NDJSON actions through the bulk API. Action lines are {"create":{}}, {"index":{"_id":"..."}}, {"update":{"_id":"..."}} or {"delete":{"_id":"..."}}; create and index are followed by a CreateRequest line, update by {"doc":{...}} with the name, job, childNames or comment to change; fields left out are kept. Ids are generated when omitted. With -soft-delete, delete marks the user as deleted. A create or index document without name or job, or an update doc with other fields, wrong types or an empty name or job, is reported as a 400 item. An update of a deleted user is a noop. Index keeps the creation time and actor of a user it overwrites. Bulk actions do not record previous revisions in the history.
+// @Tags elastic
+// @Accept x-ndjson
+// @Param body body string true "NDJSON actions"
+// @Success 200 {object} model.BulkResponse
+// @Router /users/_bulk [post]
+func (endpoint *elasticsearchEndpoint) Bulk() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		response, err := endpoint.elasticsearchService.Bulk(context, context.Request.Body)
 
 		if err != nil {
 			helper.HandleEndpointError(context, &model.ResponseError{
@@ -219,3 +727,391 @@ func (endpoint *elasticsearchEndpoint) FindByJsonQuery() gin.HandlerFunc {
 		context.JSON(http.StatusOK, response)
 	}
 }
+
+// UpdateByQuery godoc
+// @Summary update all matching users
+// @Description sets name, job, comment or childNames on every user matching the query and filters, which work like in POST /users/_search; at least one of them is required. With dry_run=true nothing changes and the response holds the number of matching users and a sample of them. requests_per_second throttles the update. Previous revisions are not recorded in the history.
+// @Tags elastic
+// @Accept json
+// @Produce json
+// @Param body body model.UpdateByQueryRequest true "UpdateByQueryRequest"
+// @Param dry_run query bool false "only count and sample the matching users"
+// @Param requests_per_second query int false "throttle, in documents per second"
+// @Param wait_for_completion query bool false "false returns a task to follow at /tasks/{id} right away"
+// @Success 200 {object} model.ByQueryResponse
+// @Success 202 {object} model.TaskResponse
+// @Failure 400 {object} model.ErrorDto
+// @Failure 501 {object} model.ErrorDto "wait_for_completion=false with the in-memory storage"
+// @Router /users/_update_by_query [post]
+func (endpoint *elasticsearchEndpoint) UpdateByQuery() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var requestBody model.UpdateByQueryRequest
+
+		if err := context.BindJSON(&requestBody); err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		dryRun, requestsPerSecond, err := helper.ParseByQueryOptions(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+		requestBody.DryRun = dryRun
+		requestBody.RequestsPerSecond = requestsPerSecond
+
+		wait, err := helper.ParseWaitForCompletion(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		if !wait && !dryRun {
+			task, err := endpoint.elasticsearchService.StartUpdateByQuery(context, requestBody)
+			if err != nil {
+				helper.HandleEndpointError(context, &model.ResponseError{
+					StatusCode: searchErrorStatusCode(err),
+					Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+				})
+				return
+			}
+
+			context.JSON(http.StatusAccepted, task)
+			return
+		}
+
+		response, err := endpoint.elasticsearchService.UpdateByQuery(context, requestBody)
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: searchErrorStatusCode(err),
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// DeleteByQuery godoc
+// @Summary delete all matching users
+// @Description deletes every user matching the query and filters, which work like in POST /users/_search; at least one of them is required. With soft delete the users are marked as deleted. With dry_run=true nothing changes and the response holds the number of matching users and a sample of them. requests_per_second throttles the deletion. Neither deletions nor soft deletions are recorded in the history.
+// @Tags elastic
+// @Accept json
+// @Produce json
+// @Param body body model.DeleteByQueryRequest true "DeleteByQueryRequest"
+// @Param dry_run query bool false "only count and sample the matching users"
+// @Param requests_per_second query int false "throttle, in documents per second"
+// @Param wait_for_completion query bool false "false returns a task to follow at /tasks/{id} right away"
+// @Success 200 {object} model.ByQueryResponse
+// @Success 202 {object} model.TaskResponse
+// @Failure 400 {object} model.ErrorDto
+// @Failure 501 {object} model.ErrorDto "wait_for_completion=false with the in-memory storage"
+// @Router /users/_delete_by_query [post]
+func (endpoint *elasticsearchEndpoint) DeleteByQuery() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var requestBody model.DeleteByQueryRequest
+
+		if err := context.BindJSON(&requestBody); err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		dryRun, requestsPerSecond, err := helper.ParseByQueryOptions(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+		requestBody.DryRun = dryRun
+		requestBody.RequestsPerSecond = requestsPerSecond
+
+		wait, err := helper.ParseWaitForCompletion(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		if !wait && !dryRun {
+			task, err := endpoint.elasticsearchService.StartDeleteByQuery(context, requestBody)
+			if err != nil {
+				helper.HandleEndpointError(context, &model.ResponseError{
+					StatusCode: searchErrorStatusCode(err),
+					Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+				})
+				return
+			}
+
+			context.JSON(http.StatusAccepted, task)
+			return
+		}
+
+		response, err := endpoint.elasticsearchService.DeleteByQuery(context, requestBody)
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: searchErrorStatusCode(err),
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// Export godoc
+// @Summary exports users
+// @Description streams every user, or the users matching jsonQuery, as NDJSON or CSV. jsonQuery is a search body with a query member, checked like the one of /users-by-query.
+// @Tags elastic
+// @Produce x-ndjson,csv
+// @Param format query string false "format" Enums(ndjson, csv) default(ndjson)
+// @Param jsonQuery query string false "jsonQuery"
+// @Param include_deleted query bool false "also export soft deleted users"
+// @Success 200 "the connection is closed without completing the response if the export fails after the first user"
+// @Failure 400 {object} model.ErrorDto
+// @Router /users/_export [get]
+func (endpoint *elasticsearchEndpoint) Export() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		writer, err := helper.NewExportWriter(context.Query("format"), context.Writer)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		includeDeleted, err := helper.ParseIncludeDeleted(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		const flushEvery = 500
+		written := 0
+		request := model.ExportRequest{Format: context.Query("format"), Query: context.Query("jsonQuery"), IncludeDeleted: includeDeleted}
+
+		err = endpoint.elasticsearchService.Export(context.Request.Context(), request, func(response model.FindResponse) error {
+			if written == 0 {
+				context.Header("Content-Type", writer.ContentType())
+				context.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, context.DefaultQuery("format", helper.ExportFormatNDJSON)))
+				context.Status(http.StatusOK)
+			}
+			if err := writer.Write(response); err != nil {
+				return err
+			}
+			written++
+			if written%flushEvery == 0 {
+				if err := writer.Flush(); err != nil {
+					return err
+				}
+				context.Writer.Flush()
+			}
+			return nil
+		})
+
+		if err != nil && written == 0 {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: searchErrorStatusCode(err),
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+		if err != nil {
+			// The 200 and the first users are sent already; drop the connection
+			// so that the client does not take the file for complete.
+			log.Printf("export aborted after %d users: %v", written, err)
+			panic(http.ErrAbortHandler)
+		}
+
+		if written == 0 {
+			context.Header("Content-Type", writer.ContentType())
+			context.Status(http.StatusOK)
+		}
+		if err := writer.Flush(); err != nil {
+			log.Printf("export flush failed: %v", err)
+		}
+	}
+}
+
+// Facets godoc
+// @Summary gets user facets
+// @Description counts users per job, per created_at interval and per number of children, optionally filtered like /users-by with queryType/key/value and repeated must, should, must_not and filter conditions
+// @Tags elastic
+// @Accept json
+// @Param queryType query string false "queryType" Enums(match, match_phrase, match_phrase_prefix, wildcard, regexp, fuzzy, prefix, term)
+// @Param key query string false "key"
+// @Param value query string false "value"
+// @Param must query []string false "must conditions, queryType:key:value" collectionFormat(multi)
+// @Param should query []string false "should conditions, queryType:key:value" collectionFormat(multi)
+// @Param must_not query []string false "must_not conditions, queryType:key:value" collectionFormat(multi)
+// @Param filter query []string false "filter conditions, queryType:key:value" collectionFormat(multi)
+// @Param minimumShouldMatch query string false "minimum_should_match of the should conditions, e.g. 1 or 50%"
+// @Param interval query string false "created_at interval" Enums(day, week, month, quarter, year) default(week)
+// @Param jobSize query int false "number of job buckets" default(10)
+// @Param include_deleted query bool false "also count soft deleted users"
+// @Success 200 {object} model.FacetsResponse
+// @Failure 400 {object} model.ErrorDto
+// @Router /users/_facets [get]
+func (endpoint *elasticsearchEndpoint) Facets() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		conditions, err := helper.ParseConditions(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		includeDeleted, err := helper.ParseIncludeDeleted(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		request := model.FacetsRequest{
+			QueryType:          context.Query("queryType"),
+			Key:                context.Query("key"),
+			Value:              context.Query("value"),
+			Conditions:         conditions,
+			MinimumShouldMatch: context.Query("minimumShouldMatch"),
+			Interval:           context.Query("interval"),
+			IncludeDeleted:     includeDeleted,
+		}
+
+		if jobSizeParam := context.Query("jobSize"); jobSizeParam != "" {
+			jobSize, err := helper.ParseNumberParameter(jobSizeParam)
+			if err != nil {
+				helper.HandleEndpointError(context, &model.ResponseError{
+					StatusCode: http.StatusBadRequest,
+					Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+				})
+				return
+			}
+			request.JobSize = int(jobSize)
+		}
+
+		endpoint.facets(context, request)
+	}
+}
+
+// SearchFacets godoc
+// @Summary gets user facets for a structured search
+// @Description counts users per job, per created_at interval and per number of children among the users a POST /users/_search with the same query and filters finds
+// @Tags elastic
+// @Accept json
+// @Produce json
+// @Param body body model.FacetsRequest true "FacetsRequest"
+// @Success 200 {object} model.FacetsResponse
+// @Failure 400 {object} model.ErrorDto
+// @Router /users/_facets [post]
+func (endpoint *elasticsearchEndpoint) SearchFacets() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var requestBody model.FacetsRequest
+
+		if err := context.BindJSON(&requestBody); err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		endpoint.facets(context, requestBody)
+	}
+}
+
+func (endpoint *elasticsearchEndpoint) facets(context *gin.Context, request model.FacetsRequest) {
+	response, err := endpoint.elasticsearchService.Facets(context, request)
+
+	if err != nil {
+		helper.HandleEndpointError(context, &model.ResponseError{
+			StatusCode: searchErrorStatusCode(err),
+			Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+		})
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+// Suggest godoc
+// @Summary suggests user names or jobs
+// @Description returns distinct names or jobs starting with prefix, best match first
+// @Tags elastic
+// @Accept json
+// @Param field query string true "field" Enums(name, job) default(name)
+// @Param prefix query string true "prefix"
+// @Param job query string false "only suggest among users with this job"
+// @Param size query int false "size" default(10)
+// @Success 200 {object} []model.SuggestionResponse
+// @Router /users/_suggest [get]
+func (endpoint *elasticsearchEndpoint) Suggest() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		request := model.SuggestRequest{
+			Field:  context.DefaultQuery("field", "name"),
+			Prefix: context.Query("prefix"),
+			Job:    context.Query("job"),
+		}
+
+		if sizeParam := context.Query("size"); sizeParam != "" {
+			size, err := helper.ParseNumberParameter(sizeParam)
+			if err != nil {
+				helper.HandleEndpointError(context, &model.ResponseError{
+					StatusCode: http.StatusBadRequest,
+					Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+				})
+				return
+			}
+			request.Size = int(size)
+		}
+
+		response, err := endpoint.elasticsearchService.Suggest(context, request)
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: searchErrorStatusCode(err),
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+func searchErrorStatusCode(err error) int {
+	if model.ErrInvalidPage == err || model.ErrInvalidCursor == err || errors.Is(err, model.ErrInvalidQuery) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, model.ErrNotSupported) {
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
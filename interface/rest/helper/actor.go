@@ -0,0 +1,22 @@
+package helper
+
+import (
+	"elastic-project/model"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// ActorHeader carries the identity of the caller. Authentication happens in
+// front of this service, which trusts the header as is.
+const ActorHeader = "X-User-ID"
+
+// ActorMiddleware stores the caller identity in the request context, where
+// the services read it with model.ActorFromContext.
+func ActorMiddleware() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if actor := strings.TrimSpace(context.GetHeader(ActorHeader)); actor != "" {
+			context.Request = context.Request.WithContext(model.ContextWithActor(context.Request.Context(), actor))
+		}
+		context.Next()
+	}
+}
@@ -0,0 +1,31 @@
+package helper
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strconv"
+)
+
+// ParseByQueryOptions reads the dry_run and requests_per_second parameters of
+// the update and delete by query endpoints. requests_per_second is 0 when
+// missing, which leaves the operation unthrottled.
+func ParseByQueryOptions(context *gin.Context) (bool, int, error) {
+	dryRun := false
+	if dryRunParam := context.Query("dry_run"); dryRunParam != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(dryRunParam); err != nil {
+			return false, 0, fmt.Errorf("invalid dry_run: %w", err)
+		}
+	}
+
+	requestsPerSecond := 0
+	if requestsPerSecondParam := context.Query("requests_per_second"); requestsPerSecondParam != "" {
+		var err error
+		requestsPerSecond, err = strconv.Atoi(requestsPerSecondParam)
+		if err != nil || requestsPerSecond < 1 {
+			return false, 0, fmt.Errorf("invalid requests_per_second: must be a positive integer")
+		}
+	}
+
+	return dryRun, requestsPerSecond, nil
+}
@@ -0,0 +1,27 @@
+package helper
+
+import (
+	"elastic-project/model"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// ParseConditions reads the repeated must, should, must_not and filter
+// parameters. Each value has the form queryType:key:value, e.g.
+// must=fuzzy:name:mehmet; the value itself may contain colons.
+func ParseConditions(context *gin.Context) ([]model.Condition, error) {
+	var conditions []model.Condition
+
+	for _, occur := range []string{"must", "should", "must_not", "filter"} {
+		for _, param := range context.QueryArray(occur) {
+			parts := strings.SplitN(param, ":", 3)
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("invalid %s condition %q: expected queryType:key:value", occur, param)
+			}
+			conditions = append(conditions, model.Condition{Occur: occur, QueryType: parts[0], Key: parts[1], Value: parts[2]})
+		}
+	}
+
+	return conditions, nil
+}
@@ -2,9 +2,41 @@ package helper
 
 import (
 	"elastic-project/model"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"net/http"
 )
 
+// writeBlockedRetryAfter is the Retry-After, in seconds, of writes rejected
+// while a reindex blocks them.
+const writeBlockedRetryAfter = "5"
+
 func HandleEndpointError(context *gin.Context, responseError *model.ResponseError) {
 	context.JSON(responseError.StatusCode, model.ErrorDto{Message: responseError.Err.Error()})
 }
+
+// HandleWriteBlocked answers 503 with Retry-After if err is
+// model.ErrWriteBlocked, and reports whether it did.
+func HandleWriteBlocked(context *gin.Context, err error) bool {
+	if !errors.Is(err, model.ErrWriteBlocked) {
+		return false
+	}
+
+	context.Header("Retry-After", writeBlockedRetryAfter)
+	HandleEndpointError(context, &model.ResponseError{
+		StatusCode: http.StatusServiceUnavailable,
+		Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+	})
+	return true
+}
+
+// HandleRecovery answers 500 to a handler that panicked, like gin's default,
+// but lets http.ErrAbortHandler through so that net/http drops the connection
+// of a response that already started.
+func HandleRecovery(context *gin.Context, err interface{}) {
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	context.AbortWithStatus(http.StatusInternalServerError)
+}
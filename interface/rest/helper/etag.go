@@ -0,0 +1,21 @@
+package helper
+
+import (
+	"strings"
+)
+
+func FormatETag(version string) string {
+	return `"` + version + `"`
+}
+
+// ParseIfMatch returns the version token of an If-Match header, or "" when the
+// header is missing or matches any version.
+func ParseIfMatch(header string) string {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return ""
+	}
+
+	header = strings.TrimPrefix(header, "W/")
+	return strings.Trim(header, `"`)
+}
@@ -0,0 +1,101 @@
+package helper
+
+import (
+	"elastic-project/model"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	ExportFormatNDJSON = "ndjson"
+	ExportFormatCSV    = "csv"
+)
+
+type ExportWriter interface {
+	ContentType() string
+	Write(response model.FindResponse) error
+	Flush() error
+}
+
+func NewExportWriter(format string, w io.Writer) (ExportWriter, error) {
+	switch format {
+	case "", ExportFormatNDJSON:
+		return &ndjsonExportWriter{encoder: json.NewEncoder(w)}, nil
+	case ExportFormatCSV:
+		return &csvExportWriter{writer: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonExportWriter) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (w *ndjsonExportWriter) Write(response model.FindResponse) error {
+	return w.encoder.Encode(response)
+}
+
+func (w *ndjsonExportWriter) Flush() error {
+	return nil
+}
+
+type csvExportWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvExportWriter) ContentType() string {
+	return "text/csv"
+}
+
+func (w *csvExportWriter) writeHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	w.headerWritten = true
+	return w.writer.Write([]string{"id", "name", "job", "childNames", "comment", "created_at", "updated_at", "created_by", "updated_by"})
+}
+
+func (w *csvExportWriter) Write(response model.FindResponse) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	createdAt := ""
+	if response.CreatedAt != nil {
+		createdAt = response.CreatedAt.Format(time.RFC3339Nano)
+	}
+	updatedAt := ""
+	if response.UpdatedAt != nil {
+		updatedAt = response.UpdatedAt.Format(time.RFC3339Nano)
+	}
+
+	return w.writer.Write([]string{
+		response.ID,
+		response.Name,
+		response.Job,
+		strings.Join(response.ChildNames, "|"),
+		response.Comment,
+		createdAt,
+		updatedAt,
+		response.CreatedBy,
+		response.UpdatedBy,
+	})
+}
+
+func (w *csvExportWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
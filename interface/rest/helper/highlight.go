@@ -0,0 +1,33 @@
+package helper
+
+import (
+	"elastic-project/model"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strconv"
+)
+
+func ParseHighlightRequest(context *gin.Context) (model.HighlightRequest, error) {
+	var highlightRequest model.HighlightRequest
+
+	if highlightParam := context.Query("highlight"); highlightParam != "" {
+		highlight, err := strconv.ParseBool(highlightParam)
+		if err != nil {
+			return model.HighlightRequest{}, fmt.Errorf("invalid highlight: %w", err)
+		}
+		highlightRequest.Highlight = highlight
+	}
+
+	if fragmentSizeParam := context.Query("fragmentSize"); fragmentSizeParam != "" {
+		fragmentSize, err := ParseNumberParameter(fragmentSizeParam)
+		if err != nil {
+			return model.HighlightRequest{}, fmt.Errorf("invalid fragmentSize: %w", err)
+		}
+		highlightRequest.FragmentSize = int(fragmentSize)
+	}
+
+	highlightRequest.PreTag = context.Query("highlightPreTag")
+	highlightRequest.PostTag = context.Query("highlightPostTag")
+
+	return highlightRequest, nil
+}
@@ -0,0 +1,23 @@
+package helper
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strconv"
+)
+
+// ParseIncludeDeleted reads the include_deleted parameter, which also
+// returns soft deleted users.
+func ParseIncludeDeleted(context *gin.Context) (bool, error) {
+	includeDeletedParam := context.Query("include_deleted")
+	if includeDeletedParam == "" {
+		return false, nil
+	}
+
+	includeDeleted, err := strconv.ParseBool(includeDeletedParam)
+	if err != nil {
+		return false, fmt.Errorf("invalid include_deleted: %w", err)
+	}
+
+	return includeDeleted, nil
+}
@@ -0,0 +1,68 @@
+package helper
+
+import (
+	"elastic-project/model"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func ParsePageRequest(context *gin.Context) (model.PageRequest, error) {
+	var pageRequest model.PageRequest
+
+	if pageParam := context.Query("page"); pageParam != "" {
+		page, err := ParseNumberParameter(pageParam)
+		if err != nil {
+			return model.PageRequest{}, fmt.Errorf("invalid page: %w", err)
+		}
+		pageRequest.Page = int(page)
+	}
+
+	if sizeParam := context.Query("size"); sizeParam != "" {
+		size, err := ParseNumberParameter(sizeParam)
+		if err != nil {
+			return model.PageRequest{}, fmt.Errorf("invalid size: %w", err)
+		}
+		pageRequest.Size = int(size)
+	}
+
+	pageRequest.Cursor = context.Query("cursor")
+
+	return pageRequest, nil
+}
+
+// PaginationLinks builds an RFC 8288 Link header value for a list response,
+// keeping the rest of the query string of the current request.
+func PaginationLinks(requestURL *url.URL, response model.FindListResponse) string {
+	var links []string
+
+	link := func(rel string, set map[string]string) {
+		query := requestURL.Query()
+		query.Del("page")
+		query.Del("cursor")
+		for key, value := range set {
+			query.Set(key, value)
+		}
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, requestURL.Path, query.Encode(), rel))
+	}
+
+	link("first", map[string]string{"page": "1"})
+
+	if response.Page > 0 {
+		if response.Page > 1 {
+			link("prev", map[string]string{"page": strconv.Itoa(response.Page - 1)})
+		}
+		if int64(response.Page*response.Size) < response.Total {
+			link("next", map[string]string{"page": strconv.Itoa(response.Page + 1)})
+		}
+		return strings.Join(links, ", ")
+	}
+
+	if response.NextCursor != "" {
+		link("next", map[string]string{"cursor": response.NextCursor})
+	}
+
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,24 @@
+package helper
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strconv"
+)
+
+// ParseWaitForCompletion reads the wait_for_completion parameter, true when
+// missing. With false, long running operations return a task instead of
+// their result.
+func ParseWaitForCompletion(context *gin.Context) (bool, error) {
+	waitParam := context.Query("wait_for_completion")
+	if waitParam == "" {
+		return true, nil
+	}
+
+	wait, err := strconv.ParseBool(waitParam)
+	if err != nil {
+		return false, fmt.Errorf("invalid wait_for_completion: %w", err)
+	}
+
+	return wait, nil
+}
@@ -0,0 +1,132 @@
+package rest
+
+import (
+	"elastic-project/application/history_operation"
+	"elastic-project/interface/rest/helper"
+	"elastic-project/model"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type historyEndpoint struct {
+	historyService history_operation.Service
+}
+
+type HistoryEndpoint interface {
+	History() gin.HandlerFunc
+	Revision() gin.HandlerFunc
+	Restore() gin.HandlerFunc
+}
+
+func NewHistoryEndpoint(historyService history_operation.Service) HistoryEndpoint {
+	return &historyEndpoint{historyService: historyService}
+}
+
+// History godoc
+// @Summary gets user history
+// @Description lists the previous revisions of a user, newest first, with the change that replaced each of them. Changes by PUT, PATCH and DELETE /users/{id}, the children endpoints and the restore endpoints are recorded; bulk actions, update and delete by query, soft deletes by query and imports are not. A revision is recorded before the change, so a change that then fails may leave an entry of the current revision.
+// @Tags history
+// @Param id path string true "id"
+// @Success 200 {array} model.HistoryResponse
+// @Router /users/{id}/history [get]
+func (endpoint *historyEndpoint) History() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		response, err := endpoint.historyService.History(context, context.Param("id"))
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusInternalServerError,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// Revision godoc
+// @Summary gets user revision
+// @Description gets a previous revision of a user
+// @Tags history
+// @Param id path string true "id"
+// @Param rev path int true "revision"
+// @Success 200 {object} model.HistoryResponse
+// @Failure 404 {object} model.ErrorDto
+// @Router /users/{id}/history/{rev} [get]
+func (endpoint *historyEndpoint) Revision() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		revision, err := helper.ParseNumberParameter(context.Param("rev"))
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		response, err := endpoint.historyService.Revision(context, context.Param("id"), int64(revision))
+
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// Restore godoc
+// @Summary restores user revision
+// @Description makes a previous revision the current user again. The user gets a new revision and the replaced one is kept in the history; a deleted user is recreated.
+// @Tags history
+// @Param id path string true "id"
+// @Param rev path int true "revision"
+// @Success 204
+// @Failure 404 {object} model.ErrorDto
+// @Failure 409 {object} model.ErrorDto
+// @Failure 503 {object} model.ErrorDto "a reindex blocks writes for a moment"
+// @Router /users/{id}/history/{rev}/restore [post]
+func (endpoint *historyEndpoint) Restore() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		revision, err := helper.ParseNumberParameter(context.Param("rev"))
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		err = endpoint.historyService.Restore(context, context.Param("id"), int64(revision))
+
+		if err != nil {
+			if helper.HandleWriteBlocked(context, err) {
+				return
+			}
+			statusCode := http.StatusInternalServerError
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
+			if model.ErrConflict == err {
+				statusCode = http.StatusConflict
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.Status(model.StatusNoContent)
+	}
+}
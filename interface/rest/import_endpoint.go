@@ -0,0 +1,157 @@
+package rest
+
+import (
+	"elastic-project/application/import_operation"
+	"elastic-project/interface/rest/helper"
+	"elastic-project/model"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type importEndpoint struct {
+	importService import_operation.Service
+}
+
+type ImportEndpoint interface {
+	Start() gin.HandlerFunc
+	Find() gin.HandlerFunc
+	ErrorReport() gin.HandlerFunc
+}
+
+func NewImportEndpoint(importService import_operation.Service) ImportEndpoint {
+	return &importEndpoint{importService: importService}
+}
+
+// Start godoc
+// @Summary import users
+// @Description uploads a CSV or NDJSON file of CreateRequest rows and imports it in the background
+// @Tags import
+// @Accept mpfd
+// @Param file formData file true "CSV (name,job,childNames,comment) or NDJSON file"
+// @Param format query string false "format, taken from the file extension when omitted" Enums(csv, ndjson)
+// @Success 202 {object} model.ImportJobResponse
+// @Header 202 {string} Location "import job"
+// @Router /users/_import [post]
+func (endpoint *importEndpoint) Start() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		fileHeader, err := context.FormFile("file")
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+		defer file.Close()
+
+		request := model.ImportRequest{Format: context.Query("format"), FileName: fileHeader.Filename}
+		response, err := endpoint.importService.Start(context, request, file)
+
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if model.ErrInvalidFormat == err {
+				statusCode = http.StatusBadRequest
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.Header("Location", "/imports/"+response.ID)
+		context.JSON(http.StatusAccepted, response)
+	}
+}
+
+// Find godoc
+// @Summary gets import job
+// @Description gets the progress of an import job
+// @Tags import
+// @Param id path string true "id"
+// @Success 200 {object} model.ImportJobResponse
+// @Router /imports/{id} [get]
+func (endpoint *importEndpoint) Find() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		response, err := endpoint.importService.Find(context, context.Param("id"))
+
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// ErrorReport godoc
+// @Summary gets import error report
+// @Description downloads the rejected rows of an import job as CSV (row,reason,raw)
+// @Tags import
+// @Produce csv
+// @Param id path string true "id"
+// @Success 200
+// @Router /imports/{id}/errors [get]
+func (endpoint *importEndpoint) ErrorReport() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		id := context.Param("id")
+		writer := csv.NewWriter(context.Writer)
+		written := 0
+
+		err := endpoint.importService.ErrorReport(context, id, func(row model.ImportErrorResponse) error {
+			if written == 0 {
+				context.Header("Content-Type", "text/csv")
+				context.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%s-errors.csv"`, id))
+				context.Status(http.StatusOK)
+				if err := writer.Write([]string{"row", "reason", "raw"}); err != nil {
+					return err
+				}
+			}
+			written++
+			return writer.Write([]string{strconv.Itoa(row.Row), row.Reason, row.Raw})
+		})
+
+		if err != nil && written == 0 {
+			statusCode := http.StatusInternalServerError
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("import %s error report aborted after %d rows: %v", id, written, err)
+		}
+
+		if written == 0 {
+			context.Header("Content-Type", "text/csv")
+			context.Status(http.StatusOK)
+			_ = writer.Write([]string{"row", "reason", "raw"})
+		}
+		writer.Flush()
+	}
+}
@@ -0,0 +1,134 @@
+package rest
+
+import (
+	"elastic-project/application/index_operation"
+	"elastic-project/interface/rest/helper"
+	"elastic-project/model"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type indexEndpoint struct {
+	indexService index_operation.Service
+}
+
+type IndexEndpoint interface {
+	Reindex() gin.HandlerFunc
+	Rollback() gin.HandlerFunc
+	BackfillAuditFields() gin.HandlerFunc
+}
+
+func NewIndexEndpoint(indexService index_operation.Service) IndexEndpoint {
+	return &indexEndpoint{indexService: indexService}
+}
+
+// Reindex godoc
+// @Summary reindex users
+// @Description copies the user index into a new index with the current mapping and moves user_alias to it. Writes stay open during the copy; the changes made meanwhile are copied while writes are blocked for a moment before the alias moves, and writes hitting that block get 503 with Retry-After. With wait_for_completion=false the copy runs as a task and the alias moves once it completed.
+// @Tags index
+// @Accept json
+// @Param wait_for_completion query bool false "false returns a task to follow at /tasks/{id} right away"
+// @Success 200 {object} model.ReindexResponse
+// @Success 202 {object} model.TaskResponse
+// @Router /admin/reindex [post]
+func (endpoint *indexEndpoint) Reindex() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		wait, err := helper.ParseWaitForCompletion(context)
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusBadRequest,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		if !wait {
+			task, err := endpoint.indexService.StartReindex(context)
+			if err != nil {
+				helper.HandleEndpointError(context, &model.ResponseError{
+					StatusCode: http.StatusInternalServerError,
+					Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+				})
+				return
+			}
+
+			context.JSON(http.StatusAccepted, task)
+			return
+		}
+
+		response, err := endpoint.indexService.Reindex(context)
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusInternalServerError,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// Rollback godoc
+// @Summary rollback user index
+// @Description moves user_alias back to an index kept by a previous reindex
+// @Tags index
+// @Accept json
+// @Param body body model.RollbackRequest true "RollbackRequest"
+// @Success 204
+// @Router /admin/rollback [post]
+func (endpoint *indexEndpoint) Rollback() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var requestBody model.RollbackRequest
+
+		if err := context.BindJSON(&requestBody); err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: 400,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		err := endpoint.indexService.Rollback(context, requestBody)
+
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.Status(model.StatusNoContent)
+	}
+}
+
+// BackfillAuditFields godoc
+// @Summary backfill audit fields
+// @Description fills created_by, updated_by and updated_at of users written before the audit fields existed: created_by becomes system, updated_by the creator and updated_at the creation time. Runs as a task; users that have the fields are left untouched.
+// @Tags index
+// @Produce json
+// @Success 202 {object} model.TaskResponse
+// @Router /admin/backfill-audit-fields [post]
+func (endpoint *indexEndpoint) BackfillAuditFields() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		task, err := endpoint.indexService.StartAuditBackfill(context)
+
+		if err != nil {
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: http.StatusInternalServerError,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusAccepted, task)
+	}
+}
@@ -2,6 +2,7 @@ package rest
 
 import (
 	"elastic-project/interface/rest/docs"
+	"elastic-project/interface/rest/helper"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -20,6 +21,10 @@ import (
 
 type server struct {
 	elasticsearchEndpoint ElasticsearchEndpoint
+	indexEndpoint         IndexEndpoint
+	importEndpoint        ImportEndpoint
+	historyEndpoint       HistoryEndpoint
+	taskEndpoint          TaskEndpoint
 }
 
 type Server interface {
@@ -27,27 +32,73 @@ type Server interface {
 }
 
 func NewServer(
-	elasticsearchEndpoint ElasticsearchEndpoint) Server {
+	elasticsearchEndpoint ElasticsearchEndpoint,
+	indexEndpoint IndexEndpoint,
+	importEndpoint ImportEndpoint,
+	historyEndpoint HistoryEndpoint,
+	taskEndpoint TaskEndpoint) Server {
 	return &server{
 		elasticsearchEndpoint: elasticsearchEndpoint,
+		indexEndpoint:         indexEndpoint,
+		importEndpoint:        importEndpoint,
+		historyEndpoint:       historyEndpoint,
+		taskEndpoint:          taskEndpoint,
 	}
 }
 
 func (server *server) SetupRouter() *gin.Engine {
 	router := gin.New()
-	router.Use(gin.Recovery())
+	router.ContextWithFallback = true
+	router.Use(gin.CustomRecovery(helper.HandleRecovery))
 	router.Use(gzip.Gzip(gzip.BestCompression))
+	router.Use(helper.ActorMiddleware())
 	gin.SetMode(gin.ReleaseMode)
 
 	//setUpNewRelic(router, server.newRelicConfig)
 
 	if server.elasticsearchEndpoint != nil {
 		router.PUT("/users/:id", server.elasticsearchEndpoint.Update())
+		router.PATCH("/users/:id", server.elasticsearchEndpoint.Patch())
+		router.POST("/users/:id/children", server.elasticsearchEndpoint.AddChild())
+		router.DELETE("/users/:id/children/:name", server.elasticsearchEndpoint.RemoveChild())
 		router.POST("/users", server.elasticsearchEndpoint.Create())
+		router.POST("/users/_bulk", server.elasticsearchEndpoint.Bulk())
+		router.POST("/users/_update_by_query", server.elasticsearchEndpoint.UpdateByQuery())
+		router.POST("/users/_delete_by_query", server.elasticsearchEndpoint.DeleteByQuery())
 		router.GET("/users", server.elasticsearchEndpoint.Find())
+		router.GET("/users/_export", server.elasticsearchEndpoint.Export())
+		router.GET("/users/_facets", server.elasticsearchEndpoint.Facets())
+		router.POST("/users/_facets", server.elasticsearchEndpoint.SearchFacets())
+		router.GET("/users/_suggest", server.elasticsearchEndpoint.Suggest())
 		router.GET("/users-by", server.elasticsearchEndpoint.FindByKeyAndValue())
 		router.GET("/users-by-query", server.elasticsearchEndpoint.FindByJsonQuery())
+		router.GET("/users/search", server.elasticsearchEndpoint.Search())
+		router.POST("/users/_search", server.elasticsearchEndpoint.SearchUsers())
 		router.DELETE("/users/:id", server.elasticsearchEndpoint.Delete())
+		router.POST("/users/:id/restore", server.elasticsearchEndpoint.Restore())
+	}
+
+	if server.indexEndpoint != nil {
+		router.POST("/admin/reindex", server.indexEndpoint.Reindex())
+		router.POST("/admin/rollback", server.indexEndpoint.Rollback())
+		router.POST("/admin/backfill-audit-fields", server.indexEndpoint.BackfillAuditFields())
+	}
+
+	if server.importEndpoint != nil {
+		router.POST("/users/_import", server.importEndpoint.Start())
+		router.GET("/imports/:id", server.importEndpoint.Find())
+		router.GET("/imports/:id/errors", server.importEndpoint.ErrorReport())
+	}
+
+	if server.historyEndpoint != nil {
+		router.GET("/users/:id/history", server.historyEndpoint.History())
+		router.GET("/users/:id/history/:rev", server.historyEndpoint.Revision())
+		router.POST("/users/:id/history/:rev/restore", server.historyEndpoint.Restore())
+	}
+
+	if server.taskEndpoint != nil {
+		router.GET("/tasks/:id", server.taskEndpoint.Find())
+		router.POST("/tasks/:id/cancel", server.taskEndpoint.Cancel())
 	}
 
 	//if server.healthEndpoint != nil {
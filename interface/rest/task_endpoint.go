@@ -0,0 +1,84 @@
+package rest
+
+import (
+	"elastic-project/application/task_operation"
+	"elastic-project/interface/rest/helper"
+	"elastic-project/model"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type taskEndpoint struct {
+	taskService task_operation.Service
+}
+
+type TaskEndpoint interface {
+	Find() gin.HandlerFunc
+	Cancel() gin.HandlerFunc
+}
+
+func NewTaskEndpoint(taskService task_operation.Service) TaskEndpoint {
+	return &taskEndpoint{taskService: taskService}
+}
+
+// Find godoc
+// @Summary gets task
+// @Description shows the progress and outcome of a reindex, update by query or delete by query started with wait_for_completion=false
+// @Tags tasks
+// @Param id path string true "task id"
+// @Success 200 {object} model.TaskResponse
+// @Failure 404 {object} model.ErrorDto
+// @Router /tasks/{id} [get]
+func (endpoint *taskEndpoint) Find() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		response, err := endpoint.taskService.Find(context, context.Param("id"))
+
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+// Cancel godoc
+// @Summary cancels task
+// @Description cancels a running task. A cancelled reindex leaves the alias where it was.
+// @Tags tasks
+// @Param id path string true "task id"
+// @Success 200 {object} model.TaskResponse
+// @Failure 404 {object} model.ErrorDto
+// @Failure 409 {object} model.ErrorDto "task already finished"
+// @Router /tasks/{id}/cancel [post]
+func (endpoint *taskEndpoint) Cancel() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		response, err := endpoint.taskService.Cancel(context, context.Param("id"))
+
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if model.ErrNotFound == err {
+				statusCode = http.StatusNotFound
+			}
+			if model.ErrConflict == err {
+				statusCode = http.StatusConflict
+			}
+			helper.HandleEndpointError(context, &model.ResponseError{
+				StatusCode: statusCode,
+				Err:        errors.New(fmt.Sprintf("invalid request: Error: %v", err.Error())),
+			})
+			return
+		}
+
+		context.JSON(http.StatusOK, response)
+	}
+}
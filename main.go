@@ -3,8 +3,14 @@ package main
 import (
 	"context"
 	"elastic-project/application/elastic_operation"
+	"elastic-project/application/history_operation"
+	"elastic-project/application/import_operation"
+	"elastic-project/application/index_operation"
+	"elastic-project/application/task_operation"
+	"elastic-project/application/trash_operation"
 	"elastic-project/client/elasticsearch"
 	"elastic-project/interface/rest"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -12,24 +18,85 @@ import (
 	"time"
 )
 
+// purgeInterval is how often soft deleted users past their retention are
+// removed.
+const purgeInterval = time.Hour
+
+// taskPollInterval is how often tasks started without waiting are checked,
+// which finishes a reindex that completed.
+const taskPollInterval = 10 * time.Second
+
 func main() {
 
 	gracefulShutdown := createGracefulShutdownChannel()
 
-	elastic, err := elasticsearch.New([]string{"http://0.0.0.0:9200"})
-	if err != nil {
-		log.Fatalln(err)
+	icu := flag.Bool("icu", false, "analyze user text fields with the ICU analyzer (needs the analysis-icu plugin)")
+	validateQueries := flag.Bool("validate-queries", false, "check raw /users-by-query queries with _validate/query before running them")
+	softDelete := flag.Bool("soft-delete", false, "mark deleted users with deleted_at instead of removing them")
+	memory := flag.Bool("memory", false, "keep users in memory instead of Elasticsearch; imports, reindex and tasks are not available")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "how long soft deleted users are kept before they are purged")
+	flag.Parse()
+
+	analysis := elasticsearch.AnalysisConfig{}
+	if *icu {
+		analysis.FieldAnalyzers = map[string]string{
+			"name":       elasticsearch.AnalyzerTurkishICU,
+			"job":        elasticsearch.AnalyzerTurkishICU,
+			"childNames": elasticsearch.AnalyzerTurkishICU,
+			"comment":    elasticsearch.AnalyzerTurkishICU,
+		}
 	}
-	if err := elastic.CreateIndex("user"); err != nil {
-		log.Fatalln(err)
+
+	var (
+		storage        elasticsearch.UserInfoStorer
+		historyStorage elasticsearch.HistoryStorer
+		taskService    task_operation.Service
+		indexEndpoint  rest.IndexEndpoint
+		importEndpoint rest.ImportEndpoint
+		taskEndpoint   rest.TaskEndpoint
+	)
+
+	if *memory {
+		memoryStorage := elasticsearch.NewMemoryStorage()
+		storage = memoryStorage
+		historyStorage = memoryStorage
+	} else {
+		elastic := setUpElasticsearch(analysis)
+
+		storage = elasticsearch.NewUserInfoStorage(*elastic)
+		historyStorage = elasticsearch.NewHistoryStorage(*elastic)
+
+		taskStorage := elasticsearch.NewTaskStorage(*elastic)
+		taskService = task_operation.NewTaskService(taskStorage, elastic, taskPollInterval)
+		go taskService.Run(context.Background())
+		taskEndpoint = rest.NewTaskEndpoint(taskService)
+
+		indexService := index_operation.NewIndexService(elastic, taskService)
+		indexEndpoint = rest.NewIndexEndpoint(indexService)
+
+		importStorage := elasticsearch.NewImportJobStorage(*elastic)
+		importService := import_operation.NewImportService(importStorage, storage, elastic, "imports")
+		if err := importService.Resume(context.Background()); err != nil {
+			log.Println(err)
+		}
+		importEndpoint = rest.NewImportEndpoint(importService)
 	}
 
-	storage := elasticsearch.NewUserInfoStorage(*elastic)
+	queryPolicy := elastic_operation.DefaultQueryPolicy()
+	queryPolicy.Validate = *validateQueries
 
-	elasticsearchService := elastic_operation.NewElasticsearchService(storage)
+	elasticsearchService := elastic_operation.NewElasticsearchService(storage, taskService, queryPolicy, *softDelete)
 	elasticsearchEndpoint := rest.NewElasticsearchEndpoint(elasticsearchService)
 
-	server := rest.NewServer(elasticsearchEndpoint)
+	if *softDelete {
+		purgeService := trash_operation.NewPurgeService(storage, *trashRetention, purgeInterval)
+		go purgeService.Run(context.Background())
+	}
+
+	historyService := history_operation.NewHistoryService(historyStorage, storage)
+	historyEndpoint := rest.NewHistoryEndpoint(historyService)
+
+	server := rest.NewServer(elasticsearchEndpoint, indexEndpoint, importEndpoint, historyEndpoint, taskEndpoint)
 
 	router := server.SetupRouter()
 	_ = router.Run(":8084")
@@ -42,6 +109,43 @@ func main() {
 
 }
 
+// setUpElasticsearch connects to the cluster and prepares the user index and
+// the indices the application keeps next to it.
+func setUpElasticsearch(analysis elasticsearch.AnalysisConfig) *elasticsearch.ElasticSearch {
+	elastic, err := elasticsearch.New([]string{"http://0.0.0.0:9200"}, analysis)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := elastic.CreateIndex("user"); err != nil {
+		log.Fatalln(err)
+	}
+	if err := elastic.MigrateAuditFields(context.Background()); err != nil {
+		log.Fatalln(err)
+	}
+	if err := elastic.MigrateAddedFields(context.Background()); err != nil {
+		log.Fatalln(err)
+	}
+	drift, err := elastic.CheckMapping()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, d := range drift {
+		log.Printf("user index mapping drift (expected version %d): %s", elasticsearch.UserMappingVersion, d)
+	}
+
+	if err := elastic.CreateImportIndices(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := elastic.CreateHistoryIndex(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := elastic.CreateTaskIndex(); err != nil {
+		log.Fatalln(err)
+	}
+
+	return elastic
+}
+
 func createGracefulShutdownChannel() chan os.Signal {
 	gracefulShutdown := make(chan os.Signal, 1)
 	signal.Notify(gracefulShutdown, syscall.SIGTERM)
@@ -0,0 +1,28 @@
+package model
+
+import "context"
+
+const (
+	// AnonymousActor is recorded when a request carries no identity.
+	AnonymousActor = "anonymous"
+	// SystemActor is recorded for changes made by the application itself,
+	// like migrations.
+	SystemActor = "system"
+)
+
+type actorKey struct{}
+
+// ContextWithActor returns a copy of ctx carrying the identity of the caller,
+// which is recorded in created_by and updated_by.
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the identity stored by ContextWithActor, or
+// AnonymousActor if there is none.
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return AnonymousActor
+}
@@ -5,6 +5,20 @@ import "errors"
 var (
 	ErrNotFound = errors.New("not found")
 	ErrConflict = errors.New("conflict")
+	// ErrWriteBlocked is returned for writes while a reindex blocks them
+	// before moving the alias. Retrying shortly after succeeds.
+	ErrWriteBlocked = errors.New("writes are blocked by a reindex")
+
+	ErrInvalidPage   = errors.New("invalid page")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidQuery  = errors.New("invalid query")
+	ErrInvalidFormat = errors.New("invalid format")
+
+	ErrUnsupportedPatch = errors.New("unsupported patch format")
+	ErrInvalidPatch     = errors.New("invalid patch")
+	ErrPatchFailed      = errors.New("patch cannot be applied")
+
+	ErrNotSupported = errors.New("not supported by this storage")
 )
 
 const (
@@ -1,8 +1,10 @@
 package model
 
+import "encoding/json"
+
 type CreateRequest struct {
-	Name       string   `json:"name"`
-	Job        string   `json:"job"`
+	Name       string   `json:"name" binding:"required"`
+	Job        string   `json:"job" binding:"required"`
 	ChildNames []string `json:"childNames"`
 	Comment    string   `json:"comment"`
 }
@@ -12,18 +14,182 @@ type UpdateRequest struct {
 	Job        string   `json:"job"`
 	ChildNames []string `json:"childNames"`
 	Comment    string   `json:"comment"`
+	Version    string   `json:"-"`
+}
+
+// PatchRequest holds a JSON Merge Patch or JSON Patch document, told apart by
+// ContentType, for the name, job, childNames and comment of a user.
+type PatchRequest struct {
+	ContentType string
+	Patch       []byte
+	Version     string
+}
+
+type ChildRequest struct {
+	Name string `json:"name" binding:"required"`
 }
 
 type DeleteRequest struct {
-	ID string
+	ID      string
+	Version string
 }
 
 type FindRequest struct {
-	ID string
+	ID             string
+	IncludeDeleted bool
+}
+
+type PageRequest struct {
+	Page   int    `json:"page"`
+	Size   int    `json:"size"`
+	Cursor string `json:"cursor"`
+}
+
+type HighlightRequest struct {
+	Highlight    bool   `json:"highlight"`
+	PreTag       string `json:"highlightPreTag"`
+	PostTag      string `json:"highlightPostTag"`
+	FragmentSize int    `json:"fragmentSize"`
 }
 
+// FindByRequest searches with one QueryType/Key/Value condition, with a list
+// of Conditions, or with both; the single condition then counts as must.
 type FindByRequest struct {
+	QueryType          string      `json:"queryType"`
+	Key                string      `json:"key"`
+	Value              string      `json:"value"`
+	Conditions         []Condition `json:"conditions"`
+	MinimumShouldMatch string      `json:"minimumShouldMatch"`
+	IncludeDeleted     bool        `json:"includeDeleted"`
+	PageRequest
+	HighlightRequest
+}
+
+// Condition is one leaf query of a compound search. Occur is must, should,
+// must_not or filter.
+type Condition struct {
+	Occur     string `json:"occur"`
 	QueryType string `json:"queryType"`
 	Key       string `json:"key"`
 	Value     string `json:"value"`
 }
+
+type FindByQueryRequest struct {
+	Query          string `json:"jsonQuery"`
+	IncludeDeleted bool   `json:"includeDeleted"`
+	PageRequest
+	HighlightRequest
+}
+
+type SearchQueryRequest struct {
+	Query          string `json:"q"`
+	IncludeDeleted bool   `json:"includeDeleted"`
+	PageRequest
+	HighlightRequest
+}
+
+// SearchRequest is the body of POST /users/_search. The free text query is
+// matched against name, job, comment and childNames; filters narrow the
+// result without affecting the score.
+type SearchRequest struct {
+	Query   string         `json:"query" example:"engineer"`
+	Filters []SearchFilter `json:"filters" binding:"dive"`
+	Sort    []SearchSort   `json:"sort" binding:"dive"`
+	Fields  []string       `json:"fields" example:"id,name,job"`
+	// IncludeDeleted also returns soft deleted users.
+	IncludeDeleted bool `json:"includeDeleted"`
+	PageRequest
+	HighlightRequest
+}
+
+// SearchFilter restricts field to documents matching exactly one of term,
+// terms, range, exists or prefix.
+type SearchFilter struct {
+	Field  string       `json:"field" binding:"required" example:"job"`
+	Term   *string      `json:"term,omitempty"`
+	Terms  []string     `json:"terms,omitempty"`
+	Range  *SearchRange `json:"range,omitempty"`
+	Exists *bool        `json:"exists,omitempty"`
+	Prefix *string      `json:"prefix,omitempty"`
+}
+
+type SearchRange struct {
+	GT  string `json:"gt,omitempty"`
+	GTE string `json:"gte,omitempty" example:"2022-01-01"`
+	LT  string `json:"lt,omitempty"`
+	LTE string `json:"lte,omitempty"`
+}
+
+type SearchSort struct {
+	Field string `json:"field" binding:"required" example:"created_at"`
+	Order string `json:"order" binding:"omitempty,oneof=asc desc" example:"desc"`
+}
+
+type RollbackRequest struct {
+	Index string `json:"index"`
+}
+
+type BulkActionMeta struct {
+	ID string `json:"_id"`
+}
+
+// BulkUpdateDocument is the document line of a bulk update. Doc holds the
+// fields to change, as in UpdateRequest; fields left out are kept.
+type BulkUpdateDocument struct {
+	Doc map[string]json.RawMessage `json:"doc"`
+}
+
+type ExportRequest struct {
+	Format         string `json:"format"`
+	Query          string `json:"jsonQuery"`
+	IncludeDeleted bool   `json:"includeDeleted"`
+}
+
+// UpdateByQueryRequest is the body of POST /users/_update_by_query. Query and
+// Filters select users like in SearchRequest; Set assigns name, job, comment
+// or childNames on all of them.
+type UpdateByQueryRequest struct {
+	Query             string                 `json:"query"`
+	Filters           []SearchFilter         `json:"filters" binding:"dive"`
+	Set               map[string]interface{} `json:"set" binding:"required"`
+	DryRun            bool                   `json:"-"`
+	RequestsPerSecond int                    `json:"-"`
+}
+
+// DeleteByQueryRequest is the body of POST /users/_delete_by_query.
+type DeleteByQueryRequest struct {
+	Query             string         `json:"query"`
+	Filters           []SearchFilter `json:"filters" binding:"dive"`
+	DryRun            bool           `json:"-"`
+	RequestsPerSecond int            `json:"-"`
+}
+
+type ImportRequest struct {
+	Format   string
+	FileName string
+}
+
+// FacetsRequest selects the users to count like GET /users-by, with
+// QueryType/Key/Value and Conditions, and like POST /users/_search, with
+// Query and Filters. Both selections may be combined; without any every user
+// is counted.
+type FacetsRequest struct {
+	QueryType          string         `json:"-"`
+	Key                string         `json:"-"`
+	Value              string         `json:"-"`
+	Conditions         []Condition    `json:"-"`
+	MinimumShouldMatch string         `json:"-"`
+	Query              string         `json:"query" example:"engineer"`
+	Filters            []SearchFilter `json:"filters" binding:"dive"`
+	Interval           string         `json:"interval" example:"week"`
+	JobSize            int            `json:"jobSize" example:"10"`
+	// IncludeDeleted also counts soft deleted users.
+	IncludeDeleted bool `json:"includeDeleted"`
+}
+
+type SuggestRequest struct {
+	Field  string `json:"field"`
+	Prefix string `json:"prefix"`
+	Job    string `json:"job"`
+	Size   int    `json:"size"`
+}
@@ -7,10 +7,159 @@ type CreateResponse struct {
 }
 
 type FindResponse struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name"`
+	Job        string              `json:"job"`
+	ChildNames []string            `json:"childNames"`
+	Comment    string              `json:"comment"`
+	CreatedAt  *time.Time          `json:"created_at"`
+	UpdatedAt  *time.Time          `json:"updated_at"`
+	CreatedBy  string              `json:"created_by"`
+	UpdatedBy  string              `json:"updated_by"`
+	Revision   int64               `json:"revision"`
+	DeletedAt  *time.Time          `json:"deleted_at,omitempty"`
+	Version    string              `json:"-"`
+	DocumentID string              `json:"_id,omitempty"`
+	Score      *float64            `json:"_score,omitempty"`
+	Sort       []interface{}       `json:"_sort,omitempty"`
+	Highlight  map[string][]string `json:"highlight,omitempty"`
+}
+
+type FindListResponse struct {
+	Total      int64          `json:"total"`
+	Page       int            `json:"page,omitempty"`
+	Size       int            `json:"size"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	TimedOut   bool           `json:"timed_out"`
+	Shards     ShardsResponse `json:"_shards"`
+	Items      []FindResponse `json:"items"`
+}
+
+type ShardsResponse struct {
+	Total      int                    `json:"total"`
+	Successful int                    `json:"successful"`
+	Skipped    int                    `json:"skipped"`
+	Failed     int                    `json:"failed"`
+	Failures   []ShardFailureResponse `json:"failures,omitempty"`
+}
+
+type ShardFailureResponse struct {
+	Index  string `json:"index"`
+	Shard  int    `json:"shard"`
+	Node   string `json:"node"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type ReindexResponse struct {
+	Source         string `json:"source"`
+	Destination    string `json:"destination"`
+	Documents      int64  `json:"documents"`
+	MappingVersion int    `json:"mapping_version"`
+}
+
+type BulkResponse struct {
+	Errors bool               `json:"errors"`
+	Items  []BulkItemResponse `json:"items"`
+}
+
+type BulkItemResponse struct {
+	Action string `json:"action,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportJobResponse struct {
 	ID         string     `json:"id"`
-	Name       string     `json:"name"`
-	Job        string     `json:"job"`
-	ChildNames []string   `json:"childNames"`
-	Comment    string     `json:"comment"`
-	CreatedAt  *time.Time `json:"created_at"`
+	Status     string     `json:"status"`
+	Format     string     `json:"format"`
+	FileName   string     `json:"file_name"`
+	Processed  int        `json:"processed"`
+	Indexed    int        `json:"indexed"`
+	Rejected   int        `json:"rejected"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type ImportErrorResponse struct {
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
+	Raw    string `json:"raw"`
+}
+
+type FacetsResponse struct {
+	Total      int64                     `json:"total"`
+	Jobs       []TermBucketResponse      `json:"jobs"`
+	CreatedAt  []DateBucketResponse      `json:"created_at"`
+	ChildCount []HistogramBucketResponse `json:"child_count"`
+}
+
+type TermBucketResponse struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+type DateBucketResponse struct {
+	Key   time.Time `json:"key"`
+	Count int64     `json:"count"`
+}
+
+type HistogramBucketResponse struct {
+	Key   float64 `json:"key"`
+	Count int64   `json:"count"`
+}
+
+type SuggestionResponse struct {
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
+
+type HistoryResponse struct {
+	Revision  int64        `json:"revision"`
+	Action    string       `json:"action"`
+	Actor     string       `json:"actor"`
+	Timestamp *time.Time   `json:"timestamp"`
+	User      FindResponse `json:"user"`
+}
+
+// ByQueryResponse reports an update or delete by query. A dry run only
+// counts the matching users in Total and returns some of them in Sample.
+type ByQueryResponse struct {
+	DryRun           bool           `json:"dry_run"`
+	Total            int64          `json:"total"`
+	Updated          int64          `json:"updated"`
+	Deleted          int64          `json:"deleted"`
+	VersionConflicts int64          `json:"version_conflicts"`
+	TimedOut         bool           `json:"timed_out"`
+	Failures         []string       `json:"failures,omitempty"`
+	Sample           []FindResponse `json:"sample,omitempty"`
+}
+
+// TaskResponse shows a task started with wait_for_completion=false. Status
+// is running, completed, failed or cancelled.
+type TaskResponse struct {
+	ID          string               `json:"id"`
+	Kind        string               `json:"kind"`
+	Status      string               `json:"status"`
+	Source      string               `json:"source,omitempty"`
+	Destination string               `json:"destination,omitempty"`
+	Progress    TaskProgressResponse `json:"progress"`
+	Failures    []string             `json:"failures,omitempty"`
+	Error       string               `json:"error,omitempty"`
+	CreatedBy   string               `json:"created_by,omitempty"`
+	CreatedAt   *time.Time           `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time           `json:"updated_at,omitempty"`
+	FinishedAt  *time.Time           `json:"finished_at,omitempty"`
+}
+
+type TaskProgressResponse struct {
+	Total            int64 `json:"total"`
+	Created          int64 `json:"created"`
+	Updated          int64 `json:"updated"`
+	Deleted          int64 `json:"deleted"`
+	VersionConflicts int64 `json:"version_conflicts"`
 }